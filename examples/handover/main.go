@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/hedzr/go-socketlib/tcp"
+	"github.com/hedzr/log"
+	"os"
+	"syscall"
+)
+
+var addr = flag.String("addr", ":1986", "listening address")
+
+// A line echo server restarting itself on SIGHUP without refusing
+// any connection:
+//
+//	kill -HUP <pid>
+//
+// The new process inherits the listening socket, and the old one
+// exits after its connections are finished. The other signals stop
+// the server.
+func main() {
+	flag.Parse()
+
+	s, err := tcp.StartServer(*addr,
+		tcp.WithServerListenInheritedFD(true),
+		tcp.WithServerCodec(tcp.NewLineCodec(0)),
+		tcp.WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out tcp.MessageWriter) error {
+			return out.WriteMessage([]byte(fmt.Sprintf("[%d] %s", os.Getpid(), msg)))
+		}),
+	)
+	if err != nil {
+		log.Fatalf("starting server failed: %v", err)
+	}
+	log.Printf("pid %d serving", os.Getpid())
+
+	for running := true; running; {
+		tcp.HandleSignals(func(sig os.Signal) {
+			if sig == syscall.SIGHUP {
+				p, err := s.Handover()
+				if err != nil {
+					log.Errorf("restarting failed, keep serving: %v", err)
+					return
+				}
+				log.Printf("pid %d took over, draining", p.Pid)
+			}
+			running = false
+		})()
+	}
+	s.Stop()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"github.com/hedzr/go-socketlib/tcp"
+	"github.com/hedzr/log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	addr  = flag.String("addr", "127.0.0.1:1984", "the address shared by the servers")
+	count = flag.Int("count", 32, "the connections to dial")
+)
+
+// Two servers listen on the same address with SO_REUSEPORT, and the
+// kernel balances the incoming connections between them.
+func main() {
+	flag.Parse()
+
+	var accepted [2]int32
+	var wg sync.WaitGroup
+	wg.Add(*count)
+
+	var servers []*tcp.Server
+	for i := range accepted {
+		n := &accepted[i]
+		s, err := tcp.StartServer(*addr,
+			tcp.WithServerReusePort(true),
+			tcp.WithServerOnConnect(func(info tcp.ConnInfo) {
+				atomic.AddInt32(n, 1)
+				wg.Done()
+			}),
+		)
+		if err != nil {
+			log.Fatalf("starting server failed: %v", err)
+		}
+		servers = append(servers, s)
+	}
+	defer func() {
+		for _, s := range servers {
+			s.Stop()
+		}
+	}()
+
+	for i := 0; i < *count; i++ {
+		conn, err := net.DialTimeout("tcp", *addr, time.Second)
+		if err != nil {
+			log.Fatalf("dial failed: %v", err)
+		}
+		defer conn.Close()
+	}
+	wg.Wait()
+
+	for i := range accepted {
+		log.Printf("server #%d accepted %d connections", i, atomic.LoadInt32(&accepted[i]))
+	}
+	if accepted[0] == 0 || accepted[1] == 0 {
+		log.Warnf("the connections were not balanced, it's up to the kernel")
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"flag"
+	"github.com/hedzr/go-socketlib/tcp"
+	"github.com/hedzr/log"
+	"os"
+	"syscall"
+)
+
+var (
+	addr = flag.String("addr", ":1985", "listening address")
+	cert = flag.String("cert", "./ci/certs/server.pem", "the certificate file")
+	key  = flag.String("key", "./ci/certs/server.key", "the private key file")
+)
+
+// A TLS server reloading its certificate on SIGHUP, such as from the
+// deploy hook of certbot:
+//
+//	kill -HUP <pid>
+//
+// The other signals stop the server.
+func main() {
+	flag.Parse()
+
+	s, err := tcp.StartServer(*addr, tcp.WithServerTLSFiles(*cert, *key))
+	if err != nil {
+		log.Fatalf("starting server failed: %v", err)
+	}
+	defer s.Stop()
+
+	for running := true; running; {
+		// HandleSignals traps one signal each time, so re-arm it
+		// after a reloading.
+		tcp.HandleSignals(func(sig os.Signal) {
+			if sig != syscall.SIGHUP {
+				running = false
+				return
+			}
+			if err := s.ReloadCert(*cert, *key); err != nil {
+				log.Errorf("reloading certificate failed, keep the old one: %v", err)
+			}
+		})()
+	}
+}
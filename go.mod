@@ -8,8 +8,6 @@ go 1.13
 
 // replace github.com/hedzr/logex => ../logex
 
-// replace github.com/hedzr/go-ringbuf => ../go-ringbuf
-
 // replace github.com/hedzr/rules => ../rules
 
 // replace github.com/hedzr/pools => ../pools
@@ -19,8 +17,10 @@ go 1.13
 require (
 	github.com/hedzr/cmdr v1.7.31
 	github.com/hedzr/cmdr-addons v1.7.31
-	github.com/hedzr/go-ringbuf v0.8.9
 	github.com/hedzr/log v0.2.3
 	github.com/hedzr/logex v1.2.17
+	github.com/prometheus/client_golang v1.11.1
+	github.com/xtaci/kcp-go/v5 v5.6.2
+	golang.org/x/net v0.1.0 // indirect
 	gopkg.in/hedzr/errors.v2 v2.1.1
 )
@@ -0,0 +1,216 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchTransfer moves b.N items from the producers to one consumer
+// through q.
+func benchTransfer(b *testing.B, q RingBuffer, producers int) {
+	per := b.N/producers + 1
+	done := make(chan struct{})
+	b.ResetTimer()
+	go func() {
+		defer close(done)
+		for n := 0; n < per*producers; {
+			if _, err := q.Dequeue(); err == nil {
+				n++
+			} else {
+				runtime.Gosched()
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < per; i++ {
+				enqueue(q, i)
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+}
+
+// BenchmarkSPSC compares NewSPSC with New under a single producer
+// and a single consumer.
+func BenchmarkSPSC(b *testing.B) {
+	b.Run("New", func(b *testing.B) { benchTransfer(b, New(1024), 1) })
+	b.Run("NewSPSC", func(b *testing.B) { benchTransfer(b, NewSPSC(1024), 1) })
+}
+
+// BenchmarkWaitStrategy runs 4 producers against one consumer on a
+// small queue. The 1ns SleepBackoff stands for the fixed sleep used
+// before the wait strategies.
+func BenchmarkWaitStrategy(b *testing.B) {
+	for _, ws := range []struct {
+		name string
+		ws   WaitStrategy
+	}{
+		{"Sleep1ns", SleepBackoff{Min: time.Nanosecond, Max: time.Nanosecond}},
+		{"BusySpin", BusySpin{}},
+		{"Yield", Yield{}},
+		{"SleepBackoff", SleepBackoff{Min: time.Microsecond, Max: maxBackoff}},
+		{"Default", DefaultWaitStrategy},
+	} {
+		b.Run(ws.name, func(b *testing.B) { benchTransfer(b, New(16, WithWaitStrategy(ws.ws)), 4) })
+	}
+}
+
+// BenchmarkBlockingMode compares WithBlockingMode with the lock-free
+// queue under the low and the high contention.
+func BenchmarkBlockingMode(b *testing.B) {
+	for _, producers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("lock-free/%dp", producers), func(b *testing.B) { benchTransfer(b, New(64), producers) })
+		b.Run(fmt.Sprintf("blocking/%dp", producers), func(b *testing.B) { benchTransfer(b, New(64, WithBlockingMode(true)), producers) })
+	}
+}
+
+// benchBlocking is benchTransfer waiting by BlockingEnqueue and
+// BlockingDequeue.
+func benchBlocking(b *testing.B, q RingBuffer, producers int) {
+	per := b.N/producers + 1
+	done := make(chan struct{})
+	b.ResetTimer()
+	go func() {
+		defer close(done)
+		for n := 0; n < per*producers; n++ {
+			if _, err := q.BlockingDequeue(context.Background()); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < per; i++ {
+				if err := q.BlockingEnqueue(context.Background(), i); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+}
+
+// BenchmarkSpinsBeforeSleep waits on a small queue across the
+// contention levels, 0 spins sleeps at once as it did before.
+func BenchmarkSpinsBeforeSleep(b *testing.B) {
+	for _, producers := range []int{1, 4, 16} {
+		for _, spins := range []int{0, spinsBeforeSleep, 300} {
+			b.Run(fmt.Sprintf("%dp/spins=%d", producers, spins), func(b *testing.B) {
+				benchBlocking(b, New(8, WithSpinsBeforeSleep(spins)), producers)
+			})
+		}
+	}
+}
+
+// BenchmarkTryEnqueue puts and takes on a tiny queue from the parallel
+// goroutines, so that it's often full or empty, by the error-returning
+// Enqueue/Dequeue and by TryEnqueue/TryDequeue. Run it with -benchmem.
+func BenchmarkTryEnqueue(b *testing.B) {
+	b.Run("Enqueue", func(b *testing.B) {
+		q := New(4)
+		b.ReportAllocs()
+		b.SetParallelism(4)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = q.Enqueue(1)
+				_, _ = q.Dequeue()
+			}
+		})
+	})
+	b.Run("TryEnqueue", func(b *testing.B) {
+		q := New(4)
+		b.ReportAllocs()
+		b.SetParallelism(4)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = q.TryEnqueue(1)
+				_, _ = q.TryDequeue()
+			}
+		})
+	})
+}
+
+// BenchmarkFairScheduling reports the latency distribution of the
+// enqueues, including the retries on a full queue, of 64 producers
+// with and without WithFairScheduling.
+func BenchmarkFairScheduling(b *testing.B) {
+	const producers = 64
+	for _, fair := range []bool{false, true} {
+		b.Run(fmt.Sprintf("fair=%v", fair), func(b *testing.B) {
+			q := New(64, WithFairScheduling(fair))
+			per := b.N/producers + 1
+			lat := make([][]time.Duration, producers)
+			done := make(chan struct{})
+			b.ResetTimer()
+			go func() {
+				defer close(done)
+				for n := 0; n < per*producers; {
+					if _, ok := q.TryDequeue(); ok {
+						n++
+					} else {
+						runtime.Gosched()
+					}
+				}
+			}()
+			var wg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					lat[p] = make([]time.Duration, 0, per)
+					for i := 0; i < per; i++ {
+						t := time.Now()
+						enqueue(q, i)
+						lat[p] = append(lat[p], time.Since(t))
+					}
+				}(p)
+			}
+			wg.Wait()
+			<-done
+			b.StopTimer()
+
+			var all []time.Duration
+			for _, l := range lat {
+				all = append(all, l...)
+			}
+			sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+			for _, quantile := range []float64{0.5, 0.99, 0.999} {
+				b.ReportMetric(float64(all[int(quantile*float64(len(all)-1))]), fmt.Sprintf("p%v-ns", quantile*100))
+			}
+		})
+	}
+}
+
+// BenchmarkLatencyTracking measures the overhead of
+// WithLatencyTracking on an enqueue and a dequeue.
+func BenchmarkLatencyTracking(b *testing.B) {
+	for _, enabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("enabled=%v", enabled), func(b *testing.B) {
+			q := New(1024, WithLatencyTracking(enabled))
+			for i := 0; i < b.N; i++ {
+				_ = q.Enqueue(i)
+				_, _ = q.Dequeue()
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+func (rb *ringBuf) BlockingEnqueue(ctx context.Context, item interface{}) (err error) {
+	return blockingEnqueue(ctx, rb, &rb.putWaits, rb.spins, item)
+}
+
+func (rb *ringBuf) BlockingDequeue(ctx context.Context) (item interface{}, err error) {
+	return blockingDequeue(ctx, rb, &rb.getWaits, rb.spins)
+}
+
+func (rb *ringBuf) PutTimeout(item interface{}, d time.Duration) (err error) {
+	return putTimeout(rb, item, d)
+}
+
+func (rb *ringBuf) GetTimeout(d time.Duration) (item interface{}, err error) {
+	return getTimeout(rb, d)
+}
+
+func blockingEnqueue(ctx context.Context, q Queue, waits *uint64, spins int, item interface{}) (err error) {
+	for retry := 0; ; retry++ {
+		if err = q.Enqueue(item); err != ErrQueueFull {
+			return
+		}
+		atomic.AddUint64(waits, 1)
+		if err = backoff(ctx, retry, spins); err != nil {
+			return
+		}
+	}
+}
+
+func (rb *ringBuf) BlockingEnqueueMany(ctx context.Context, items []interface{}) (err error) {
+	return blockingEnqueueAll(ctx, rb, &rb.putWaits, rb.spins, items)
+}
+
+// allEnqueuer puts all the items at once or none of them, see
+// BlockingEnqueueMany.
+type allEnqueuer interface {
+	// enqueueAll returns ErrQueueFull without writing anything if
+	// the free slots are fewer than len(items).
+	enqueueAll(items []interface{}) (err error)
+}
+
+func blockingEnqueueAll(ctx context.Context, q allEnqueuer, waits *uint64, spins int, items []interface{}) (err error) {
+	for retry := 0; ; retry++ {
+		if err = q.enqueueAll(items); err != ErrQueueFull {
+			return
+		}
+		atomic.AddUint64(waits, 1)
+		if err = backoff(ctx, retry, spins); err != nil {
+			return
+		}
+	}
+}
+
+func blockingDequeue(ctx context.Context, q Queue, waits *uint64, spins int) (item interface{}, err error) {
+	for retry := 0; ; retry++ {
+		if item, err = q.Dequeue(); err != ErrQueueEmpty {
+			return
+		}
+		atomic.AddUint64(waits, 1)
+		if err = backoff(ctx, retry, spins); err != nil {
+			return
+		}
+	}
+}
+
+func putTimeout(rb RingBuffer, item interface{}, d time.Duration) (err error) {
+	switch {
+	case d == 0:
+		return rb.Enqueue(item)
+	case d < 0:
+		return rb.BlockingEnqueue(context.Background(), item)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if err = rb.BlockingEnqueue(ctx, item); err == context.DeadlineExceeded {
+		err = ErrTimeout
+	}
+	return
+}
+
+func getTimeout(rb RingBuffer, d time.Duration) (item interface{}, err error) {
+	switch {
+	case d == 0:
+		return rb.Dequeue()
+	case d < 0:
+		return rb.BlockingDequeue(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if item, err = rb.BlockingDequeue(ctx); err == context.DeadlineExceeded {
+		err = ErrTimeout
+	}
+	return
+}
+
+// backoff yields the processor for the first spins retries, and
+// sleeps with a growing duration later. It returns ctx.Err() as
+// soon as ctx is done, even if it's sleeping.
+func backoff(ctx context.Context, retry, spins int) error {
+	if retry < spins {
+		runtime.Gosched()
+		return ctx.Err()
+	}
+
+	d := time.Duration(retry-spins+1) * time.Microsecond
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	return nil
+}
+
+const (
+	// spinsBeforeSleep is the default of WithSpinsBeforeSleep.
+	spinsBeforeSleep = 30
+	maxBackoff       = time.Millisecond
+)
@@ -0,0 +1,117 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// ByteRing is a circular byte buffer for exactly one writer and
+	// one reader, it implements io.Reader and io.Writer.
+	//
+	// Like NewSPSC, tail is written by the writer only, and head by
+	// the reader only, so that the bytes can be streamed without
+	// any lock or per-message allocation. All the Cap() bytes are
+	// usable since head and tail are free-running.
+	ByteRing struct {
+		cap        uint32
+		capModMask uint32
+		_          [CacheLinePadSize - 8]byte
+		head       uint32
+		_          [CacheLinePadSize - 4]byte
+		tail       uint32
+		_          [CacheLinePadSize - 4]byte
+		data       []byte
+	}
+)
+
+// NewByteRing returns a ByteRing object, the capacity will be
+// rounded up to a power of 2.
+func NewByteRing(capacity uint32) *ByteRing {
+	size := roundUpToPower2(capacity)
+	return &ByteRing{
+		data:       make([]byte, size),
+		cap:        size,
+		capModMask: size - 1, // = 2^n - 1
+	}
+}
+
+// Write copies as many bytes of p as possible into the ring. If
+// the ring is nearly full, a partial write happens and ErrQueueFull
+// returned with the count of bytes written.
+func (r *ByteRing) Write(p []byte) (n int, err error) {
+	tail := atomic.LoadUint32(&r.tail)
+	head := atomic.LoadUint32(&r.head)
+	free := r.cap - (tail - head)
+	count := uint32(len(p))
+	if count > free {
+		count = free
+	}
+
+	if count > 0 {
+		pos := tail & r.capModMask
+		c := uint32(copy(r.data[pos:], p[:count]))
+		copy(r.data, p[c:count]) // wraps around the end of data
+		atomic.StoreUint32(&r.tail, tail+count)
+	}
+
+	n = int(count)
+	if n < len(p) {
+		err = ErrQueueFull
+	}
+	return
+}
+
+// Read copies up to len(p) bytes out of the ring. If the ring is
+// nearly empty, a partial read happens. ErrQueueEmpty returned if
+// nothing could be read.
+func (r *ByteRing) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+
+	head := atomic.LoadUint32(&r.head)
+	tail := atomic.LoadUint32(&r.tail)
+	count := tail - head
+	if count == 0 {
+		err = ErrQueueEmpty
+		return
+	}
+	if uint32(len(p)) < count {
+		count = uint32(len(p))
+	}
+
+	pos := head & r.capModMask
+	c := uint32(copy(p[:count], r.data[pos:]))
+	copy(p[c:count], r.data) // wraps around the end of data
+	atomic.StoreUint32(&r.head, head+count)
+
+	n = int(count)
+	return
+}
+
+// Len returns the count of bytes which can be read.
+func (r *ByteRing) Len() int {
+	head := atomic.LoadUint32(&r.head)
+	return int(atomic.LoadUint32(&r.tail) - head)
+}
+
+// Free returns the count of bytes which can be written.
+func (r *ByteRing) Free() int {
+	return int(r.cap) - r.Len()
+}
+
+// Cap returns the capacity of the ring.
+func (r *ByteRing) Cap() int {
+	return int(r.cap)
+}
+
+// Reset empties the ring. It's unsafe while the writer or the
+// reader is working.
+func (r *ByteRing) Reset() {
+	atomic.StoreUint32(&r.head, 0)
+	atomic.StoreUint32(&r.tail, 0)
+}
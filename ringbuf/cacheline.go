@@ -0,0 +1,13 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"unsafe"
+)
+
+// rbItemPayload is the size of the fields of rbItem, the padding
+// after them rounds a slot up to whole cache lines.
+const rbItemPayload = unsafe.Sizeof(uint64(0)) + unsafe.Sizeof(interface{}(nil)) + unsafe.Sizeof(int64(0))
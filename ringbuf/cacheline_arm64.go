@@ -0,0 +1,11 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+// CacheLinePadSize represents the CPU Cache Line Padding Size.
+//
+// Some arm64 cores (Apple M-series, Neoverse with adjacent-line
+// prefetching) work on 128-byte lines.
+const CacheLinePadSize uintptr = 128
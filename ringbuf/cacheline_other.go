@@ -0,0 +1,12 @@
+//go:build !arm64 && !ppc64 && !ppc64le && !s390x
+// +build !arm64,!ppc64,!ppc64le,!s390x
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+// CacheLinePadSize represents the CPU Cache Line Padding Size,
+// 64 bytes on amd64/386 and the most of the others.
+const CacheLinePadSize uintptr = 64
@@ -0,0 +1,11 @@
+//go:build ppc64 || ppc64le
+// +build ppc64 ppc64le
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+// CacheLinePadSize represents the CPU Cache Line Padding Size.
+const CacheLinePadSize uintptr = 128
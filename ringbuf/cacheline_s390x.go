@@ -0,0 +1,8 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+// CacheLinePadSize represents the CPU Cache Line Padding Size.
+const CacheLinePadSize uintptr = 256
@@ -0,0 +1,45 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestCacheLineSlots(t *testing.T) {
+	if size := unsafe.Sizeof(rbItem{}); size%CacheLinePadSize != 0 {
+		t.Fatalf("a slot takes %v bytes, not whole cache lines of %v", size, CacheLinePadSize)
+	}
+
+	rb := New(8).(*ringBuf)
+	for i := 1; i < len(rb.data); i++ {
+		prev, cur := uintptr(unsafe.Pointer(&rb.data[i-1])), uintptr(unsafe.Pointer(&rb.data[i]))
+		if cur-prev < CacheLinePadSize {
+			t.Fatalf("slot %v is %v bytes after the previous one, sharing a cache line", i, cur-prev)
+		}
+	}
+}
+
+func TestCacheLineHotFields(t *testing.T) {
+	apart := func(name string, offsets ...uintptr) {
+		t.Helper()
+		for i := 1; i < len(offsets); i++ {
+			if d := offsets[i] - offsets[i-1]; d < CacheLinePadSize {
+				t.Errorf("%s: field #%v is %v bytes after the previous one, less than a cache line of %v",
+					name, i, d, CacheLinePadSize)
+			}
+		}
+	}
+
+	var rb ringBuf
+	apart("ringBuf", 0, unsafe.Offsetof(rb.head), unsafe.Offsetof(rb.tail), unsafe.Offsetof(rb.putWaits),
+		unsafe.Offsetof(rb.getWaits), unsafe.Offsetof(rb.expired), unsafe.Offsetof(rb.highWater),
+		unsafe.Offsetof(rb.data))
+	var spsc spscRingBuf
+	apart("spscRingBuf", unsafe.Offsetof(spsc.head), unsafe.Offsetof(spsc.tail))
+	var br ByteRing
+	apart("ByteRing", unsafe.Offsetof(br.head), unsafe.Offsetof(br.tail))
+}
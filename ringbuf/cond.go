@@ -0,0 +1,538 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+
+	// condRingBuf is the ring buffer for WithBlockingMode(true).
+	//
+	// All the states are guarded by mu, a producer waits on notFull
+	// and wakes up a consumer by notEmpty, and vice versa. No CPU
+	// is burnt while waiting.
+	condRingBuf struct {
+		mu          sync.Mutex
+		notEmpty    sync.Cond
+		notFull     sync.Cond
+		cap         uint32
+		limit       uint32
+		head        uint32 // index of the first item in data
+		count       uint32
+		highWater   uint32 // the maximum count
+		data        []interface{}
+		ts          []int64 // enqueued time in unix nano, for WithItemTTL
+		ttl         time.Duration
+		expired     uint64
+		closed      bool
+		putWaits    uint64
+		getWaits    uint64
+		batchWaits  int // the waiters of BlockingEnqueueMany
+		debugMode   bool
+		logger      Logger
+		initializer Initializeable
+	}
+)
+
+func newCondRingBuf(cfg *ringBuf) *condRingBuf {
+	rb := &condRingBuf{
+		cap:         cfg.cap,
+		limit:       cfg.limit,
+		data:        make([]interface{}, cfg.limit),
+		debugMode:   cfg.debugMode,
+		logger:      cfg.logger,
+		initializer: cfg.initializer,
+		ttl:         cfg.ttl,
+	}
+	if rb.ttl > 0 {
+		rb.ts = make([]int64, cfg.limit)
+	}
+	rb.notEmpty.L = &rb.mu
+	rb.notFull.L = &rb.mu
+
+	if rb.initializer != nil {
+		for i := range rb.data {
+			rb.data[i] = rb.initializer.PreAlloc(i)
+		}
+	}
+	return rb
+}
+
+func (rb *condRingBuf) Put(item interface{}) (err error) {
+	err = rb.Enqueue(item)
+	return
+}
+
+// Enqueue waits for a free slot, and returns ErrClosed if the
+// queue is closed.
+func (rb *condRingBuf) Enqueue(item interface{}) (err error) {
+	return rb.BlockingEnqueue(context.Background(), item)
+}
+
+func (rb *condRingBuf) BlockingEnqueue(ctx context.Context, item interface{}) (err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if err = rb.waitNotFull(ctx, 1); err != nil {
+		return
+	}
+	rb.fill(item)
+	return
+}
+
+// BlockingEnqueueMany waits until all the items fit, and puts them
+// with the lock held.
+func (rb *condRingBuf) BlockingEnqueueMany(ctx context.Context, items []interface{}) (err error) {
+	if uint32(len(items)) > rb.limit {
+		return ErrBatchTooLarge
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.batchWaits++
+	err = rb.waitNotFull(ctx, uint32(len(items)))
+	rb.batchWaits--
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		rb.fill(item)
+	}
+	return
+}
+
+// EnqueueFrom waits for a free slot, and fills it with the item
+// built by fn, which is called with the lock held.
+func (rb *condRingBuf) EnqueueFrom(fn func() interface{}) (err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if err = rb.waitNotFull(context.Background(), 1); err != nil {
+		return
+	}
+	rb.fill(fn())
+	return
+}
+
+// EnqueueMany waits for a free slot, and puts as many items as
+// possible. ErrQueueFull returned with the count of items written
+// if the buffer filled partway through.
+func (rb *condRingBuf) EnqueueMany(items []interface{}) (n int, err error) {
+	if len(items) == 0 {
+		return
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if err = rb.waitNotFull(context.Background(), 1); err != nil {
+		return
+	}
+	for n < len(items) && rb.count < rb.limit {
+		rb.fill(items[n])
+		n++
+	}
+	if n < len(items) {
+		err = ErrQueueFull
+	}
+	return
+}
+
+// waitNotFull waits for n free slots, it must be called with mu
+// held.
+func (rb *condRingBuf) waitNotFull(ctx context.Context, n uint32) (err error) {
+	if rb.count+n > rb.limit && !rb.closed {
+		defer rb.wakeOnDone(ctx, &rb.notFull)()
+	}
+	for rb.count+n > rb.limit && !rb.closed {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		atomic.AddUint64(&rb.putWaits, 1)
+		rb.notFull.Wait()
+	}
+	if rb.closed {
+		err = ErrClosed
+	}
+	return
+}
+
+// fill must be called with mu held and a free slot.
+func (rb *condRingBuf) fill(item interface{}) {
+	pos := (rb.head + rb.count) % rb.limit
+	if rb.initializer != nil {
+		rb.initializer.CloneIn(item, rb.data[pos])
+	} else {
+		rb.data[pos] = item
+	}
+	if rb.ttl > 0 {
+		rb.ts[pos] = time.Now().UnixNano()
+	}
+	rb.count++
+	if rb.count > rb.highWater {
+		rb.highWater = rb.count
+	}
+	rb.notEmpty.Signal()
+
+	if rb.debugMode {
+		rb.logger.Debugf("[W] head: %v, count: %v | ENQUEUED value = %v", rb.head, rb.count, toString(item))
+	}
+}
+
+func (rb *condRingBuf) Get() (item interface{}, err error) {
+	item, err = rb.Dequeue()
+	return
+}
+
+// TryEnqueue puts item without waiting for a free slot.
+func (rb *condRingBuf) TryEnqueue(item interface{}) (ok bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if ok = rb.count < rb.limit && !rb.closed; ok {
+		rb.fill(item)
+	}
+	return
+}
+
+func (rb *condRingBuf) EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool) {
+	return enqueueOrDrop(rb, item, onDrop)
+}
+
+// DequeueInto waits for an item, and passes it to fn.
+func (rb *condRingBuf) DequeueInto(fn func(item interface{})) (err error) {
+	return dequeueInto(rb, fn)
+}
+
+// TryDequeue takes an item without waiting for it.
+func (rb *condRingBuf) TryDequeue() (item interface{}, ok bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	for rb.count > 0 {
+		if item, expired := rb.take(); !expired {
+			return item, true
+		}
+	}
+	return
+}
+
+// Dequeue waits for an item, and returns ErrClosed if the queue is
+// closed and drained.
+func (rb *condRingBuf) Dequeue() (item interface{}, err error) {
+	return rb.BlockingDequeue(context.Background())
+}
+
+func (rb *condRingBuf) BlockingDequeue(ctx context.Context) (item interface{}, err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	for expired := true; expired; {
+		if err = rb.waitNotEmpty(ctx); err != nil {
+			return
+		}
+		item, expired = rb.take()
+	}
+	return
+}
+
+// DequeueMany waits for an item, and drains up to len(dst) items
+// into dst.
+func (rb *condRingBuf) DequeueMany(dst []interface{}) (n int, err error) {
+	if len(dst) == 0 {
+		return
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	for n == 0 {
+		if err = rb.waitNotEmpty(context.Background()); err != nil {
+			return
+		}
+		for n < len(dst) && rb.count > 0 {
+			if item, expired := rb.take(); !expired {
+				dst[n] = item
+				n++
+			}
+		}
+	}
+	return
+}
+
+func (rb *condRingBuf) Drain() (items []interface{}) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.count == 0 {
+		return
+	}
+	items = make([]interface{}, 0, rb.count)
+	for rb.count > 0 {
+		if item, expired := rb.take(); !expired {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// waitNotEmpty must be called with mu held.
+func (rb *condRingBuf) waitNotEmpty(ctx context.Context) (err error) {
+	if rb.count == 0 && !rb.closed {
+		defer rb.wakeOnDone(ctx, &rb.notEmpty)()
+	}
+	for rb.count == 0 && !rb.closed {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		atomic.AddUint64(&rb.getWaits, 1)
+		rb.notEmpty.Wait()
+	}
+	if rb.count == 0 {
+		err = ErrClosed
+	}
+	return
+}
+
+// take must be called with mu held and at least one item.
+func (rb *condRingBuf) take() (item interface{}, expired bool) {
+	if rb.initializer != nil {
+		item = rb.initializer.CloneOut(rb.data[rb.head])
+	} else {
+		item = rb.data[rb.head]
+		rb.data[rb.head] = nil
+	}
+	if item == poison {
+		expired, item = true, nil // see CloseAndSignal
+	} else if rb.ttl > 0 && time.Now().UnixNano()-rb.ts[rb.head] > int64(rb.ttl) {
+		expired, item = true, nil
+		atomic.AddUint64(&rb.expired, 1)
+	}
+	rb.head = (rb.head + 1) % rb.limit
+	rb.count--
+	if rb.batchWaits > 0 {
+		rb.notFull.Broadcast() // a batch waiter may take the signal but not fit
+	} else {
+		rb.notFull.Signal()
+	}
+
+	if rb.debugMode {
+		rb.logger.Debugf("[ringbuf][GET] head: %v, count: %v, item=%v", rb.head, rb.count, toString(item))
+	}
+	return
+}
+
+// wakeOnDone broadcasts cond once ctx is done, so that the waiters
+// can check ctx.Err(). The returned func stops the watching.
+func (rb *condRingBuf) wakeOnDone(ctx context.Context, cond *sync.Cond) (stop func()) {
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			rb.mu.Lock()
+			cond.Broadcast()
+			rb.mu.Unlock()
+		case <-stopCh:
+		}
+	}()
+	return func() { close(stopCh) }
+}
+
+func (rb *condRingBuf) PutTimeout(item interface{}, d time.Duration) (err error) {
+	if d == 0 {
+		rb.mu.Lock()
+		defer rb.mu.Unlock()
+		if rb.closed {
+			return ErrClosed
+		}
+		if rb.count >= rb.limit {
+			return ErrQueueFull
+		}
+		rb.fill(item)
+		return
+	}
+	return putTimeout(rb, item, d)
+}
+
+func (rb *condRingBuf) GetTimeout(d time.Duration) (item interface{}, err error) {
+	if d == 0 {
+		rb.mu.Lock()
+		defer rb.mu.Unlock()
+		for expired := true; expired; {
+			if rb.count == 0 {
+				if rb.closed {
+					return nil, ErrClosed
+				}
+				return nil, ErrQueueEmpty
+			}
+			item, expired = rb.take()
+		}
+		return
+	}
+	return getTimeout(rb, d)
+}
+
+func (rb *condRingBuf) Peek() (item interface{}, err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.count == 0 {
+		err = ErrQueueEmpty
+		if rb.closed {
+			err = ErrClosed
+		}
+		return
+	}
+	if item = rb.data[rb.head]; item == poison {
+		item, err = nil, ErrClosed
+	}
+	return
+}
+
+func (rb *condRingBuf) ForEach(fn func(index int, item interface{}) bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	for i := uint32(0); i < rb.count; i++ {
+		if item := rb.data[(rb.head+i)%rb.limit]; item != poison && !fn(int(i), item) {
+			return
+		}
+	}
+}
+
+func (rb *condRingBuf) GetGetWaits() uint64 {
+	return atomic.LoadUint64(&rb.getWaits)
+}
+
+func (rb *condRingBuf) GetPutWaits() uint64 {
+	return atomic.LoadUint64(&rb.putWaits)
+}
+
+func (rb *condRingBuf) ExpiredCount() uint64 {
+	return atomic.LoadUint64(&rb.expired)
+}
+
+func (rb *condRingBuf) ResetCounters() {
+	atomic.StoreUint64(&rb.getWaits, 0)
+	atomic.StoreUint64(&rb.putWaits, 0)
+	atomic.StoreUint64(&rb.expired, 0)
+	rb.mu.Lock()
+	rb.highWater = 0
+	rb.mu.Unlock()
+}
+
+func (rb *condRingBuf) HighWaterMark() uint32 {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.highWater
+}
+
+// Close is CloseWrite, for io.Closer.
+func (rb *condRingBuf) Close() (err error) {
+	return rb.CloseWrite()
+}
+
+// CloseWrite marks the queue closed and wakes up all the waiters.
+func (rb *condRingBuf) CloseWrite() (err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.closed = true
+	rb.notEmpty.Broadcast()
+	rb.notFull.Broadcast()
+	return
+}
+
+// CloseAndSignal puts the marker if there is a free slot, see
+// RingBuffer.CloseAndSignal.
+func (rb *condRingBuf) CloseAndSignal() (err error) {
+	return closeAndSignal(rb, rb.initializer != nil)
+}
+
+func (rb *condRingBuf) IsClosed() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.closed
+}
+
+// Quantity is an alias of Len.
+func (rb *condRingBuf) Quantity() uint32 {
+	return rb.Len()
+}
+
+// Size is an alias of Len.
+func (rb *condRingBuf) Size() uint32 {
+	return rb.Len()
+}
+
+func (rb *condRingBuf) Len() (quantity uint32) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.count
+}
+
+func (rb *condRingBuf) Free() uint32 {
+	return rb.limit - rb.Len()
+}
+
+func (rb *condRingBuf) Cap() uint32 {
+	return rb.cap
+}
+
+func (rb *condRingBuf) CapReal() uint32 {
+	return rb.limit
+}
+
+func (rb *condRingBuf) IsEmpty() (b bool) {
+	return rb.Size() == 0
+}
+
+func (rb *condRingBuf) IsFull() (b bool) {
+	return rb.Size() >= rb.limit
+}
+
+// Reset will clear the whole queue without reallocating the slots.
+//
+// ErrQueueNotEmpty returned if there are items still in the queue,
+// unless force is true. A closed queue is reopened only if force is
+// true, see ringBuf.Reset.
+func (rb *condRingBuf) Reset(force bool) (err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if !force && rb.closed {
+		return ErrClosed
+	}
+	if !force && rb.count != 0 {
+		return ErrQueueNotEmpty
+	}
+	if rb.initializer == nil {
+		for i := range rb.data {
+			rb.data[i] = nil
+		}
+	}
+	rb.head, rb.count, rb.closed = 0, 0, false
+	rb.notFull.Broadcast()
+	return
+}
+
+func (rb *condRingBuf) Debug(enabled bool) (lastState bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	lastState = rb.debugMode
+	rb.debugMode = enabled
+	return
+}
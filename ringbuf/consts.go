@@ -0,0 +1,43 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"gopkg.in/hedzr/errors.v2"
+)
+
+var (
+	// ErrQueueFull queue full when enqueueing
+	ErrQueueFull = errors.New("queue full")
+	// ErrQueueEmpty queue empty when dequeueing
+	ErrQueueEmpty = errors.New("queue empty")
+	// ErrRaced the exception raised if data racing
+	ErrRaced = errors.New("queue race")
+	// ErrQueueNotReady queue not ready for enqueue or dequeue
+	ErrQueueNotReady = errors.New("queue not ready")
+	// ErrQueueNotEmpty queue not empty when resetting it
+	ErrQueueNotEmpty = errors.New("queue not empty")
+	// ErrCorrupted a nil item was read out of a slot. The slot has
+	// been released, so the queue is still usable.
+	ErrCorrupted = errors.New("queue item corrupted")
+	// ErrClosed the queue has been closed, and no more items in it
+	ErrClosed = errors.New("queue closed")
+	// ErrBadLane the priority lane is out of range
+	ErrBadLane = errors.New("queue lane out of range")
+	// ErrTimeout the timed operation could not complete in time
+	ErrTimeout = errors.New("queue operation timeout")
+	// ErrBadBackingStore the slots returned by the allocator of
+	// WithBackingStore don't match the capacity
+	ErrBadBackingStore = errors.New("queue backing store mismatched")
+	// ErrBatchTooLarge the batch of BlockingEnqueueMany is larger
+	// than the capacity, it would never fit
+	ErrBatchTooLarge = errors.New("queue batch larger than capacity")
+)
+
+// MaxUint32 represents the maximal uint32 value
+const MaxUint32 = ^uint32(0)
+
+// MaxUint64 represents the maximal uint64 value
+const MaxUint64 = ^uint64(0)
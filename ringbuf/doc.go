@@ -0,0 +1,31 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+// Package ringbuf provides the helpers and extensions around the
+// lock-free ring-buffer/circular-queue.
+//
+// # Ordering
+//
+// The queues of New, NewSPSC and NewRing are strictly FIFO across
+// all the producers, not only per producer. An enqueue takes its
+// place in the queue at the moment it reserves the tail slot, and
+// the consumers are handed out the slots in that order, waiting
+// for a slot reserved but not filled yet rather than skipping it.
+// So if an Enqueue returns before another one is called, its item
+// is dequeued first, no matter which producers called them. Two
+// enqueues overlapping in time may take either order, as no order
+// exists between them; the items of EnqueueMany stay contiguous.
+//
+// The same holds for the consumers: if a Dequeue returns before
+// another one is called, it gets the earlier item. But several
+// consumers dequeuing at the same time may return, and then
+// process, their items in any order. Use a single consumer, or
+// carry a sequence number in the items, if the processing order
+// matters.
+//
+// The exceptions are stated by their own docs: the items expired
+// by WithItemTTL are skipped, a PriorityRingBuffer is FIFO within
+// each lane only, and a Merger interleaves its inputs by the
+// policy.
+package ringbuf
@@ -0,0 +1,121 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// slotStates renders the readWrite states of the lock-free ring
+// buffer, see Dump.
+var slotStates = [...]byte{'.', 'R', 'w', 'r'}
+
+// Dump returns a snapshot of head, tail and the state of each slot:
+// '.' free, 'R' readable, 'w' being written, 'r' being read. It's
+// best-effort, the slots are loaded one by one without stopping the
+// producers and consumers. Empty unless Debug(true).
+func (rb *ringBuf) Dump() string {
+	if !rb.debugMode {
+		return ""
+	}
+	head, tail := atomic.LoadUint32(&rb.head), atomic.LoadUint32(&rb.tail)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ringbuf: head=%v tail=%v capModMask=%#x quantity=%v closed=%v\nslots: ",
+		head, tail, rb.capModMask, rb.qty(head, tail), rb.IsClosed())
+	for i := range rb.data {
+		if state := atomic.LoadUint64(&rb.data[i].readWrite); state < uint64(len(slotStates)) {
+			sb.WriteByte(slotStates[state])
+		} else {
+			sb.WriteByte('?')
+		}
+	}
+	return sb.String()
+}
+
+// Dump is like ringBuf.Dump, the state of a slot is derived from its
+// sequence number: '.' writable, 'R' readable, 'x' held over from
+// the other lap.
+func (rb *fairRingBuf) Dump() string {
+	if !rb.debugMode {
+		return ""
+	}
+	head, tail := atomic.LoadUint32(&rb.head), atomic.LoadUint32(&rb.tail)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ringbuf(fair): head=%v tail=%v capModMask=%#x quantity=%v closed=%v\nslots: ",
+		head, tail, rb.capModMask, rb.qty(head, tail), rb.IsClosed())
+	for i := uint32(0); i < uint32(len(rb.data)); i++ {
+		switch rb.seq(head + (i-head)&rb.capModMask) {
+		case 0:
+			sb.WriteByte('.')
+		case 1:
+			sb.WriteByte('R')
+		default:
+			sb.WriteByte('x')
+		}
+	}
+	return sb.String()
+}
+
+// Dump is like ringBuf.Dump, a slot is either '.' free or 'R'
+// holding an item. It must be called from the consumer goroutine.
+func (rb *spscRingBuf) Dump() string {
+	if !rb.debugMode {
+		return ""
+	}
+	head, tail := atomic.LoadUint32(&rb.head), atomic.LoadUint32(&rb.tail)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ringbuf(spsc): head=%v tail=%v capModMask=%#x quantity=%v closed=%v\nslots: ",
+		head, tail, rb.capModMask, tail-head, rb.IsClosed())
+	for i := uint32(0); i < uint32(len(rb.data)); i++ {
+		if (i-head)&rb.capModMask < tail-head {
+			sb.WriteByte('R')
+		} else {
+			sb.WriteByte('.')
+		}
+	}
+	return sb.String()
+}
+
+// Dump is like ringBuf.Dump, the snapshot is consistent since it's
+// taken under the lock.
+func (rb *condRingBuf) Dump() string {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.debugMode {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ringbuf(blocking): head=%v count=%v limit=%v closed=%v\nslots: ",
+		rb.head, rb.count, rb.limit, rb.closed)
+	for i := uint32(0); i < rb.limit; i++ {
+		if (i+rb.limit-rb.head)%rb.limit < rb.count {
+			sb.WriteByte('R')
+		} else {
+			sb.WriteByte('.')
+		}
+	}
+	return sb.String()
+}
+
+// Dump returns the dumps of all the lanes, from the highest one.
+func (rb *priorityRingBuf) Dump() string {
+	var dumps []string
+	for i, lane := range rb.lanes {
+		if d := lane.(Dbg).Dump(); d != "" {
+			dumps = append(dumps, fmt.Sprintf("lane %v: %v", i, d))
+		}
+	}
+	return strings.Join(dumps, "\n")
+}
+
+func (rb *signalRingBuf) Dump() string {
+	return rb.RingBuffer.(Dbg).Dump()
+}
+
+func (rb *latencyRingBuf) Dump() string {
+	return rb.RingBuffer.(Dbg).Dump()
+}
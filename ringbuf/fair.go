@@ -0,0 +1,480 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type (
+
+	// fairRingBuf is the ring buffer for WithFairScheduling(true),
+	// the bounded MPMC queue of Dmitry Vyukov.
+	//
+	// readWrite of each slot is its sequence number instead of the
+	// state: a slot at position pos is writable if the sequence is
+	// pos, and readable if it's pos+1; the consumer hands it over to
+	// the producer of the next lap by storing pos+len(data).
+	//
+	// A producer checks the slot before competing for the tail, and
+	// owns the slot once it wins, so it never waits on a slot held
+	// by another producer. Each CAS failure means another producer
+	// has succeeded, none of them can block the others.
+	fairRingBuf struct {
+		ringBuf
+	}
+)
+
+func newFairRingBuf(cfg *ringBuf) *fairRingBuf {
+	rb := &fairRingBuf{ringBuf: *cfg}
+	rb.resetSeq()
+	return rb
+}
+
+func (rb *fairRingBuf) resetSeq() {
+	for i := range rb.data {
+		atomic.StoreUint64(&rb.data[i].readWrite, uint64(i))
+	}
+}
+
+// seq returns the sequence number of the slot at pos, relative to
+// pos.
+func (rb *fairRingBuf) seq(pos uint32) int32 {
+	return int32(uint32(atomic.LoadUint64(&rb.data[pos&rb.capModMask].readWrite)) - pos)
+}
+
+func (rb *fairRingBuf) Put(item interface{}) (err error) {
+	err = rb.Enqueue(item)
+	return
+}
+
+func (rb *fairRingBuf) Enqueue(item interface{}) (err error) {
+	var tail, head uint32
+	if tail, head, err = rb.reserveTail(); err != nil {
+		return
+	}
+	rb.fill(tail, item)
+	rb.enqueued(tail, head, item)
+	return
+}
+
+// EnqueueFrom reserves a slot, and fills it with the item built by
+// fn then.
+func (rb *fairRingBuf) EnqueueFrom(fn func() interface{}) (err error) {
+	var tail, head uint32
+	if tail, head, err = rb.reserveTail(); err != nil {
+		return
+	}
+	item := fn()
+	rb.fill(tail, item)
+	rb.enqueued(tail, head, item)
+	return
+}
+
+// reserveTail advances the tail by one, the slot at the old tail is
+// owned by the caller, which must fill it.
+func (rb *fairRingBuf) reserveTail() (tail, head uint32, err error) {
+	if rb.IsClosed() {
+		err = ErrClosed
+		return
+	}
+	for retry := 0; ; {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if rb.qty(head, tail) >= rb.limit {
+			err = ErrQueueFull
+			return
+		}
+
+		switch dif := rb.seq(tail); {
+		case dif < 0:
+			rb.waiter.Wait(retry) // a consumer is still reading the previous lap
+			retry++
+			continue
+		case dif > 0:
+			continue // tail is stale, another producer has taken it
+		}
+
+		if atomic.CompareAndSwapUint32(&rb.tail, tail, tail+1) {
+			err = rb.backOutIfClosed(tail, 1)
+			return
+		}
+	}
+}
+
+// backOutIfClosed is ringBuf.backOutIfClosed.
+func (rb *fairRingBuf) backOutIfClosed(tail, count uint32) (err error) {
+	if !rb.IsClosed() {
+		return
+	}
+	for i := uint32(0); i < count; i++ {
+		rb.fill(tail+i, poison)
+	}
+	return ErrClosed
+}
+
+func (rb *fairRingBuf) enqueued(tail, head uint32, item interface{}) {
+	raiseHighWater(&rb.highWater, rb.qty(atomic.LoadUint32(&rb.head), tail+1))
+	if rb.debugMode {
+		rb.logger.Debugf("[W] tail %v => %v, head: %v | ENQUEUED value = %v", tail, tail+1, head, toString(item))
+	}
+}
+
+// EnqueueMany reserves the run of the writable slots from the tail
+// at once, and fills them with items.
+func (rb *fairRingBuf) EnqueueMany(items []interface{}) (n int, err error) {
+	var tail, head, count uint32
+	if len(items) == 0 {
+		return
+	}
+	if tail, head, count, err = rb.reserveRun(uint32(len(items)), false); err != nil {
+		return
+	}
+	rb.fillRun(tail, head, items[:count])
+	n = int(count)
+	if n < len(items) {
+		err = ErrQueueFull
+	}
+	return
+}
+
+func (rb *fairRingBuf) enqueueAll(items []interface{}) (err error) {
+	var tail, head uint32
+	if uint32(len(items)) > rb.limit {
+		return ErrBatchTooLarge
+	}
+	if len(items) == 0 {
+		return
+	}
+	if tail, head, _, err = rb.reserveRun(uint32(len(items)), true); err == nil {
+		rb.fillRun(tail, head, items)
+	}
+	return
+}
+
+func (rb *fairRingBuf) BlockingEnqueueMany(ctx context.Context, items []interface{}) (err error) {
+	return blockingEnqueueAll(ctx, rb, &rb.putWaits, rb.spins, items)
+}
+
+// reserveRun reserves the run of up to most writable slots from the
+// tail, or exactly most ones if all is set, and returns the old tail
+// and the count of them.
+func (rb *fairRingBuf) reserveRun(most uint32, all bool) (tail, head, count uint32, err error) {
+	var qty uint32
+	if rb.IsClosed() {
+		err = ErrClosed
+		return
+	}
+	for retry := 0; ; {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if qty = rb.qty(head, tail); qty >= rb.limit || (all && rb.limit-qty < most) {
+			err = ErrQueueFull
+			return
+		}
+		count = rb.limit - qty
+		if most < count {
+			count = most
+		}
+
+		switch dif := rb.seq(tail); {
+		case dif < 0:
+			rb.waiter.Wait(retry) // a consumer is still reading the previous lap
+			retry++
+			continue
+		case dif > 0:
+			continue
+		}
+		for i := uint32(1); i < count; i++ {
+			if rb.seq(tail+i) != 0 {
+				count = i // shrink the run to the writable slots
+				break
+			}
+		}
+		if all && count < most {
+			rb.waiter.Wait(retry) // the consumers are still reading the rest
+			retry++
+			continue
+		}
+
+		if atomic.CompareAndSwapUint32(&rb.tail, tail, tail+count) {
+			err = rb.backOutIfClosed(tail, count)
+			return
+		}
+	}
+}
+
+// fillRun fills the run of slots reserved from tail with items.
+func (rb *fairRingBuf) fillRun(tail, head uint32, items []interface{}) {
+	count := uint32(len(items))
+	for i := uint32(0); i < count; i++ {
+		rb.fill(tail+i, items[i])
+	}
+	raiseHighWater(&rb.highWater, rb.qty(atomic.LoadUint32(&rb.head), tail+count))
+	if rb.debugMode {
+		rb.logger.Debugf("[W] tail %v => %v, head: %v | ENQUEUED %v items", tail, tail+count, head, count)
+	}
+}
+
+// fill writes item into the slot reserved by the caller, and
+// publishes it to the consumers.
+func (rb *fairRingBuf) fill(pos uint32, item interface{}) {
+	holder := &rb.data[pos&rb.capModMask]
+	if rb.initializer != nil && item != poison {
+		rb.initializer.CloneIn(item, holder.value)
+	} else {
+		holder.value = item
+	}
+	if rb.ttl > 0 {
+		holder.ts = time.Now().UnixNano()
+	}
+	atomic.StoreUint64(&holder.readWrite, uint64(pos+1))
+}
+
+func (rb *fairRingBuf) Get() (item interface{}, err error) {
+	item, err = rb.Dequeue()
+	return
+}
+
+func (rb *fairRingBuf) Dequeue() (item interface{}, err error) {
+	var tail, head uint32
+	for retry := 0; ; {
+		head = atomic.LoadUint32(&rb.head)
+
+		switch dif := rb.seq(head) - 1; {
+		case dif < 0:
+			if tail = atomic.LoadUint32(&rb.tail); tail == head {
+				err = rb.emptyOrClosed(tail)
+				return
+			}
+			rb.waiter.Wait(retry) // the producer is still writing this slot
+			retry++
+			continue
+		case dif > 0:
+			continue // head is stale, another consumer has taken it
+		}
+
+		if !atomic.CompareAndSwapUint32(&rb.head, head, head+1) {
+			continue
+		}
+
+		var expired bool
+		if item, expired = rb.take(head); expired {
+			continue // discard it, and try the next one
+		}
+
+		if rb.debugMode {
+			rb.logger.Debugf("[ringbuf][GET] cap=%v, head=%v, new head=%v, item=%v", rb.Cap(), head, head+1, toString(item))
+		}
+		if item == nil {
+			err = ErrCorrupted
+		}
+		return
+	}
+}
+
+// DequeueMany reserves the run of the readable slots from the head
+// at once, and drains them into dst.
+func (rb *fairRingBuf) DequeueMany(dst []interface{}) (n int, err error) {
+	var head, count uint32
+	if len(dst) == 0 {
+		return
+	}
+	if head, count = rb.reserve(uint32(len(dst))); count == 0 {
+		err = rb.emptyOrClosed(head)
+		return
+	}
+
+	for i := uint32(0); i < count; i++ {
+		if item, expired := rb.take(head + i); !expired {
+			dst[n] = item
+			n++
+		}
+	}
+
+	if rb.debugMode {
+		rb.logger.Debugf("[ringbuf][GET] head %v => %v | DEQUEUED %v items", head, head+count, n)
+	}
+	if n == 0 {
+		return rb.DequeueMany(dst) // the whole run expired
+	}
+	return
+}
+
+// reserve advances the head over up to most readable slots, and
+// returns the old head and the count of slots reserved. It waits
+// for the producers still writing the slots before the tail, zero
+// count returned only if the queue is empty.
+func (rb *fairRingBuf) reserve(most uint32) (head, count uint32) {
+	for retry := 0; ; {
+		head = atomic.LoadUint32(&rb.head)
+
+		switch dif := rb.seq(head) - 1; {
+		case dif < 0:
+			if atomic.LoadUint32(&rb.tail) == head {
+				return head, 0
+			}
+			rb.waiter.Wait(retry) // the producer is still writing this slot
+			retry++
+			continue
+		case dif > 0:
+			continue
+		}
+		for count = 1; count < most && rb.seq(head+count) == 1; count++ {
+		}
+
+		if atomic.CompareAndSwapUint32(&rb.head, head, head+count) {
+			return
+		}
+	}
+}
+
+// Drain removes the items enqueued at the moment, and returns them
+// in FIFO order.
+func (rb *fairRingBuf) Drain() (items []interface{}) {
+	head, count := rb.reserve(rb.limit)
+	if count == 0 {
+		return
+	}
+
+	items = make([]interface{}, 0, count)
+	for pos := head; pos != head+count; pos++ {
+		if item, expired := rb.take(pos); !expired {
+			items = append(items, item)
+		}
+	}
+
+	if rb.debugMode {
+		rb.logger.Debugf("[ringbuf][GET] head %v => %v | DRAINED %v items", head, head+count, len(items))
+	}
+	return
+}
+
+// take reads the item out of the slot reserved by the caller, and
+// hands the slot over to the producer of the next lap.
+func (rb *fairRingBuf) take(pos uint32) (item interface{}, expired bool) {
+	holder := &rb.data[pos&rb.capModMask]
+	switch {
+	case holder.value == poison:
+		item = poison
+		holder.value = rb.preAlloc(pos)
+	case rb.initializer != nil:
+		item = rb.initializer.CloneOut(holder.value)
+	default:
+		item = holder.value
+		holder.value = nil
+	}
+	if item == poison {
+		expired, item = true, nil // see CloseAndSignal
+	} else if rb.ttl > 0 && time.Now().UnixNano()-holder.ts > int64(rb.ttl) {
+		expired, item = true, nil
+		atomic.AddUint64(&rb.expired, 1)
+	}
+	atomic.StoreUint64(&holder.readWrite, uint64(pos+uint32(len(rb.data))))
+	return
+}
+
+func (rb *fairRingBuf) CloseAndSignal() (err error) {
+	return closeAndSignal(rb, rb.initializer != nil)
+}
+
+func (rb *fairRingBuf) TryEnqueue(item interface{}) (ok bool) {
+	return rb.Enqueue(item) == nil
+}
+
+func (rb *fairRingBuf) EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool) {
+	return enqueueOrDrop(rb, item, onDrop)
+}
+
+func (rb *fairRingBuf) TryDequeue() (item interface{}, ok bool) {
+	item, err := rb.Dequeue()
+	return item, err == nil
+}
+
+func (rb *fairRingBuf) DequeueInto(fn func(item interface{})) (err error) {
+	return dequeueInto(rb, fn)
+}
+
+func (rb *fairRingBuf) BlockingEnqueue(ctx context.Context, item interface{}) (err error) {
+	return blockingEnqueue(ctx, rb, &rb.putWaits, rb.spins, item)
+}
+
+func (rb *fairRingBuf) BlockingDequeue(ctx context.Context) (item interface{}, err error) {
+	return blockingDequeue(ctx, rb, &rb.getWaits, rb.spins)
+}
+
+func (rb *fairRingBuf) PutTimeout(item interface{}, d time.Duration) (err error) {
+	return putTimeout(rb, item, d)
+}
+
+func (rb *fairRingBuf) GetTimeout(d time.Duration) (item interface{}, err error) {
+	return getTimeout(rb, d)
+}
+
+func (rb *fairRingBuf) Peek() (item interface{}, err error) {
+	var head uint32
+	for retry := 0; ; retry++ {
+		head = atomic.LoadUint32(&rb.head)
+		if rb.seq(head) != 1 {
+			if tail := atomic.LoadUint32(&rb.tail); tail == head {
+				err = rb.emptyOrClosed(tail)
+				return
+			}
+			rb.waiter.Wait(retry) // the producer is still writing, or a consumer is reading
+			continue
+		}
+
+		item = rb.data[head&rb.capModMask].value
+
+		// make sure the slot wasn't taken while we were reading it
+		if atomic.LoadUint32(&rb.head) != head || rb.seq(head) != 1 {
+			continue
+		}
+		if item == poison {
+			item, err = nil, ErrClosed
+		}
+		return
+	}
+}
+
+// ForEach walks the readable items from head to tail without
+// dequeuing them, see ringBuf.ForEach.
+func (rb *fairRingBuf) ForEach(fn func(index int, item interface{}) bool) {
+	head := atomic.LoadUint32(&rb.head)
+	tail := atomic.LoadUint32(&rb.tail)
+	count := rb.qty(head, tail)
+	for i := uint32(0); i < count; i++ {
+		if rb.seq(head+i) != 1 || rb.data[(head+i)&rb.capModMask].value == poison {
+			continue
+		}
+		if !fn(int(i), rb.data[(head+i)&rb.capModMask].value) {
+			return
+		}
+	}
+}
+
+// Reset empties the queue, see ringBuf.Reset.
+func (rb *fairRingBuf) Reset(force bool) (err error) {
+	if !force && rb.IsClosed() {
+		return ErrClosed
+	}
+	if !force && rb.Size() != 0 {
+		return ErrQueueNotEmpty
+	}
+	for i := range rb.data {
+		if rb.initializer == nil || rb.data[i].value == poison {
+			rb.data[i].value = rb.preAlloc(uint32(i))
+		}
+	}
+	rb.resetSeq()
+	atomic.StoreUint32(&rb.head, 0)
+	atomic.StoreUint32(&rb.tail, 0)
+	atomic.StoreUint32(&rb.closed, 0)
+	return
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"time"
+)
+
+// TaggedItem is an item emitted by FanIn, tagged with the index of
+// its source ring buffer.
+type TaggedItem struct {
+	Index int
+	Item  interface{}
+}
+
+// FanIn drains all sources into one channel, each item is tagged
+// with the index of the source it came from.
+//
+// The sources are drained round-robin, one item per source in each
+// round, so a busy source cannot starve the others.
+//
+// The sources of WithSignaling are waited on their signals, the
+// others are polled with a backoff, so FanIn should be the only
+// consumer of them.
+//
+// The returned channel will be closed after ctx cancelled, or all
+// the sources have been closed and drained.
+func FanIn(ctx context.Context, sources ...RingBuffer) <-chan TaggedItem {
+	ch := make(chan TaggedItem)
+	go fanIn(ctx, ch, sources)
+	return ch
+}
+
+func fanIn(ctx context.Context, ch chan<- TaggedItem, sources []RingBuffer) {
+	defer close(ch)
+
+	// the sources of WithSignaling wake up the loop once an item
+	// arrives, the others are polled.
+	wake := make(chan struct{}, 1)
+	signaling := make([]bool, len(sources))
+	for i, rb := range sources {
+		if s := signalsOf(rb); s != nil {
+			signaling[i] = true
+			go forwardSignals(ctx, s, wake)
+		}
+	}
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	retry := 0
+	closed, live := make([]bool, len(sources)), len(sources)
+	for live > 0 {
+		got, polling := false, false
+		for i, rb := range sources {
+			if closed[i] {
+				continue
+			}
+			it, err := rb.Dequeue()
+			if err == ErrClosed {
+				closed[i] = true
+				live--
+				continue
+			} else if err != nil {
+				// a closed source may still be settling the last
+				// items, its signals are gone
+				polling = polling || !signaling[i] || rb.IsClosed()
+				continue
+			}
+
+			got = true
+			select {
+			case ch <- TaggedItem{Index: i, Item: it}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if got || live == 0 {
+			retry = 0
+			continue
+		}
+
+		// all sources are empty, wait till something arrives
+		var tick <-chan time.Time
+		if polling {
+			timer.Reset(fanInBackoff.duration(retry))
+			tick = timer.C
+			retry++
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+			if polling && !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-tick:
+		}
+	}
+}
+
+// signalsOf returns the signaling ring buffer behind q, or nil if q
+// doesn't signal, see WithSignaling.
+func signalsOf(q RingBuffer) *signalRingBuf {
+	if l, ok := q.(*latencyRingBuf); ok {
+		q = l.RingBuffer
+	}
+	s, _ := q.(*signalRingBuf)
+	return s
+}
+
+// forwardSignals passes the not-empty signals of s on to wake, till
+// s closed or ctx done.
+func forwardSignals(ctx context.Context, s *signalRingBuf, wake chan struct{}) {
+	for {
+		select {
+		case <-s.notEmpty:
+			signal(wake)
+		case <-s.done:
+			signal(wake)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fanInBackoff is how FanIn waits on the sources polled.
+var fanInBackoff = SleepBackoff{Min: time.Microsecond, Max: 100 * time.Microsecond}
@@ -0,0 +1,100 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFanInRoundRobin(t *testing.T) {
+	busy, quiet := New(16), New(16)
+	for _, it := range []string{"a0", "a1", "a2", "a3"} {
+		_ = busy.Enqueue(it)
+	}
+	for _, it := range []string{"b0", "b1"} {
+		_ = quiet.Enqueue(it)
+	}
+	_, _ = busy.CloseWrite(), quiet.CloseWrite()
+
+	want := []TaggedItem{{0, "a0"}, {1, "b0"}, {0, "a1"}, {1, "b1"}, {0, "a2"}, {0, "a3"}}
+	var got []TaggedItem
+	for ti := range FanIn(context.Background(), busy, quiet) {
+		got = append(got, ti)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFanInLate(t *testing.T) {
+	a, b := New(4), New(4)
+	ch := FanIn(context.Background(), a, b)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = b.Enqueue("late")
+		_, _ = a.CloseWrite(), b.CloseWrite()
+	}()
+	if ti := <-ch; ti.Index != 1 || ti.Item != "late" {
+		t.Fatalf("got %v, want the item of source 1", ti)
+	}
+	if ti, ok := <-ch; ok {
+		t.Fatalf("got %v after all the sources closed", ti)
+	}
+}
+
+func TestFanInCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := FanIn(ctx, New(4))
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("got an item from an empty source")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the channel isn't closed after ctx cancelled")
+	}
+}
+
+// TestFanInSignaling mixes the sources of WithSignaling, on which
+// FanIn waits for the signals, with a polled one.
+func TestFanInSignaling(t *testing.T) {
+	a, b := New(4, WithSignaling(true)), New(4, WithSignaling(true), WithLatencyTracking(true))
+	polled := New(4)
+	ch := FanIn(context.Background(), a, b, polled)
+
+	for _, src := range []struct {
+		index int
+		q     RingBuffer
+	}{{1, b}, {2, polled}, {0, a}} {
+		time.Sleep(5 * time.Millisecond)
+		_ = src.q.Enqueue("late")
+		select {
+		case ti := <-ch:
+			if ti.Index != src.index || ti.Item != "late" {
+				t.Fatalf("got %v, want the item of source %v", ti, src.index)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the item of source %v isn't delivered", src.index)
+		}
+	}
+
+	_, _, _ = a.CloseWrite(), b.CloseWrite(), polled.CloseWrite()
+	select {
+	case ti, ok := <-ch:
+		if ok {
+			t.Fatalf("got %v after all the sources closed", ti)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the channel isn't closed after all the sources closed")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"sync"
+)
+
+// Distributor is a pool of workers competing on one ring buffer,
+// see FanOut.
+type Distributor struct {
+	rb      RingBuffer
+	handler func(item interface{})
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// FanOut spawns workers goroutines which compete via Dequeue on rb,
+// and calls handler with each item. Every item is delivered to
+// exactly one worker.
+//
+// The workers exit after Stop called, or rb closed and drained.
+func FanOut(rb RingBuffer, workers int, handler func(item interface{})) *Distributor {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Distributor{rb: rb, handler: handler, cancel: cancel}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work(ctx)
+	}
+	return d
+}
+
+// Stop asks the workers to exit, and waits until all of them are
+// done. The items left in the buffer at the moment will be handled
+// before the workers exit, so none is lost.
+func (d *Distributor) Stop() {
+	d.once.Do(d.cancel)
+	d.wg.Wait()
+}
+
+func (d *Distributor) work(ctx context.Context) {
+	defer d.wg.Done()
+
+	for {
+		it, err := d.rb.BlockingDequeue(ctx)
+		if err == nil {
+			d.handler(it)
+			continue
+		}
+		if err == ErrClosed {
+			return
+		}
+		if ctx.Err() != nil {
+			d.drain()
+			return
+		}
+	}
+}
+
+// drain handles the remained items without waiting.
+func (d *Distributor) drain() {
+	for {
+		it, err := d.rb.GetTimeout(0)
+		if err == ErrQueueEmpty || err == ErrClosed {
+			return
+		}
+		if err == nil {
+			d.handler(it)
+		}
+	}
+}
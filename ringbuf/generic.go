@@ -0,0 +1,192 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+type (
+	// Ring is a type-parameterized ring buffer.
+	//
+	// It shares the lock-free algorithm with RingBuffer, but stores
+	// T in the slots directly so that no interface boxing is needed
+	// on Enqueue and no type assertion on Dequeue.
+	Ring[T any] struct {
+		cap        uint32
+		capModMask uint32
+		_          [CacheLinePadSize - 8]byte
+		head       uint32
+		_          [CacheLinePadSize - 4]byte
+		tail       uint32
+		_          [CacheLinePadSize - 4]byte
+		data       []ringItem[T]
+		debugMode  bool
+		logger     Logger
+	}
+
+	ringItem[T any] struct {
+		readWrite uint64 // 0: writable, 1: readable, 2: write ok, 3: read ok
+		value     T
+	}
+
+	// RingOpt is the functional option of Ring
+	RingOpt[T any] func(r *Ring[T])
+)
+
+// NewRing returns a Ring object of T
+func NewRing[T any](capacity uint32, opts ...RingOpt[T]) *Ring[T] {
+	size := roundUpToPower2(capacity)
+	r := &Ring[T]{
+		data:       make([]ringItem[T], size),
+		cap:        size,
+		capModMask: size - 1, // = 2^n - 1
+		logger:     nopLogger{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithRingDebugMode enables the internal debug mode for more logging output
+func WithRingDebugMode[T any](debug bool) RingOpt[T] {
+	return func(r *Ring[T]) {
+		r.debugMode = debug
+	}
+}
+
+// WithRingLogger setup a logger
+func WithRingLogger[T any](logger Logger) RingOpt[T] {
+	return func(r *Ring[T]) {
+		if logger != nil {
+			r.logger = logger
+		}
+	}
+}
+
+func (r *Ring[T]) Enqueue(item T) (err error) {
+	var tail, head uint32
+	var holder *ringItem[T]
+	for {
+		head = atomic.LoadUint32(&r.head)
+		tail = atomic.LoadUint32(&r.tail)
+
+		if r.qty(head, tail) >= r.capModMask {
+			err = ErrQueueFull
+			return
+		}
+
+		if !atomic.CompareAndSwapUint32(&r.tail, tail, tail+1) {
+			runtime.Gosched() // time to time
+			continue
+		}
+
+		holder = &r.data[tail&r.capModMask]
+		for !atomic.CompareAndSwapUint64(&holder.readWrite, 0, 2) {
+			runtime.Gosched() // a slow consumer is still reading the previous lap
+		}
+
+		holder.value = item
+		if !atomic.CompareAndSwapUint64(&holder.readWrite, 2, 1) {
+			err = ErrRaced // never happens
+		}
+		if r.debugMode {
+			r.logger.Debugf("[W] tail %v => %v, head: %v | ENQUEUED value = %v", tail, tail+1, head, item)
+		}
+		return
+	}
+}
+
+func (r *Ring[T]) Dequeue() (item T, err error) {
+	var tail, head uint32
+	var holder *ringItem[T]
+	var zero T
+	for {
+		head = atomic.LoadUint32(&r.head)
+		tail = atomic.LoadUint32(&r.tail)
+
+		if head == tail {
+			err = ErrQueueEmpty
+			return
+		}
+
+		if !atomic.CompareAndSwapUint32(&r.head, head, head+1) {
+			runtime.Gosched() // time to time
+			continue
+		}
+
+		holder = &r.data[head&r.capModMask]
+		for !atomic.CompareAndSwapUint64(&holder.readWrite, 1, 3) {
+			runtime.Gosched() // the producer is still writing this slot
+		}
+
+		item, holder.value = holder.value, zero
+		if !atomic.CompareAndSwapUint64(&holder.readWrite, 3, 0) {
+			err = ErrRaced // never happens
+		}
+		if r.debugMode {
+			r.logger.Debugf("[ringbuf][GET] cap=%v, tail=%v, head=%v, new head=%v, item=%v", r.cap, tail, head, head+1, item)
+		}
+		return
+	}
+}
+
+func (r *Ring[T]) Put(item T) (err error) { return r.Enqueue(item) }
+
+func (r *Ring[T]) Get() (item T, err error) { return r.Dequeue() }
+
+func (r *Ring[T]) Cap() uint32 { return r.cap }
+
+func (r *Ring[T]) CapReal() uint32 { return r.capModMask }
+
+// Quantity is an alias of Len.
+func (r *Ring[T]) Quantity() uint32 { return r.Len() }
+
+// Size is an alias of Len.
+func (r *Ring[T]) Size() uint32 { return r.Len() }
+
+func (r *Ring[T]) Len() (quantity uint32) {
+	head := atomic.LoadUint32(&r.head)
+	tail := atomic.LoadUint32(&r.tail)
+	return r.qty(head, tail)
+}
+
+func (r *Ring[T]) qty(head, tail uint32) (quantity uint32) {
+	quantity = tail - head // wraps around correctly since both are free-running
+	if quantity > r.capModMask {
+		quantity = r.capModMask // head was loaded before a concurrent dequeue
+	}
+	return
+}
+
+func (r *Ring[T]) IsEmpty() bool {
+	return atomic.LoadUint32(&r.head) == atomic.LoadUint32(&r.tail)
+}
+
+func (r *Ring[T]) IsFull() bool {
+	head := atomic.LoadUint32(&r.head)
+	tail := atomic.LoadUint32(&r.tail)
+	return r.qty(head, tail) >= r.capModMask
+}
+
+// Reset will clear the whole queue without reallocating the slots.
+// See also RingBuffer.Reset.
+func (r *Ring[T]) Reset(force bool) (err error) {
+	if !force && r.Size() != 0 {
+		return ErrQueueNotEmpty
+	}
+	var zero T
+	for i := range r.data {
+		r.data[i].readWrite, r.data[i].value = 0, zero
+	}
+	atomic.StoreUint32(&r.head, 0)
+	atomic.StoreUint32(&r.tail, 0)
+	return
+}
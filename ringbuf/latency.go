@@ -0,0 +1,219 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets is the count of the buckets of LatencySnapshot, the
+// last one takes all the durations longer than it's for.
+const LatencyBuckets = 40
+
+type (
+	// LatencyTracker is implemented by the ring buffers created with
+	// WithLatencyTracking(true).
+	LatencyTracker interface {
+		// Latencies returns the histograms of the time spent in
+		// the enqueues and the dequeues, since created or
+		// ResetCounters.
+		Latencies() (enqueue, dequeue LatencySnapshot)
+	}
+
+	// LatencySnapshot is a histogram of the latencies, the bucket i
+	// counts the operations which took [2^(i-1), 2^i) nanoseconds,
+	// see LatencyBucketBound.
+	LatencySnapshot struct {
+		Count   uint64
+		Sum     time.Duration
+		Buckets [LatencyBuckets]uint64
+	}
+
+	latencyHistogram struct {
+		counts [LatencyBuckets]uint64
+		sum    uint64 // in nanoseconds
+	}
+
+	// latencyRingBuf is the ring buffer for WithLatencyTracking(true),
+	// it wraps the one under it and times the operations on single
+	// items.
+	latencyRingBuf struct {
+		RingBuffer
+		enq latencyHistogram
+		deq latencyHistogram
+	}
+)
+
+// LatencyBucketBound returns the upper bound of the bucket i of
+// LatencySnapshot, that is 2^i nanoseconds.
+func LatencyBucketBound(i int) time.Duration {
+	return time.Duration(1) << uint(i)
+}
+
+// Quantile returns the upper bound of the bucket in which the
+// q-quantile (0 < q <= 1) falls, such as Quantile(0.99) for the p99.
+// It's accurate to a factor of 2, zero returned if no operation.
+func (s LatencySnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(s.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range s.Buckets {
+		if seen += n; seen >= rank {
+			return LatencyBucketBound(i)
+		}
+	}
+	return LatencyBucketBound(LatencyBuckets - 1)
+}
+
+// Mean returns the average latency, zero if no operation.
+func (s LatencySnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+func (h *latencyHistogram) observe(since time.Time) {
+	d := time.Since(since)
+	i := bits.Len64(uint64(d))
+	if i >= LatencyBuckets {
+		i = LatencyBuckets - 1
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+func (h *latencyHistogram) snapshot() (s LatencySnapshot) {
+	for i := range h.counts {
+		s.Buckets[i] = atomic.LoadUint64(&h.counts[i])
+		s.Count += s.Buckets[i]
+	}
+	s.Sum = time.Duration(atomic.LoadUint64(&h.sum))
+	return
+}
+
+func (h *latencyHistogram) reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+	atomic.StoreUint64(&h.sum, 0)
+}
+
+// Latencies implements LatencyTracker.
+func (rb *latencyRingBuf) Latencies() (enqueue, dequeue LatencySnapshot) {
+	return rb.enq.snapshot(), rb.deq.snapshot()
+}
+
+func (rb *latencyRingBuf) ResetCounters() {
+	rb.RingBuffer.ResetCounters()
+	rb.enq.reset()
+	rb.deq.reset()
+}
+
+func (rb *latencyRingBuf) GetGetWaits() uint64 {
+	return rb.RingBuffer.(Dbg).GetGetWaits()
+}
+
+func (rb *latencyRingBuf) GetPutWaits() uint64 {
+	return rb.RingBuffer.(Dbg).GetPutWaits()
+}
+
+func (rb *latencyRingBuf) Put(item interface{}) (err error) {
+	err = rb.Enqueue(item)
+	return
+}
+
+func (rb *latencyRingBuf) Enqueue(item interface{}) (err error) {
+	ts := time.Now()
+	if err = rb.RingBuffer.Enqueue(item); err == nil {
+		rb.enq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) EnqueueFrom(fn func() interface{}) (err error) {
+	ts := time.Now()
+	if err = rb.RingBuffer.EnqueueFrom(fn); err == nil {
+		rb.enq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) TryEnqueue(item interface{}) (ok bool) {
+	ts := time.Now()
+	if ok = rb.RingBuffer.TryEnqueue(item); ok {
+		rb.enq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool) {
+	return enqueueOrDrop(rb, item, onDrop)
+}
+
+func (rb *latencyRingBuf) BlockingEnqueue(ctx context.Context, item interface{}) (err error) {
+	ts := time.Now()
+	if err = rb.RingBuffer.BlockingEnqueue(ctx, item); err == nil {
+		rb.enq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) Get() (item interface{}, err error) {
+	item, err = rb.Dequeue()
+	return
+}
+
+func (rb *latencyRingBuf) Dequeue() (item interface{}, err error) {
+	ts := time.Now()
+	if item, err = rb.RingBuffer.Dequeue(); err == nil {
+		rb.deq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) DequeueInto(fn func(item interface{})) (err error) {
+	return dequeueInto(rb, fn)
+}
+
+func (rb *latencyRingBuf) TryDequeue() (item interface{}, ok bool) {
+	ts := time.Now()
+	if item, ok = rb.RingBuffer.TryDequeue(); ok {
+		rb.deq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) BlockingDequeue(ctx context.Context) (item interface{}, err error) {
+	ts := time.Now()
+	if item, err = rb.RingBuffer.BlockingDequeue(ctx); err == nil {
+		rb.deq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) PutTimeout(item interface{}, d time.Duration) (err error) {
+	ts := time.Now()
+	if err = rb.RingBuffer.PutTimeout(item, d); err == nil {
+		rb.enq.observe(ts)
+	}
+	return
+}
+
+func (rb *latencyRingBuf) GetTimeout(d time.Duration) (item interface{}, err error) {
+	ts := time.Now()
+	if item, err = rb.RingBuffer.GetTimeout(d); err == nil {
+		rb.deq.observe(ts)
+	}
+	return
+}
@@ -0,0 +1,22 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+type (
+	// Logger is the minimal logging interface used by the ring
+	// buffer. github.com/hedzr/log.Logger satisfies it, so does a
+	// *zap.SugaredLogger.
+	Logger interface {
+		Debugf(msg string, args ...interface{})
+		Warnf(msg string, args ...interface{})
+		Errorf(msg string, args ...interface{})
+	}
+
+	nopLogger struct{}
+)
+
+func (nopLogger) Debugf(msg string, args ...interface{}) {}
+func (nopLogger) Warnf(msg string, args ...interface{})  {}
+func (nopLogger) Errorf(msg string, args ...interface{}) {}
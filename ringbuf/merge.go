@@ -0,0 +1,154 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Merger consumes several ring buffers as one, see Merge.
+	Merger struct {
+		bufs   []RingBuffer
+		policy MergePolicy
+	}
+
+	// MergePolicy decides the input of a Merger to try first on each
+	// dequeue, the inputs after it are tried in turn if it's empty.
+	// It's called concurrently if the Merger is.
+	MergePolicy interface {
+		// Next returns the index of the input to try first, in
+		// [0, n).
+		Next(n int) int
+	}
+
+	// MergeFeedback is the optional interface of a MergePolicy to
+	// learn the input which an item was actually dequeued from.
+	MergeFeedback interface {
+		Served(index int)
+	}
+
+	// RoundRobin is the MergePolicy trying the inputs one after
+	// another, starting from the one next to the input served last
+	// time, so that every non-empty input gets the same share.
+	RoundRobin struct {
+		next uint32
+	}
+
+	// Weighted is the MergePolicy sharing the inputs by Weights, the
+	// input i is tried first Weights[i] times out of the sum of them,
+	// interleaved smoothly (as the weighted round-robin of nginx)
+	// rather than in bursts.
+	Weighted struct {
+		// Weights of the inputs, the missing or non-positive ones
+		// are taken as 1.
+		Weights []int
+		mu      sync.Mutex
+		current []int
+	}
+)
+
+// Merge returns a Merger which dequeues from bufs round-robin, so
+// that a busy buffer cannot starve the others. It's the pull-style
+// counterpart of FanIn, without a goroutine.
+func Merge(bufs ...RingBuffer) *Merger {
+	return MergeWith(&RoundRobin{}, bufs...)
+}
+
+// MergeWith returns a Merger which dequeues from bufs in the order
+// decided by policy, such as &Weighted{Weights: []int{3, 1}}.
+func MergeWith(policy MergePolicy, bufs ...RingBuffer) *Merger {
+	return &Merger{bufs: bufs, policy: policy}
+}
+
+// Dequeue takes an item from the input picked by the policy, or the
+// first non-empty one after it, and returns the index of the input.
+// ErrQueueEmpty returned only if all the inputs are empty, and
+// ErrClosed if all of them have been closed and drained.
+func (m *Merger) Dequeue() (item interface{}, index int, err error) {
+	n := len(m.bufs)
+	if n == 0 {
+		return nil, -1, ErrQueueEmpty
+	}
+
+	closed := 0
+	start := m.policy.Next(n)
+	for i := 0; i < n; i++ {
+		index = (start + i) % n
+		if item, err = m.bufs[index].Dequeue(); err == nil {
+			if fb, ok := m.policy.(MergeFeedback); ok {
+				fb.Served(index)
+			}
+			return
+		} else if err == ErrClosed {
+			closed++
+		} else if err != ErrQueueEmpty {
+			return
+		}
+	}
+
+	index, err = -1, ErrQueueEmpty
+	if closed == n {
+		err = ErrClosed
+	}
+	return
+}
+
+// BlockingDequeue waits until an item is available in any input, or
+// ctx is done. In the latter case ctx.Err() returned.
+func (m *Merger) BlockingDequeue(ctx context.Context) (item interface{}, index int, err error) {
+	for retry := 0; ; retry++ {
+		if item, index, err = m.Dequeue(); err != ErrQueueEmpty {
+			return
+		}
+		if err = backoff(ctx, retry, spinsBeforeSleep); err != nil {
+			return
+		}
+	}
+}
+
+// Len returns the total quantity of items in all the inputs.
+func (m *Merger) Len() (quantity uint32) {
+	for _, rb := range m.bufs {
+		quantity += rb.Len()
+	}
+	return
+}
+
+// Next implements MergePolicy.
+func (p *RoundRobin) Next(n int) int {
+	return int((atomic.AddUint32(&p.next, 1) - 1) % uint32(n))
+}
+
+// Served implements MergeFeedback.
+func (p *RoundRobin) Served(index int) {
+	atomic.StoreUint32(&p.next, uint32(index+1))
+}
+
+// Next implements MergePolicy.
+func (p *Weighted) Next(n int) (index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.current) != n {
+		p.current = make([]int, n)
+	}
+	total := 0
+	for i := range p.current {
+		w := 1
+		if i < len(p.Weights) && p.Weights[i] > 0 {
+			w = p.Weights[i]
+		}
+		p.current[i] += w
+		total += w
+		if p.current[i] > p.current[index] {
+			index = i
+		}
+	}
+	p.current[index] -= total
+	return
+}
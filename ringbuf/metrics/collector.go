@@ -0,0 +1,97 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+// Package metrics exports the internals of ringbuf.RingBuffer to
+// Prometheus.
+//
+// It's a standalone package so that the core ringbuf doesn't pull
+// in the Prometheus client.
+package metrics
+
+import (
+	"github.com/hedzr/go-socketlib/ringbuf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements prometheus.Collector for a named ring buffer.
+type Collector struct {
+	rb       ringbuf.RingBuffer
+	quantity *prometheus.Desc
+	capacity *prometheus.Desc
+	putWaits *prometheus.Desc
+	getWaits *prometheus.Desc
+	enqueue  *prometheus.Desc
+	dequeue  *prometheus.Desc
+}
+
+// NewCollector returns a Collector for rb. The metrics are labeled
+// with name so that multiple buffers can be tracked by one registry.
+func NewCollector(rb ringbuf.RingBuffer, name string) *Collector {
+	labels := prometheus.Labels{"name": name}
+	return &Collector{
+		rb: rb,
+		quantity: prometheus.NewDesc("ringbuf_quantity",
+			"The quantity of items in the ring buffer.", nil, labels),
+		capacity: prometheus.NewDesc("ringbuf_capacity",
+			"The capacity of the ring buffer.", nil, labels),
+		putWaits: prometheus.NewDesc("ringbuf_put_waits_total",
+			"The times of waiting for a free slot to enqueue.", nil, labels),
+		getWaits: prometheus.NewDesc("ringbuf_get_waits_total",
+			"The times of waiting for an item to dequeue.", nil, labels),
+		enqueue: prometheus.NewDesc("ringbuf_enqueue_latency_seconds",
+			"The latency of enqueuing an item, see ringbuf.WithLatencyTracking.", nil, labels),
+		dequeue: prometheus.NewDesc("ringbuf_dequeue_latency_seconds",
+			"The latency of dequeuing an item, see ringbuf.WithLatencyTracking.", nil, labels),
+	}
+}
+
+// RegisterMetrics creates a Collector for rb and registers it into reg.
+func RegisterMetrics(reg prometheus.Registerer, name string, rb ringbuf.RingBuffer) (c *Collector, err error) {
+	c = NewCollector(rb, name)
+	if err = reg.Register(c); err != nil {
+		c = nil
+	}
+	return
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.quantity
+	ch <- c.capacity
+	if _, ok := c.rb.(ringbuf.Dbg); ok {
+		ch <- c.putWaits
+		ch <- c.getWaits
+	}
+	if _, ok := c.rb.(ringbuf.LatencyTracker); ok {
+		ch <- c.enqueue
+		ch <- c.dequeue
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.quantity, prometheus.GaugeValue, float64(c.rb.Quantity()))
+	ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(c.rb.Cap()))
+	if dbg, ok := c.rb.(ringbuf.Dbg); ok {
+		ch <- prometheus.MustNewConstMetric(c.putWaits, prometheus.CounterValue, float64(dbg.GetPutWaits()))
+		ch <- prometheus.MustNewConstMetric(c.getWaits, prometheus.CounterValue, float64(dbg.GetGetWaits()))
+	}
+	if lt, ok := c.rb.(ringbuf.LatencyTracker); ok {
+		enq, deq := lt.Latencies()
+		ch <- latencyHistogram(c.enqueue, enq)
+		ch <- latencyHistogram(c.dequeue, deq)
+	}
+}
+
+// latencyHistogram converts s into a histogram in seconds, whose
+// buckets are cumulative as Prometheus requires.
+func latencyHistogram(desc *prometheus.Desc, s ringbuf.LatencySnapshot) prometheus.Metric {
+	buckets := make(map[float64]uint64, ringbuf.LatencyBuckets)
+	var count uint64
+	for i, n := range s.Buckets[:ringbuf.LatencyBuckets-1] {
+		count += n
+		buckets[ringbuf.LatencyBucketBound(i).Seconds()] = count
+	}
+	return prometheus.MustNewConstHistogram(desc, s.Count, s.Sum.Seconds(), buckets)
+}
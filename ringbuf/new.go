@@ -0,0 +1,246 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"gopkg.in/hedzr/errors.v2"
+	"time"
+)
+
+// New returns the RingBuffer object
+//
+// The capacity will be rounded up to a power of 2, unless
+// WithExactCapacity(true) is specified. In both modes, one slot is
+// reserved to distinguish a full queue from an empty one, so the
+// usable slots are Cap()-1 (= CapReal()).
+func New(capacity uint32, opts ...Opt) (ringBuffer RingBuffer) {
+	size := roundUpToPower2(capacity)
+
+	rb := &ringBuf{
+		head:       0,
+		tail:       0,
+		cap:        size,
+		capModMask: size - 1, // = 2^n - 1
+		limit:      size - 1,
+		waiter:     DefaultWaitStrategy,
+		logger:     nopLogger{},
+		spins:      -1,
+	}
+
+	ringBuffer = rb
+
+	for _, opt := range opts {
+		opt(rb)
+	}
+	rb.applySpins()
+
+	if rb.exactCap && capacity > 1 {
+		// the slots are still allocated in power of 2 so that the
+		// free-running head and tail can be masked, but only the
+		// requested count of them will be used.
+		rb.cap = capacity
+		rb.limit = capacity - 1
+	}
+
+	if rb.blockingMode {
+		ringBuffer = rb.tracked(newCondRingBuf(rb))
+		return
+	}
+
+	if rb.alloc != nil {
+		if rb.data = rb.alloc(size); uint32(len(rb.data)) != size {
+			panic(errors.Wrap(ErrBadBackingStore, "%v slots allocated, %v expected", len(rb.data), size))
+		}
+	} else {
+		rb.data = make([]rbItem, size)
+	}
+	for i := 0; i < (int)(size); i++ {
+		rb.data[i].readWrite &= 0 // bit 0: readable, bit 1: writable
+		if rb.initializer != nil {
+			rb.data[i].value = rb.initializer.PreAlloc(i)
+		}
+	}
+	base := rb
+	if rb.fair {
+		f := newFairRingBuf(rb)
+		ringBuffer, base = f, &f.ringBuf
+	}
+	if rb.signaling {
+		ringBuffer = newSignalRingBuf(ringBuffer, base)
+	}
+	ringBuffer = rb.tracked(ringBuffer)
+	return
+}
+
+// tracked wraps q for WithLatencyTracking, or returns it as is.
+func (rb *ringBuf) tracked(q RingBuffer) RingBuffer {
+	if !rb.latency {
+		return q
+	}
+	return &latencyRingBuf{RingBuffer: q}
+}
+
+// Opt interface the functional options
+type Opt func(buf *ringBuf)
+
+// WithItemInitializer provides your custom initializer for each data item.
+func WithItemInitializer(initializeable Initializeable) Opt {
+	return func(buf *ringBuf) {
+		buf.initializer = initializeable
+	}
+}
+
+// WithExactCapacity makes Cap() report the requested capacity
+// rather than rounding it up to a power of 2, and limits the usable
+// slots to Cap()-1 accordingly.
+func WithExactCapacity(exact bool) Opt {
+	return func(buf *ringBuf) {
+		buf.exactCap = exact
+	}
+}
+
+// WithWaitStrategy specifies how to wait on contention, the
+// default is DefaultWaitStrategy.
+func WithWaitStrategy(ws WaitStrategy) Opt {
+	return func(buf *ringBuf) {
+		if ws != nil {
+			buf.waiter = ws
+		}
+	}
+}
+
+// WithSpinsBeforeSleep makes the retry loops of enqueuing and
+// dequeuing yield the processor by runtime.Gosched for up to n
+// retries before falling back to sleeping, 30 by default. A larger
+// n avoids the sleeping under the brief contention on a multi-core
+// machine, at the cost of the CPU time; 0 sleeps at once.
+//
+// It applies to the waiting of BlockingEnqueue, BlockingDequeue,
+// PutTimeout and GetTimeout (WithBlockingMode waits on the condition
+// variables instead), and overrides Hybrid.Spins if the wait
+// strategy (see WithWaitStrategy) is a Hybrid, such as the default
+// one. A negative n is ignored.
+func WithSpinsBeforeSleep(n int) Opt {
+	return func(buf *ringBuf) {
+		if n >= 0 {
+			buf.spins = n
+		}
+	}
+}
+
+// applySpins resolves the spins after the options applied, see
+// WithSpinsBeforeSleep.
+func (rb *ringBuf) applySpins() {
+	if rb.spins < 0 {
+		rb.spins = spinsBeforeSleep
+		return
+	}
+	if h, ok := rb.waiter.(Hybrid); ok {
+		h.Spins = rb.spins
+		rb.waiter = h
+	}
+}
+
+// WithBackingStore lets alloc provide the slots of the lock-free
+// ring buffer instead of make, such as the memory aligned, pinned or
+// backed by the huge pages, for the NUMA-aware deployments. n is the
+// capacity rounded up to a power of 2, even in WithExactCapacity,
+// and New panics with ErrBadBackingStore if len of the returned
+// slice isn't n. The slots are reset by New.
+//
+// It's ignored by WithBlockingMode and NewSPSC, which keep the items
+// in a plain slice.
+func WithBackingStore(alloc func(n uint32) []Slot) Opt {
+	return func(buf *ringBuf) {
+		buf.alloc = alloc
+	}
+}
+
+// WithFairScheduling makes New return the bounded MPMC queue of
+// Dmitry Vyukov, in which the slot carries a sequence number instead
+// of the read/write state. A producer checks the slot before
+// competing for the tail and owns it once won, so that no producer
+// waits on a slot held by another one, and every lost CAS means
+// another producer has succeeded. It narrows the tail latency of
+// the producers under the heavy contention, such as dozens of them.
+//
+// It's still lock-free rather than wait-free, a producer may lose
+// again and again in theory. WithBlockingMode takes precedence.
+func WithFairScheduling(fair bool) Opt {
+	return func(buf *ringBuf) {
+		buf.fair = fair
+	}
+}
+
+// WithSignaling makes the blocked producers and consumers (see
+// BlockingEnqueue and BlockingDequeue) wait on the channels, instead
+// of yielding and sleeping in turn. A successful enqueue wakes up a
+// consumer by a non-blocking send, and vice versa. No CPU is burnt
+// while waiting, and the waiting ends as soon as the item arrives,
+// at the cost of a channel operation per enqueue and dequeue.
+//
+// The non-blocking operations work as before, it applies over
+// WithFairScheduling, but is ignored in WithBlockingMode, which
+// never burns the CPU either.
+func WithSignaling(signaling bool) Opt {
+	return func(buf *ringBuf) {
+		buf.signaling = signaling
+	}
+}
+
+// WithLatencyTracking records the time spent in each successful
+// enqueue and dequeue of a single item, including the waiting of the
+// blocking ones, into the histograms read by LatencyTracker:
+//
+//	enq, deq := rb.(ringbuf.LatencyTracker).Latencies()
+//	log.Printf("enqueue p50=%v p99=%v", enq.Quantile(0.5), enq.Quantile(0.99))
+//
+// It costs two clock readings and two atomic adds per operation, and
+// nothing if disabled. The batch operations (EnqueueMany, ...) aren't
+// tracked. NewPriority ignores it.
+func WithLatencyTracking(enabled bool) Opt {
+	return func(buf *ringBuf) {
+		buf.latency = enabled
+	}
+}
+
+// WithBlockingMode makes New return a ring buffer guarded by a mutex
+// and two condition variables, in which Enqueue waits for a free slot
+// and Dequeue waits for an item, instead of returning ErrQueueFull
+// or ErrQueueEmpty.
+//
+// It trades some throughput for no CPU burning while the producers
+// or the consumers are idle.
+func WithBlockingMode(blocking bool) Opt {
+	return func(buf *ringBuf) {
+		buf.blockingMode = blocking
+	}
+}
+
+// WithItemTTL discards the items which have stayed in the queue
+// longer than d, Dequeue skips them and returns the first live one.
+// Only the items being dequeued are checked, no scanning happens.
+// See also RingBuffer.ExpiredCount.
+func WithItemTTL(d time.Duration) Opt {
+	return func(buf *ringBuf) {
+		buf.ttl = d
+	}
+}
+
+// WithDebugMode enables the internal debug mode for more logging output, and collect the metrics for debugging
+func WithDebugMode(debug bool) Opt {
+	return func(buf *ringBuf) {
+		buf.debugMode = debug
+	}
+}
+
+// WithLogger setup a logger, the default one discards everything.
+func WithLogger(logger Logger) Opt {
+	return func(buf *ringBuf) {
+		if logger != nil {
+			buf.logger = logger
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// fifoKinds are the queues which are FIFO across the producers, see
+// Ordering in the package doc.
+func fifoKinds() (ks []struct {
+	name string
+	new  func(capacity uint32) RingBuffer
+}) {
+	for _, k := range kinds {
+		if k.name != "spsc" && k.name != "priority" {
+			ks = append(ks, k)
+		}
+	}
+	return
+}
+
+// enqueue retries on a full queue.
+func enqueue(q RingBuffer, item interface{}) {
+	for errors.Is(q.Enqueue(item), ErrQueueFull) {
+		runtime.Gosched()
+	}
+}
+
+// TestOrderAcrossProducers serializes the enqueues of the producers
+// by a lock, the consumer must see them in the same order.
+func TestOrderAcrossProducers(t *testing.T) {
+	const producers, n = 4, 4000
+	for _, k := range fifoKinds() {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			var mu sync.Mutex
+			seq := 0
+			var wg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						mu.Lock()
+						if seq == n {
+							mu.Unlock()
+							return
+						}
+						enqueue(q, seq) // returns before the next one is called
+						seq++
+						mu.Unlock()
+					}
+				}()
+			}
+			go func() {
+				wg.Wait()
+				_ = q.CloseWrite()
+			}()
+
+			want := 0
+			for {
+				item, err := q.Dequeue()
+				if errors.Is(err, ErrQueueEmpty) {
+					runtime.Gosched()
+					continue
+				}
+				if errors.Is(err, ErrClosed) {
+					break
+				}
+				if err != nil || item != want {
+					t.Fatalf("got %v, %v, want %v", item, err, want)
+				}
+				want++
+			}
+			if want != n {
+				t.Fatalf("got %v items, want %v", want, n)
+			}
+		})
+	}
+}
+
+// TestOrderPerProducer lets the producers race, the items of each
+// one must keep its order, and the batches of BlockingEnqueueMany
+// stay contiguous.
+func TestOrderPerProducer(t *testing.T) {
+	const producers, batches, batch = 4, 200, 4
+	for _, k := range fifoKinds() {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(64)
+			var wg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					for b := 0; b < batches; b++ {
+						items := make([]interface{}, batch)
+						for i := range items {
+							items[i] = [2]int{p, b*batch + i}
+						}
+						if err := q.BlockingEnqueueMany(context.Background(), items); err != nil {
+							t.Errorf("BlockingEnqueueMany: %v", err)
+							return
+						}
+					}
+				}(p)
+			}
+			go func() {
+				wg.Wait()
+				_ = q.CloseWrite()
+			}()
+
+			next, mid := make([]int, producers), -1 // mid: the producer of an unfinished batch
+			for {
+				item, err := q.Dequeue()
+				if errors.Is(err, ErrQueueEmpty) {
+					runtime.Gosched()
+					continue
+				}
+				if errors.Is(err, ErrClosed) {
+					break
+				}
+				it := item.([2]int)
+				if mid >= 0 && it[0] != mid {
+					t.Fatalf("the batch of producer %v is interleaved by #%v of producer %v", mid, it[1], it[0])
+				}
+				if it[1] != next[it[0]] {
+					t.Fatalf("producer %v: got #%v, want #%v", it[0], it[1], next[it[0]])
+				}
+				next[it[0]]++
+				if mid = it[0]; next[mid]%batch == 0 {
+					mid = -1
+				}
+			}
+			for p, n := range next {
+				if n != batches*batch {
+					t.Fatalf("producer %v: got %v items, want %v", p, n, batches*batch)
+				}
+			}
+		})
+	}
+}
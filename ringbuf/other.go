@@ -0,0 +1,173 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"sync/atomic"
+)
+
+func (rb *ringBuf) GetGetWaits() uint64 {
+	return atomic.LoadUint64(&rb.getWaits)
+}
+
+func (rb *ringBuf) GetPutWaits() uint64 {
+	return atomic.LoadUint64(&rb.putWaits)
+}
+
+func (rb *ringBuf) ExpiredCount() uint64 {
+	return atomic.LoadUint64(&rb.expired)
+}
+
+func (rb *ringBuf) ResetCounters() {
+	atomic.StoreUint64(&rb.getWaits, 0)
+	atomic.StoreUint64(&rb.putWaits, 0)
+	atomic.StoreUint64(&rb.expired, 0)
+	atomic.StoreUint32(&rb.highWater, 0)
+}
+
+func (rb *ringBuf) HighWaterMark() uint32 {
+	return atomic.LoadUint32(&rb.highWater)
+}
+
+// Close is CloseWrite, for io.Closer.
+func (rb *ringBuf) Close() (err error) {
+	return rb.CloseWrite()
+}
+
+// CloseWrite marks the queue closed. Enqueue returns ErrClosed after
+// closed, and Dequeue returns ErrClosed once the remained items are
+// drained.
+func (rb *ringBuf) CloseWrite() (err error) {
+	atomic.StoreUint32(&rb.closed, 1)
+	return
+}
+
+// CloseAndSignal puts the marker if there is a free slot, and closes
+// the queue, see RingBuffer.CloseAndSignal.
+func (rb *ringBuf) CloseAndSignal() (err error) {
+	return closeAndSignal(rb, rb.initializer != nil)
+}
+
+func (rb *ringBuf) IsClosed() bool {
+	return atomic.LoadUint32(&rb.closed) == 1
+}
+
+// emptyOrClosed tells ErrClosed from ErrQueueEmpty after the queue
+// was found empty at tail.
+func (rb *ringBuf) emptyOrClosed(tail uint32) error {
+	if rb.IsClosed() && atomic.LoadUint32(&rb.tail) == tail {
+		return ErrClosed
+	}
+	return ErrQueueEmpty
+}
+
+func (rb *ringBuf) qty(head, tail uint32) (quantity uint32) {
+	quantity = tail - head // wraps around correctly since both are free-running
+	if quantity > rb.limit {
+		quantity = rb.limit // head was loaded before a concurrent dequeue
+	}
+	return
+}
+
+// Quantity is an alias of Len.
+func (rb *ringBuf) Quantity() uint32 {
+	return rb.Len()
+}
+
+// Size is an alias of Len.
+func (rb *ringBuf) Size() uint32 {
+	return rb.Len()
+}
+
+func (rb *ringBuf) Len() (quantity uint32) {
+	var tail, head uint32
+	head = atomic.LoadUint32(&rb.head)
+	tail = atomic.LoadUint32(&rb.tail)
+	return rb.qty(head, tail)
+}
+
+func (rb *ringBuf) Free() uint32 {
+	return rb.limit - rb.Len()
+}
+
+func (rb *ringBuf) Cap() uint32 {
+	return rb.cap
+}
+
+func (rb *ringBuf) CapReal() uint32 {
+	return rb.limit
+}
+
+func (rb *ringBuf) IsEmpty() (b bool) {
+	var tail, head uint32
+	head = atomic.LoadUint32(&rb.head)
+	tail = atomic.LoadUint32(&rb.tail)
+	b = head == tail
+	return
+}
+
+func (rb *ringBuf) IsFull() (b bool) {
+	var tail, head uint32
+	head = atomic.LoadUint32(&rb.head)
+	tail = atomic.LoadUint32(&rb.tail)
+	b = rb.qty(head, tail) >= rb.limit
+	return
+}
+
+// Reset will clear the whole queue without reallocating the slots.
+//
+// It's unsafe while any producer or consumer is working, so
+// ErrQueueNotEmpty returned if there are items still in the queue,
+// unless force is true.
+//
+// Reset reopens a closed queue, which is allowed only if force is
+// true, ErrClosed returned otherwise.
+func (rb *ringBuf) Reset(force bool) (err error) {
+	if !force && rb.IsClosed() {
+		return ErrClosed
+	}
+	if !force && rb.Size() != 0 {
+		return ErrQueueNotEmpty
+	}
+	for i := 0; i < len(rb.data); i++ {
+		rb.data[i].readWrite = 0 // bit 0: readable, bit 1: writable
+		if rb.initializer == nil || rb.data[i].value == poison {
+			rb.data[i].value = rb.preAlloc(uint32(i))
+		}
+	}
+	atomic.StoreUint32(&rb.head, 0)
+	atomic.StoreUint32(&rb.tail, 0)
+	atomic.StoreUint32(&rb.closed, 0)
+	return
+}
+
+func (rb *ringBuf) Debug(enabled bool) (lastState bool) {
+	lastState = rb.debugMode
+	rb.debugMode = enabled
+	return
+}
+
+// raiseHighWater stores qty into the high-water mark hw if it's
+// higher.
+func raiseHighWater(hw *uint32, qty uint32) {
+	for old := atomic.LoadUint32(hw); qty > old; old = atomic.LoadUint32(hw) {
+		if atomic.CompareAndSwapUint32(hw, old, qty) {
+			return
+		}
+	}
+}
+
+// roundUpToPower2 takes a uint32 positive integer and
+// rounds it up to the next power of 2.
+func roundUpToPower2(v uint32) uint32 {
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v++
+	return v
+}
@@ -0,0 +1,22 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+type poisonMarker struct{ _ byte } // not zero-sized, so its address is unique
+
+// poison is the marker put by CloseAndSignal after the last item.
+// The consumers skip it as an expired item, and find the queue closed
+// and drained behind it, so that ErrClosed is returned.
+var poison interface{} = &poisonMarker{}
+
+// closeAndSignal puts the marker into q, and closes q. The marker is
+// left out if q is full or cloning (an Initializer can't copy it),
+// the consumers stop at ErrClosed once drained in that case too.
+func closeAndSignal(q RingBuffer, cloning bool) (err error) {
+	if !cloning {
+		q.TryEnqueue(poison)
+	}
+	return q.Close()
+}
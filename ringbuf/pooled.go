@@ -0,0 +1,65 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"sync"
+)
+
+// PooledRing is a Ring of *T which recycles the dequeued objects by
+// a sync.Pool, so that a high throughput producer/consumer pair
+// doesn't allocate a new T for each item.
+//
+// A producer takes an object by Acquire, fills and enqueues it. The
+// consumer dequeues the object, and gives it back by Release once
+// it's no longer referenced:
+//
+//	r := ringbuf.NewPooledRing[Packet](1024, nil, func(p *Packet) { p.Data = p.Data[:0] })
+//	p := r.Acquire()
+//	p.Data = append(p.Data, payload...)
+//	_ = r.Enqueue(p)
+//	...
+//	if p, err := r.Dequeue(); err == nil {
+//	    process(p)
+//	    r.Release(p)
+//	}
+type PooledRing[T any] struct {
+	*Ring[*T]
+	pool  sync.Pool
+	reset func(*T)
+}
+
+// NewPooledRing returns a PooledRing object. newFn allocates a new
+// T when the pool is empty, new(T) is used if it's nil. reset clears
+// an object before it's put back to the pool, it's optional.
+func NewPooledRing[T any](capacity uint32, newFn func() *T, reset func(*T), opts ...RingOpt[*T]) *PooledRing[T] {
+	if newFn == nil {
+		newFn = func() *T { return new(T) }
+	}
+	return &PooledRing[T]{
+		Ring:  NewRing[*T](capacity, opts...),
+		pool:  sync.Pool{New: func() interface{} { return newFn() }},
+		reset: reset,
+	}
+}
+
+// Acquire takes an object from the pool.
+func (r *PooledRing[T]) Acquire() *T {
+	return r.pool.Get().(*T)
+}
+
+// Release resets obj and puts it back to the pool.
+func (r *PooledRing[T]) Release(obj *T) {
+	if obj == nil {
+		return
+	}
+	if r.reset != nil {
+		r.reset(obj)
+	}
+	r.pool.Put(obj)
+}
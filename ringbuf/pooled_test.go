@@ -0,0 +1,44 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"testing"
+)
+
+type benchPacket struct {
+	Data [256]byte
+	N    int
+}
+
+// BenchmarkPooledRing compares the recycled objects of PooledRing
+// with a new one for each item, run it with -benchmem.
+func BenchmarkPooledRing(b *testing.B) {
+	b.Run("Ring", func(b *testing.B) {
+		r := NewRing[*benchPacket](1024)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p := new(benchPacket)
+			p.N = i
+			_ = r.Enqueue(p)
+			p, _ = r.Dequeue()
+			_ = p.N
+		}
+	})
+	b.Run("PooledRing", func(b *testing.B) {
+		r := NewPooledRing[benchPacket](1024, nil, func(p *benchPacket) { p.N = 0 })
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p := r.Acquire()
+			p.N = i
+			_ = r.Enqueue(p)
+			p, _ = r.Dequeue()
+			r.Release(p)
+		}
+	})
+}
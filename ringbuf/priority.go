@@ -0,0 +1,376 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// PriorityRingBuffer is a RingBuffer with multiple priority
+	// lanes, see NewPriority.
+	PriorityRingBuffer interface {
+		RingBuffer
+
+		// EnqueuePriority puts item into the given lane, lane 0 is
+		// the highest priority. ErrBadLane returned if lane is out
+		// of range.
+		EnqueuePriority(item interface{}, lane int) (err error)
+		// Lanes returns the count of lanes.
+		Lanes() int
+	}
+
+	priorityRingBuf struct {
+		lanes     []RingBuffer
+		putWaits  uint64
+		getWaits  uint64
+		highWater uint32 // of the total quantity of the lanes
+		spins     int    // see WithSpinsBeforeSleep
+	}
+)
+
+// NewPriority returns a PriorityRingBuffer object which keeps one
+// ring buffer of capacityPerLane for each lane, the opts are applied
+// to every lane.
+//
+// Dequeue always returns the item from the highest priority (the
+// lowest index) non-empty lane, and the items are FIFO within a lane.
+// Enqueue puts item into the lowest priority lane.
+//
+// Note that the lower lanes can be starved as long as the higher
+// ones keep non-empty, so the high priority traffic should be kept
+// sparse (such as the control messages).
+//
+// The lanes are always lock-free, WithBlockingMode and
+// WithLatencyTracking are ignored.
+func NewPriority(capacityPerLane uint32, lanes int, opts ...Opt) PriorityRingBuffer {
+	if lanes < 1 {
+		lanes = 1
+	}
+
+	cfg := &ringBuf{logger: nopLogger{}, spins: -1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cfg.applySpins()
+
+	opts = append(opts, WithBlockingMode(false), WithLatencyTracking(false))
+	rb := &priorityRingBuf{lanes: make([]RingBuffer, lanes), spins: cfg.spins}
+	for i := range rb.lanes {
+		rb.lanes[i] = New(capacityPerLane, opts...)
+	}
+	return rb
+}
+
+func (rb *priorityRingBuf) Lanes() int {
+	return len(rb.lanes)
+}
+
+func (rb *priorityRingBuf) EnqueuePriority(item interface{}, lane int) (err error) {
+	if lane < 0 || lane >= len(rb.lanes) {
+		return ErrBadLane
+	}
+	return rb.enqueued(rb.lanes[lane].Enqueue(item))
+}
+
+func (rb *priorityRingBuf) Put(item interface{}) (err error) {
+	err = rb.Enqueue(item)
+	return
+}
+
+func (rb *priorityRingBuf) Enqueue(item interface{}) (err error) {
+	return rb.enqueued(rb.lanes[len(rb.lanes)-1].Enqueue(item))
+}
+
+func (rb *priorityRingBuf) EnqueueMany(items []interface{}) (n int, err error) {
+	n, err = rb.lanes[len(rb.lanes)-1].EnqueueMany(items)
+	if n > 0 {
+		raiseHighWater(&rb.highWater, rb.Len())
+	}
+	return
+}
+
+// BlockingEnqueueMany puts items into the lowest priority lane as a
+// whole.
+func (rb *priorityRingBuf) BlockingEnqueueMany(ctx context.Context, items []interface{}) (err error) {
+	return rb.enqueued(rb.lanes[len(rb.lanes)-1].BlockingEnqueueMany(ctx, items))
+}
+
+// enqueued raises the high-water mark if an item was enqueued, and
+// passes err through.
+func (rb *priorityRingBuf) enqueued(err error) error {
+	if err == nil {
+		raiseHighWater(&rb.highWater, rb.Len())
+	}
+	return err
+}
+
+func (rb *priorityRingBuf) Get() (item interface{}, err error) {
+	item, err = rb.Dequeue()
+	return
+}
+
+func (rb *priorityRingBuf) TryEnqueue(item interface{}) (ok bool) {
+	return rb.Enqueue(item) == nil
+}
+
+func (rb *priorityRingBuf) EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool) {
+	return enqueueOrDrop(rb, item, onDrop)
+}
+
+func (rb *priorityRingBuf) TryDequeue() (item interface{}, ok bool) {
+	item, err := rb.Dequeue()
+	return item, err == nil
+}
+
+// EnqueueFrom puts the item built by fn into the lowest priority
+// lane.
+func (rb *priorityRingBuf) EnqueueFrom(fn func() interface{}) (err error) {
+	return rb.enqueued(rb.lanes[len(rb.lanes)-1].EnqueueFrom(fn))
+}
+
+func (rb *priorityRingBuf) DequeueInto(fn func(item interface{})) (err error) {
+	return dequeueInto(rb, fn)
+}
+
+func (rb *priorityRingBuf) Dequeue() (item interface{}, err error) {
+	closed := 0
+	for _, lane := range rb.lanes {
+		if item, err = lane.Dequeue(); err == nil {
+			return
+		} else if err == ErrClosed {
+			closed++
+		} else if err != ErrQueueEmpty {
+			return
+		}
+	}
+
+	err = ErrQueueEmpty
+	if closed == len(rb.lanes) {
+		err = ErrClosed
+	}
+	return
+}
+
+func (rb *priorityRingBuf) DequeueMany(dst []interface{}) (n int, err error) {
+	if len(dst) == 0 {
+		return
+	}
+
+	closed := 0
+	for _, lane := range rb.lanes {
+		c, e := lane.DequeueMany(dst[n:])
+		n += c
+		if e == ErrClosed {
+			closed++
+		}
+		if n == len(dst) {
+			return
+		}
+	}
+
+	if n == 0 {
+		err = ErrQueueEmpty
+		if closed == len(rb.lanes) {
+			err = ErrClosed
+		}
+	}
+	return
+}
+
+func (rb *priorityRingBuf) Drain() (items []interface{}) {
+	for _, lane := range rb.lanes {
+		items = append(items, lane.Drain()...)
+	}
+	return
+}
+
+func (rb *priorityRingBuf) BlockingEnqueue(ctx context.Context, item interface{}) (err error) {
+	return blockingEnqueue(ctx, rb, &rb.putWaits, rb.spins, item)
+}
+
+func (rb *priorityRingBuf) BlockingDequeue(ctx context.Context) (item interface{}, err error) {
+	return blockingDequeue(ctx, rb, &rb.getWaits, rb.spins)
+}
+
+func (rb *priorityRingBuf) PutTimeout(item interface{}, d time.Duration) (err error) {
+	return putTimeout(rb, item, d)
+}
+
+func (rb *priorityRingBuf) GetTimeout(d time.Duration) (item interface{}, err error) {
+	return getTimeout(rb, d)
+}
+
+func (rb *priorityRingBuf) Peek() (item interface{}, err error) {
+	for _, lane := range rb.lanes {
+		if item, err = lane.Peek(); err != ErrQueueEmpty && err != ErrClosed {
+			return
+		}
+	}
+	return
+}
+
+func (rb *priorityRingBuf) ForEach(fn func(index int, item interface{}) bool) {
+	base, stopped := 0, false
+	for _, lane := range rb.lanes {
+		count := 0
+		lane.ForEach(func(index int, item interface{}) bool {
+			count = index + 1
+			if !fn(base+index, item) {
+				stopped = true
+			}
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+		base += count
+	}
+}
+
+func (rb *priorityRingBuf) GetGetWaits() uint64 {
+	return atomic.LoadUint64(&rb.getWaits)
+}
+
+func (rb *priorityRingBuf) GetPutWaits() uint64 {
+	return atomic.LoadUint64(&rb.putWaits)
+}
+
+func (rb *priorityRingBuf) ExpiredCount() (count uint64) {
+	for _, lane := range rb.lanes {
+		count += lane.ExpiredCount()
+	}
+	return
+}
+
+func (rb *priorityRingBuf) ResetCounters() {
+	atomic.StoreUint64(&rb.getWaits, 0)
+	atomic.StoreUint64(&rb.putWaits, 0)
+	atomic.StoreUint32(&rb.highWater, 0)
+	for _, lane := range rb.lanes {
+		lane.ResetCounters()
+	}
+}
+
+// HighWaterMark returns the maximum total quantity of all the lanes
+// observed by the successful enqueues.
+func (rb *priorityRingBuf) HighWaterMark() uint32 {
+	return atomic.LoadUint32(&rb.highWater)
+}
+
+// Close is CloseWrite, for io.Closer.
+func (rb *priorityRingBuf) Close() (err error) {
+	return rb.CloseWrite()
+}
+
+func (rb *priorityRingBuf) CloseWrite() (err error) {
+	for _, lane := range rb.lanes {
+		if e := lane.CloseWrite(); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// CloseAndSignal puts the marker into every lane, so that ErrClosed
+// is returned after the items of all the lanes have been delivered.
+func (rb *priorityRingBuf) CloseAndSignal() (err error) {
+	for _, lane := range rb.lanes {
+		if e := lane.CloseAndSignal(); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (rb *priorityRingBuf) IsClosed() bool {
+	return rb.lanes[0].IsClosed()
+}
+
+// Quantity is an alias of Len.
+func (rb *priorityRingBuf) Quantity() uint32 {
+	return rb.Len()
+}
+
+// Size is an alias of Len.
+func (rb *priorityRingBuf) Size() uint32 {
+	return rb.Len()
+}
+
+// Len returns the total quantity of items in all the lanes.
+func (rb *priorityRingBuf) Len() (quantity uint32) {
+	for _, lane := range rb.lanes {
+		quantity += lane.Len()
+	}
+	return
+}
+
+// Free returns the total free slots of all the lanes.
+func (rb *priorityRingBuf) Free() (c uint32) {
+	for _, lane := range rb.lanes {
+		c += lane.Free()
+	}
+	return
+}
+
+// Cap returns the total capacity of all the lanes.
+func (rb *priorityRingBuf) Cap() (c uint32) {
+	for _, lane := range rb.lanes {
+		c += lane.Cap()
+	}
+	return
+}
+
+func (rb *priorityRingBuf) CapReal() (c uint32) {
+	for _, lane := range rb.lanes {
+		c += lane.CapReal()
+	}
+	return
+}
+
+func (rb *priorityRingBuf) IsEmpty() (b bool) {
+	for _, lane := range rb.lanes {
+		if !lane.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsFull reports whether all the lanes are full.
+func (rb *priorityRingBuf) IsFull() (b bool) {
+	for _, lane := range rb.lanes {
+		if !lane.IsFull() {
+			return false
+		}
+	}
+	return true
+}
+
+func (rb *priorityRingBuf) Reset(force bool) (err error) {
+	if !force && rb.IsClosed() {
+		return ErrClosed
+	}
+	if !force && rb.Size() != 0 {
+		return ErrQueueNotEmpty
+	}
+	for _, lane := range rb.lanes {
+		if e := lane.Reset(true); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (rb *priorityRingBuf) Debug(enabled bool) (lastState bool) {
+	for i, lane := range rb.lanes {
+		if last := lane.Debug(enabled); i == 0 {
+			lastState = last
+		}
+	}
+	return
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type (
+	// Queue interface provides a set of standard queue operations
+	Queue interface {
+		// Enqueue puts item at the tail, it's dequeued after all
+		// the items enqueued before, see Ordering in the package
+		// doc.
+		Enqueue(item interface{}) (err error)
+		// Dequeue takes the item at the head, the earliest one.
+		Dequeue() (item interface{}, err error)
+		// Cap returns the outer capacity of the ring buffer.
+		Cap() uint32
+		// CapReal returns the real (inner) capacity of the ring buffer.
+		CapReal() uint32
+		// Len returns the quantity of items in the ring buffer
+		// queue. It's the canonical name, Size and Quantity are
+		// the aliases of it, none of them means the capacity.
+		Len() uint32
+		// Size is an alias of Len.
+		Size() uint32
+		IsEmpty() (b bool)
+		IsFull() (b bool)
+		// Reset empties the queue and reuses the allocated slots.
+		// It must not be called while any producer or consumer is
+		// working; ErrQueueNotEmpty returned if there are items
+		// left in the queue, unless force is true. A closed queue
+		// is reopened by Reset only if force is true, ErrClosed
+		// returned otherwise.
+		Reset(force bool) (err error)
+	}
+
+	// RingBuffer interface provides a set of standard ring buffer operations
+	RingBuffer interface {
+		// Close is CloseWrite, for io.Closer.
+		io.Closer
+		// IsClosed reports whether the queue has been closed.
+		IsClosed() bool
+		// CloseWrite closes the producer side, for a producer
+		// finishing the stream while the consumers catch up:
+		// Enqueue fails with ErrClosed from now on, and Dequeue
+		// returns ErrClosed once the remained items drained.
+		// IsClosed reports true since then. There is no teardown
+		// dropping the items, Drain them if needed. An Enqueue
+		// racing with it either fails with ErrClosed, or its item
+		// is dequeued before ErrClosed; in SPSC mode, only if it's
+		// called from the producer goroutine.
+		CloseWrite() (err error)
+		// CloseAndSignal closes the queue as Close, and puts an
+		// internal marker after all the items enqueued so far. A
+		// consumer reaching the marker gets ErrClosed, as any
+		// other consumer does since then, so that the consumer
+		// loops terminate in order and none of them hangs:
+		//
+		//	for {
+		//		item, err := q.BlockingDequeue(ctx)
+		//		if err == ringbuf.ErrClosed {
+		//			return // all the items before have been delivered
+		//		}
+		//		...
+		//	}
+		//
+		// The marker takes a slot, it's left out if the queue is
+		// full or WithItemInitializer is set, the consumers still
+		// get ErrClosed once drained. In SPSC mode it must be
+		// called from the producer goroutine.
+		CloseAndSignal() (err error)
+
+		Queue
+
+		Put(item interface{}) (err error)
+		Get() (item interface{}, err error)
+
+		// TryEnqueue puts item if a free slot is available at
+		// once, and reports whether it did, false for a full or
+		// closed queue. It never waits, even in WithBlockingMode.
+		TryEnqueue(item interface{}) (ok bool)
+		// EnqueueOrDrop puts item as TryEnqueue, or passes it to
+		// onDrop if the queue is full or closed, so that the lossy
+		// producers can count or log the drops in one call. It
+		// never waits, and reports whether item was enqueued.
+		// onDrop may be nil.
+		EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool)
+		// TryDequeue takes the head item if there is one at once,
+		// and reports whether it did, false for an empty queue.
+		// It never waits, even in WithBlockingMode.
+		TryDequeue() (item interface{}, ok bool)
+
+		// EnqueueFrom reserves a slot first, and calls fn to build
+		// the item only if it got one, so that the construction is
+		// skipped if the queue is full or closed. fn runs while
+		// the slot is held, it should be quick and must not panic,
+		// or the consumers of the slot would wait forever.
+		EnqueueFrom(fn func() interface{}) (err error)
+		// DequeueInto dequeues an item as Dequeue, and passes it
+		// to fn inline, so that the caller can type-assert it in
+		// one place. fn isn't called on error.
+		DequeueInto(fn func(item interface{})) (err error)
+
+		// EnqueueMany puts as many items as possible in one shot.
+		// It returns the count of items written, and ErrQueueFull
+		// if the buffer filled partway through.
+		EnqueueMany(items []interface{}) (n int, err error)
+		// BlockingEnqueueMany waits until there are free slots for
+		// all the items, and puts them as a contiguous run, so that
+		// a batch is never applied partially nor interleaved with
+		// the items of the other producers. ErrBatchTooLarge
+		// returned at once if len(items) > CapReal(), and
+		// ctx.Err() if ctx is done before the batch fits.
+		BlockingEnqueueMany(ctx context.Context, items []interface{}) (err error)
+		// DequeueMany drains up to len(dst) items into dst. It
+		// returns ErrQueueEmpty only if nothing could be read.
+		DequeueMany(dst []interface{}) (n int, err error)
+		// Drain removes and returns all the items enqueued at
+		// the moment, in FIFO order.
+		Drain() (items []interface{})
+
+		// BlockingEnqueue waits until a free slot is available or
+		// ctx is done. In the latter case ctx.Err() returned.
+		BlockingEnqueue(ctx context.Context, item interface{}) (err error)
+		// BlockingDequeue waits until an item is available or
+		// ctx is done. In the latter case ctx.Err() returned.
+		BlockingDequeue(ctx context.Context) (item interface{}, err error)
+
+		// PutTimeout enqueues item, waits at most d for a free slot.
+		// A zero d means trying once, a negative d means waiting
+		// forever. ErrTimeout returned if d elapsed.
+		PutTimeout(item interface{}, d time.Duration) (err error)
+		// GetTimeout dequeues an item, waits at most d for it.
+		// A zero d means trying once, a negative d means waiting
+		// forever. ErrTimeout returned if d elapsed.
+		GetTimeout(d time.Duration) (item interface{}, err error)
+
+		// Peek returns the head item without dequeuing it.
+		//
+		// In a multiple-consumers scene, the result may become
+		// stale immediately since another consumer can dequeue it
+		// at any time. Peek is reliable for single-consumer usage.
+		Peek() (item interface{}, err error)
+		// ForEach walks the pending items from head to tail without
+		// dequeuing them, until fn returns false. It's a best-effort
+		// snapshot and may be torn in a multiple-producers or
+		// multiple-consumers scene.
+		ForEach(fn func(index int, item interface{}) bool)
+
+		// Quantity is an alias of Len.
+		Quantity() uint32
+		// Free returns the count of the free slots, that is
+		// CapReal() - Len().
+		Free() uint32
+		// HighWaterMark returns the maximum Len observed by the
+		// successful enqueues since created or ResetCounters, to
+		// tell how close to full the queue got under load.
+		HighWaterMark() uint32
+
+		// ExpiredCount returns how many items have been discarded
+		// for exceeding the TTL, see WithItemTTL.
+		ExpiredCount() uint64
+
+		Debug(enabled bool) (lastState bool)
+
+		ResetCounters()
+	}
+
+	// Dbg exposes some internal fields for debugging
+	Dbg interface {
+		GetGetWaits() uint64
+		GetPutWaits() uint64
+		Debug(enabled bool) (lastState bool)
+		ResetCounters()
+		// Dump returns a human-readable snapshot of the indices
+		// and the slot states, to diagnose ErrRaced and
+		// ErrCorrupted. It's empty unless Debug(true), so it
+		// costs nothing in production.
+		Dump() string
+	}
+)
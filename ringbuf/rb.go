@@ -0,0 +1,519 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"fmt"
+	"gopkg.in/hedzr/errors.v2"
+	"sync/atomic"
+	"time"
+)
+
+type (
+
+	// ringBuf implements a circular buffer. It is a fixed size,
+	// and new writes will be blocked when queue is full.
+	//
+	// head and tail are free-running counters, the slot index is
+	// taken by masking them with capModMask. So a stale load of
+	// them cannot pass a CAS after a full lap (ABA).
+	//
+	// limit is the count of usable slots, it is Cap()-1 in both
+	// the power-of-2 mode and the exact capacity mode.
+	//
+	// head and tail are loaded one by one, head first, and each is
+	// advanced by its own CAS which validates the value loaded. No
+	// torn read can misjudge the fullness: head only moves forward,
+	// so a stale head overestimates the quantity, in which case a
+	// producer may see a full queue spuriously but never overwrites
+	// a live slot; and a consumer finding head == tail has seen an
+	// empty queue at the moment tail was loaded. A slot reserved but
+	// not filled yet is guarded by readWrite, the consumer waits for
+	// it in take.
+	ringBuf struct {
+		cap          uint32
+		capModMask   uint32
+		limit        uint32
+		_            [CacheLinePadSize - 12]byte
+		head         uint32
+		_            [CacheLinePadSize - 4]byte
+		tail         uint32
+		_            [CacheLinePadSize - 4]byte
+		putWaits     uint64
+		_            [CacheLinePadSize - 8]byte
+		getWaits     uint64
+		_            [CacheLinePadSize - 8]byte
+		expired      uint64
+		_            [CacheLinePadSize - 8]byte
+		highWater    uint32
+		_            [CacheLinePadSize - 4]byte
+		data         []rbItem
+		exactCap     bool
+		blockingMode bool
+		closed       uint32
+		debugMode    bool
+		logger       Logger
+		initializer  Initializeable
+		waiter       WaitStrategy
+		ttl          time.Duration
+		spins        int // see WithSpinsBeforeSleep, -1 until applySpins
+		alloc        func(n uint32) []rbItem
+		fair         bool
+		signaling    bool
+		latency      bool
+	}
+
+	// Slot is a slot of the lock-free ring buffer, it's exported for
+	// WithBackingStore only, the fields are internal.
+	Slot = rbItem
+
+	rbItem struct {
+		readWrite uint64      // 0: writable, 1: readable, 2: write ok, 3: read ok
+		value     interface{} // ptr
+		ts        int64       // enqueued time in unix nano, for WithItemTTL
+		_         [CacheLinePadSize - rbItemPayload%CacheLinePadSize]byte
+	}
+)
+
+func (rb *ringBuf) Put(item interface{}) (err error) {
+	err = rb.Enqueue(item)
+	return
+}
+
+func (rb *ringBuf) Enqueue(item interface{}) (err error) {
+	var tail, head uint32
+	if tail, head, err = rb.reserveTail(); err != nil {
+		return
+	}
+	err = rb.fill(tail, item)
+	rb.enqueued(tail, head, item)
+	return
+}
+
+// EnqueueFrom reserves a slot, and fills it with the item built by
+// fn then.
+func (rb *ringBuf) EnqueueFrom(fn func() interface{}) (err error) {
+	var tail, head uint32
+	if tail, head, err = rb.reserveTail(); err != nil {
+		return
+	}
+	item := fn()
+	err = rb.fill(tail, item)
+	rb.enqueued(tail, head, item)
+	return
+}
+
+// reserveTail advances the tail by one, the slot at the old tail is
+// owned by the caller, which must fill it.
+func (rb *ringBuf) reserveTail() (tail, head uint32, err error) {
+	if rb.IsClosed() {
+		err = ErrClosed
+		return
+	}
+	for retry := 0; ; retry++ {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if rb.qty(head, tail) >= rb.limit {
+			err = ErrQueueFull
+			return
+		}
+
+		// the slot is owned by whom advanced the tail, so that a
+		// batch reservation (see EnqueueMany) cannot be interleaved
+		// by another producer.
+		if atomic.CompareAndSwapUint32(&rb.tail, tail, tail+1) {
+			err = rb.backOutIfClosed(tail, 1)
+			return
+		}
+		rb.waiter.Wait(retry) // time to time
+	}
+}
+
+// backOutIfClosed checks the closed flag again once the run of count
+// slots from tail reserved. If the queue has been closed meanwhile,
+// a consumer may have found it closed and drained already, so the
+// slots are filled with the poison marker to be skipped, and
+// ErrClosed returned.
+func (rb *ringBuf) backOutIfClosed(tail, count uint32) (err error) {
+	if !rb.IsClosed() {
+		return
+	}
+	for i := uint32(0); i < count; i++ {
+		_ = rb.fill(tail+i, poison)
+	}
+	return ErrClosed
+}
+
+func (rb *ringBuf) enqueued(tail, head uint32, item interface{}) {
+	raiseHighWater(&rb.highWater, rb.qty(atomic.LoadUint32(&rb.head), tail+1))
+	if rb.debugMode {
+		rb.logger.Debugf("[W] tail %v => %v, head: %v | ENQUEUED value = %v | [0]=%v, [1]=%v",
+			tail, tail+1, head, toString(item), toString(rb.data[0].value), toString(rb.data[1].value))
+	}
+}
+
+// EnqueueMany reserves a contiguous run of slots by advancing the
+// tail once, and fills them with items.
+//
+// If the free slots are fewer than len(items), the run is shrunk
+// and ErrQueueFull returned with the count of items written.
+func (rb *ringBuf) EnqueueMany(items []interface{}) (n int, err error) {
+	var tail, head, count uint32
+	if len(items) == 0 {
+		return
+	}
+	if tail, head, count, err = rb.reserveRun(uint32(len(items)), false); err != nil {
+		return
+	}
+	err = rb.fillRun(tail, head, items[:count])
+	n = int(count)
+	if err == nil && n < len(items) {
+		err = ErrQueueFull
+	}
+	return
+}
+
+func (rb *ringBuf) enqueueAll(items []interface{}) (err error) {
+	var tail, head uint32
+	if uint32(len(items)) > rb.limit {
+		return ErrBatchTooLarge
+	}
+	if len(items) == 0 {
+		return
+	}
+	if tail, head, _, err = rb.reserveRun(uint32(len(items)), true); err != nil {
+		return
+	}
+	return rb.fillRun(tail, head, items)
+}
+
+// reserveRun advances the tail by up to most slots, or exactly most
+// ones if all is set, and returns the old tail and the count of the
+// slots reserved. ErrQueueFull returned if nothing can be reserved.
+func (rb *ringBuf) reserveRun(most uint32, all bool) (tail, head, count uint32, err error) {
+	var qty uint32
+	if rb.IsClosed() {
+		err = ErrClosed
+		return
+	}
+	for retry := 0; ; retry++ {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if qty = rb.qty(head, tail); qty >= rb.limit || (all && rb.limit-qty < most) {
+			err = ErrQueueFull
+			return
+		}
+		count = rb.limit - qty
+		if most < count {
+			count = most
+		}
+
+		if atomic.CompareAndSwapUint32(&rb.tail, tail, tail+count) {
+			err = rb.backOutIfClosed(tail, count)
+			return
+		}
+		rb.waiter.Wait(retry) // time to time
+	}
+}
+
+// fillRun fills the run of slots reserved from tail with items.
+func (rb *ringBuf) fillRun(tail, head uint32, items []interface{}) (err error) {
+	count := uint32(len(items))
+	for i := uint32(0); i < count; i++ {
+		// the run may straddle the end of data, fill() wraps it
+		if e := rb.fill(tail+i, items[i]); e != nil {
+			err = e
+		}
+	}
+
+	raiseHighWater(&rb.highWater, rb.qty(atomic.LoadUint32(&rb.head), tail+count))
+	if rb.debugMode {
+		rb.logger.Debugf("[W] tail %v => %v, head: %v | ENQUEUED %v items", tail, tail+count, head, count)
+	}
+	return
+}
+
+// fill writes item into the slot which has been reserved by the caller.
+func (rb *ringBuf) fill(pos uint32, item interface{}) (err error) {
+	holder := &rb.data[pos&rb.capModMask]
+	for retry := 0; !atomic.CompareAndSwapUint64(&holder.readWrite, 0, 2); retry++ {
+		rb.waiter.Wait(retry) // a slow consumer is still reading the previous lap
+	}
+
+	if rb.initializer != nil && item != poison {
+		rb.initializer.CloneIn(item, holder.value)
+	} else {
+		holder.value = item
+	}
+	if rb.ttl > 0 {
+		holder.ts = time.Now().UnixNano()
+	}
+	if !atomic.CompareAndSwapUint64(&holder.readWrite, 2, 1) {
+		err = ErrRaced // runtime.Gosched() // never happens
+	}
+	return
+}
+
+func (rb *ringBuf) Get() (item interface{}, err error) {
+	item, err = rb.Dequeue()
+	return
+}
+
+func (rb *ringBuf) TryEnqueue(item interface{}) (ok bool) {
+	return rb.Enqueue(item) == nil
+}
+
+func (rb *ringBuf) EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool) {
+	return enqueueOrDrop(rb, item, onDrop)
+}
+
+// enqueueOrDrop implements RingBuffer.EnqueueOrDrop on TryEnqueue
+// of q.
+func enqueueOrDrop(q RingBuffer, item interface{}, onDrop func(item interface{})) (ok bool) {
+	if ok = q.TryEnqueue(item); !ok && onDrop != nil {
+		onDrop(item)
+	}
+	return
+}
+
+func (rb *ringBuf) TryDequeue() (item interface{}, ok bool) {
+	item, err := rb.Dequeue()
+	return item, err == nil
+}
+
+func (rb *ringBuf) DequeueInto(fn func(item interface{})) (err error) {
+	return dequeueInto(rb, fn)
+}
+
+// dequeueInto passes the item dequeued from q to fn.
+func dequeueInto(q Queue, fn func(item interface{})) (err error) {
+	var item interface{}
+	if item, err = q.Dequeue(); err == nil {
+		fn(item)
+	}
+	return
+}
+
+func (rb *ringBuf) Dequeue() (item interface{}, err error) {
+	var tail, head uint32
+	for retry := 0; ; retry++ {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if head == tail {
+			err = rb.emptyOrClosed(tail)
+			return
+		}
+
+		if !atomic.CompareAndSwapUint32(&rb.head, head, head+1) {
+			rb.waiter.Wait(retry) // time to time
+			continue
+		}
+
+		var expired bool
+		if item, expired, err = rb.take(head); expired {
+			continue // discard it, and try the next one
+		}
+
+		if rb.debugMode {
+			rb.logger.Debugf("[ringbuf][GET] cap=%v, qty=%v, tail=%v, head=%v, new head=%v, item=%v", rb.Cap(), rb.qty(head, tail), tail, head, head+1, toString(item))
+		}
+
+		if item == nil {
+			err = errors.Wrap(ErrCorrupted, "[ringbuf][GET] cap: %v, qty: %v, head: %v, tail: %v, new head: %v", rb.cap, rb.qty(head, tail), head, tail, head+1)
+		}
+		return
+	}
+}
+
+// DequeueMany reserves a contiguous run of slots by advancing the
+// head once, and drains them into dst.
+//
+// ErrQueueEmpty is returned only if the queue is empty at entry, a
+// partial fill returns the count of items read and a nil error.
+func (rb *ringBuf) DequeueMany(dst []interface{}) (n int, err error) {
+	var tail, head, count uint32
+	if len(dst) == 0 {
+		return
+	}
+	for retry := 0; ; retry++ {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if head == tail {
+			err = rb.emptyOrClosed(tail)
+			return
+		}
+		count = rb.qty(head, tail)
+		if uint32(len(dst)) < count {
+			count = uint32(len(dst))
+		}
+
+		if atomic.CompareAndSwapUint32(&rb.head, head, head+count) {
+			break
+		}
+		rb.waiter.Wait(retry) // time to time
+	}
+
+	for i := uint32(0); i < count; i++ {
+		// the run may straddle the end of data, take() wraps it
+		item, expired, e := rb.take(head + i)
+		if e != nil {
+			err = e
+		}
+		if !expired {
+			dst[n] = item
+			n++
+		}
+	}
+
+	if rb.debugMode {
+		rb.logger.Debugf("[ringbuf][GET] head %v => %v, tail: %v | DEQUEUED %v items", head, head+count, tail, n)
+	}
+	if n == 0 && err == nil {
+		return rb.DequeueMany(dst) // the whole run expired
+	}
+	return
+}
+
+// Drain removes all the items enqueued at the moment, and returns
+// them in FIFO order. The items enqueued after the snapshot are
+// left in the buffer.
+func (rb *ringBuf) Drain() (items []interface{}) {
+	var tail, head uint32
+	for retry := 0; ; retry++ {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if head == tail {
+			return
+		}
+
+		if atomic.CompareAndSwapUint32(&rb.head, head, tail) {
+			break
+		}
+		rb.waiter.Wait(retry) // time to time
+	}
+
+	items = make([]interface{}, 0, tail-head)
+	for pos := head; pos != tail; pos++ {
+		if item, expired, err := rb.take(pos); err == nil && !expired {
+			items = append(items, item)
+		}
+	}
+
+	if rb.debugMode {
+		rb.logger.Debugf("[ringbuf][GET] head %v => %v | DRAINED %v items", head, tail, len(items))
+	}
+	return
+}
+
+// ForEach walks the items from head to tail without dequeuing
+// them, and stops as soon as fn returns false.
+//
+// It's a best-effort view: in the MPMC mode the items may be
+// dequeued or overwritten while walking, the slots which are not
+// readable at the moment are skipped. So the result is possibly a
+// torn snapshot.
+func (rb *ringBuf) ForEach(fn func(index int, item interface{}) bool) {
+	head := atomic.LoadUint32(&rb.head)
+	tail := atomic.LoadUint32(&rb.tail)
+	count := rb.qty(head, tail)
+	for i := uint32(0); i < count; i++ {
+		holder := &rb.data[(head+i)&rb.capModMask]
+		if atomic.LoadUint64(&holder.readWrite) != 1 || holder.value == poison {
+			continue
+		}
+		if !fn(int(i), holder.value) {
+			return
+		}
+	}
+}
+
+// take reads the item out of the slot which has been reserved by
+// the caller. An item older than the TTL is discarded and counted,
+// expired returned as true.
+func (rb *ringBuf) take(pos uint32) (item interface{}, expired bool, err error) {
+	holder := &rb.data[pos&rb.capModMask]
+	for retry := 0; !atomic.CompareAndSwapUint64(&holder.readWrite, 1, 3); retry++ {
+		rb.waiter.Wait(retry) // the producer is still writing this slot
+	}
+
+	switch {
+	case holder.value == poison:
+		item = poison
+		holder.value = rb.preAlloc(pos)
+	case rb.initializer != nil:
+		item = rb.initializer.CloneOut(holder.value)
+	default:
+		item = holder.value
+		holder.value = 0
+	}
+	if item == poison {
+		expired, item = true, nil // see CloseAndSignal
+	} else if rb.ttl > 0 && time.Now().UnixNano()-holder.ts > int64(rb.ttl) {
+		expired, item = true, nil
+		atomic.AddUint64(&rb.expired, 1)
+	}
+	if !atomic.CompareAndSwapUint64(&holder.readWrite, 3, 0) {
+		err = ErrRaced // runtime.Gosched() // never happens
+	}
+	return
+}
+
+// preAlloc returns the empty value of the slot at pos, the block of
+// the Initializer if any.
+func (rb *ringBuf) preAlloc(pos uint32) interface{} {
+	if rb.initializer != nil {
+		return rb.initializer.PreAlloc(int(pos & rb.capModMask))
+	}
+	return nil
+}
+
+func (rb *ringBuf) Peek() (item interface{}, err error) {
+	var tail, head uint32
+	var holder *rbItem
+	for retry := 0; ; retry++ {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+
+		if head == tail {
+			err = rb.emptyOrClosed(tail)
+			return
+		}
+
+		holder = &rb.data[head&rb.capModMask]
+		if atomic.LoadUint64(&holder.readWrite) != 1 {
+			rb.waiter.Wait(retry) // the producer is still writing, or a consumer is reading
+			continue
+		}
+
+		item = holder.value
+
+		// make sure the slot wasn't taken while we were reading it
+		if atomic.LoadUint32(&rb.head) != head || atomic.LoadUint64(&holder.readWrite) != 1 {
+			continue
+		}
+		if item == poison {
+			item, err = nil, ErrClosed
+		}
+		return
+	}
+}
+
+func toString(i interface{}) (sz string) {
+	if s, ok := i.(string); ok {
+		sz = s
+	} else if s, ok := i.([]byte); ok {
+		sz = string(s)
+	} else if s, ok := i.(*rbItem); ok {
+		sz = toString(s.value)
+	} else {
+		sz = fmt.Sprintf("%v", i)
+	}
+	return
+}
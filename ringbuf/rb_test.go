@@ -0,0 +1,467 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// kinds are the constructors of the queue types behind New.
+var kinds = []struct {
+	name string
+	new  func(capacity uint32) RingBuffer
+}{
+	{"lock-free", func(c uint32) RingBuffer { return New(c) }},
+	{"fair", func(c uint32) RingBuffer { return New(c, WithFairScheduling(true)) }},
+	{"spsc", func(c uint32) RingBuffer { return NewSPSC(c) }},
+	{"blocking", func(c uint32) RingBuffer { return New(c, WithBlockingMode(true)) }},
+	{"signaling", func(c uint32) RingBuffer { return New(c, WithSignaling(true)) }},
+	{"priority", func(c uint32) RingBuffer { return NewPriority(c, 2) }},
+}
+
+func TestResetClosed(t *testing.T) {
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			_ = q.Close()
+			if err := q.Reset(false); !errors.Is(err, ErrClosed) {
+				t.Fatalf("Reset(false) of a closed queue: %v, want ErrClosed", err)
+			}
+			if !q.IsClosed() {
+				t.Fatal("Reset(false) reopened the queue")
+			}
+			if err := q.Enqueue(1); !errors.Is(err, ErrClosed) {
+				t.Fatalf("Enqueue after Reset(false): %v, want ErrClosed", err)
+			}
+
+			if err := q.Reset(true); err != nil {
+				t.Fatalf("Reset(true): %v", err)
+			}
+			if q.IsClosed() {
+				t.Fatal("Reset(true) didn't reopen the queue")
+			}
+			if err := q.Enqueue(1); err != nil {
+				t.Fatalf("Enqueue after Reset(true): %v", err)
+			}
+			if err := q.Reset(false); !errors.Is(err, ErrQueueNotEmpty) {
+				t.Fatalf("Reset(false) of a non-empty queue: %v, want ErrQueueNotEmpty", err)
+			}
+		})
+	}
+}
+
+func TestCloseWriteDrains(t *testing.T) {
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			for i := 0; i < 3; i++ {
+				if err := q.Enqueue(i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := q.CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Enqueue(3); !errors.Is(err, ErrClosed) {
+				t.Fatalf("Enqueue after CloseWrite: %v, want ErrClosed", err)
+			}
+			for i := 0; i < 3; i++ {
+				if item, err := q.Dequeue(); err != nil || item != i {
+					t.Fatalf("Dequeue #%d after CloseWrite: %v, %v", i, item, err)
+				}
+			}
+			if _, err := q.Dequeue(); !errors.Is(err, ErrClosed) {
+				t.Fatalf("Dequeue of a drained queue: %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+// TestCloseWhileLogging closes the queue while the producers and the
+// consumers are logging, for -race.
+func TestCloseWhileLogging(t *testing.T) {
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			q.Debug(true)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; ; i++ {
+					if err := q.Enqueue(i); errors.Is(err, ErrClosed) {
+						return
+					}
+					_, _ = q.Dequeue()
+				}
+			}()
+			_ = q.Close()
+			<-done
+		})
+	}
+}
+
+func TestPeek(t *testing.T) {
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			if _, err := q.Peek(); !errors.Is(err, ErrQueueEmpty) {
+				t.Fatalf("Peek of an empty queue: %v, want ErrQueueEmpty", err)
+			}
+			_, _ = q.Enqueue("first"), q.Enqueue("second")
+			for i := 0; i < 2; i++ {
+				if item, err := q.Peek(); err != nil || item != "first" {
+					t.Fatalf("Peek #%d: %v, %v, want the head", i, item, err)
+				}
+			}
+			if l := q.Len(); l != 2 {
+				t.Fatalf("Len after Peek: %v, want 2", l)
+			}
+			if item, err := q.Dequeue(); err != nil || item != "first" {
+				t.Fatalf("Dequeue after Peek: %v, %v", item, err)
+			}
+			if item, err := q.Peek(); err != nil || item != "second" {
+				t.Fatalf("Peek of the new head: %v, %v", item, err)
+			}
+			_, _ = q.Dequeue()
+			_ = q.CloseWrite()
+			if _, err := q.Peek(); !errors.Is(err, ErrClosed) {
+				t.Fatalf("Peek of a closed empty queue: %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			if items := q.Drain(); len(items) != 0 {
+				t.Fatalf("Drain of an empty queue: %v", items)
+			}
+			for i := 0; i < 5; i++ {
+				_ = q.Enqueue(i)
+			}
+			_ = q.CloseWrite()
+			items := q.Drain()
+			if len(items) != 5 {
+				t.Fatalf("Drain: %v, want 5 items", items)
+			}
+			for i, item := range items {
+				if item != i {
+					t.Fatalf("Drain: %v, want FIFO order", items)
+				}
+			}
+			if l := q.Len(); l != 0 {
+				t.Fatalf("Len after Drain: %v", l)
+			}
+			if _, err := q.Dequeue(); !errors.Is(err, ErrClosed) {
+				t.Fatalf("Dequeue after Drain: %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+// TestDrainConcurrent drains while a producer is enqueuing, every item
+// must be drained exactly once and in order.
+func TestDrainConcurrent(t *testing.T) {
+	const n = 5000
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(16)
+			go func() {
+				for i := 0; i < n; {
+					if err := q.Enqueue(i); err == nil {
+						i++
+					} else {
+						runtime.Gosched()
+					}
+				}
+				_ = q.CloseWrite()
+			}()
+			next := 0
+			for !q.IsClosed() || q.Len() > 0 {
+				for _, item := range q.Drain() {
+					if item != next {
+						t.Fatalf("drained %v, want %v", item, next)
+					}
+					next++
+				}
+				runtime.Gosched()
+			}
+			if next != n {
+				t.Fatalf("drained %v items, want %v", next, n)
+			}
+		})
+	}
+}
+
+func TestDequeueNilItem(t *testing.T) {
+	for _, k := range kinds {
+		if k.name == "blocking" {
+			continue // the slots have no state to be corrupted
+		}
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			if err := q.Enqueue(nil); err != nil {
+				t.Fatal(err)
+			}
+			_ = q.Enqueue("next")
+			if item, err := q.Dequeue(); !errors.Is(err, ErrCorrupted) {
+				t.Fatalf("Dequeue of a nil item: %v, %v, want ErrCorrupted", item, err)
+			}
+			if item, err := q.Dequeue(); err != nil || item != "next" {
+				t.Fatalf("Dequeue after the corrupted slot: %v, %v", item, err)
+			}
+			if err := q.Enqueue("again"); err != nil {
+				t.Fatalf("Enqueue after the corrupted slot: %v", err)
+			}
+			if item, err := q.Dequeue(); err != nil || item != "again" {
+				t.Fatalf("Dequeue: %v, %v", item, err)
+			}
+		})
+	}
+}
+
+func TestLenAliases(t *testing.T) {
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			for i := 0; i < 3; i++ {
+				_ = q.Enqueue(i)
+			}
+			_, _ = q.Dequeue()
+			if l, s, n := q.Len(), q.Size(), q.Quantity(); l != 2 || s != l || n != l {
+				t.Fatalf("Len %v, Size %v, Quantity %v, want 2 items", l, s, n)
+			}
+			if q.Cap() == q.Len() || q.Free() != q.CapReal()-q.Len() {
+				t.Fatalf("Cap %v, CapReal %v, Free %v: mixed up with the item count", q.Cap(), q.CapReal(), q.Free())
+			}
+		})
+	}
+}
+
+func TestBlockingEnqueueMany(t *testing.T) {
+	for _, k := range kinds {
+		if k.name == "priority" {
+			continue // CapReal counts all the lanes, a batch goes to one
+		}
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			free := int(q.CapReal())
+			for i := 0; i < free-2; i++ {
+				_ = q.Enqueue(i)
+			}
+			batch := []interface{}{"a", "b", "c"}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := q.BlockingEnqueueMany(ctx, batch); err != context.DeadlineExceeded {
+				t.Fatalf("a batch not fitting: %v, want DeadlineExceeded", err)
+			}
+			if l := q.Len(); int(l) != free-2 {
+				t.Fatalf("Len %v after the batch timed out, want %v: written partially", l, free-2)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- q.BlockingEnqueueMany(context.Background(), batch) }()
+			time.Sleep(20 * time.Millisecond)
+			if l := q.Len(); int(l) != free-2 {
+				t.Fatalf("Len %v while the batch waits, want %v: written partially", l, free-2)
+			}
+			_, _ = q.Dequeue()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the batch doesn't go in once it fits")
+			}
+			if l := q.Len(); int(l) != free {
+				t.Fatalf("Len %v after the batch, want %v", l, free)
+			}
+
+			if err := q.BlockingEnqueueMany(context.Background(), make([]interface{}, free+1)); err != ErrBatchTooLarge {
+				t.Fatalf("a batch over the capacity: %v, want ErrBatchTooLarge", err)
+			}
+			_ = q.CloseWrite()
+			if err := q.BlockingEnqueueMany(context.Background(), batch[:1]); !errors.Is(err, ErrClosed) {
+				t.Fatalf("a batch to a closed queue: %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+// TestCloseAndSignal stops the consumers blocked in BlockingDequeue
+// after all the items delivered, with the queue full or not, so that
+// the marker is put or left out.
+func TestCloseAndSignal(t *testing.T) {
+	for _, k := range kinds {
+		for _, full := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%v/full=%v", k.name, full), func(t *testing.T) {
+				q := k.new(8)
+				consumers := 3
+				if k.name == "spsc" {
+					consumers = 1
+				}
+
+				got := make(chan interface{}, q.CapReal())
+				var wg sync.WaitGroup
+				for c := 0; c < consumers; c++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							item, err := q.BlockingDequeue(context.Background())
+							if errors.Is(err, ErrClosed) {
+								return
+							}
+							if err != nil {
+								t.Errorf("BlockingDequeue: %v", err)
+								return
+							}
+							got <- item
+						}
+					}()
+				}
+				n := 0
+				for ; (full || n < 5) && q.TryEnqueue(n); n++ {
+				}
+				_ = q.CloseAndSignal()
+
+				done := make(chan struct{})
+				go func() { wg.Wait(); close(done) }()
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the consumers don't stop")
+				}
+				close(got)
+				seen := make([]bool, n)
+				for item := range got {
+					i, ok := item.(int)
+					if !ok || seen[i] {
+						t.Fatalf("got %v: the marker leaked, or an item delivered twice", item)
+					}
+					seen[i] = true
+				}
+				for i, ok := range seen {
+					if !ok {
+						t.Fatalf("item %v isn't delivered", i)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestEnqueueWhileClosing closes the queue while the producers are
+// enqueuing, every item accepted must reach the consumer before it
+// finds the queue closed. Run it with -race.
+func TestEnqueueWhileClosing(t *testing.T) {
+	const producers, rounds = 3, 300
+	for _, k := range kinds {
+		if k.name == "spsc" {
+			continue
+		}
+		t.Run(k.name, func(t *testing.T) {
+			for r := 0; r < rounds; r++ {
+				q := k.new(16)
+				var accepted, delivered int64
+				var wg sync.WaitGroup
+				for p := 0; p < producers; p++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							switch {
+							case q.TryEnqueue(1):
+								atomic.AddInt64(&accepted, 1)
+							case q.IsClosed():
+								return
+							default:
+								runtime.Gosched() // full
+							}
+						}
+					}()
+				}
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for {
+						_, err := q.Dequeue()
+						switch {
+						case err == nil:
+							delivered++
+						case errors.Is(err, ErrClosed):
+							return
+						default:
+							runtime.Gosched()
+						}
+					}
+				}()
+
+				for i := 0; i < r%8; i++ {
+					runtime.Gosched()
+				}
+				_ = q.Close()
+				wg.Wait()
+				<-done
+				if delivered != accepted {
+					t.Fatalf("round %v: %v items accepted, %v delivered", r, accepted, delivered)
+				}
+			}
+		})
+	}
+}
+
+// TestManyWraparound moves the head and the tail near the end of the
+// slots first, so that the runs of EnqueueMany and DequeueMany cross
+// index 0.
+func TestManyWraparound(t *testing.T) {
+	for _, k := range kinds {
+		if k.name == "priority" {
+			continue // the lanes are separate rings
+		}
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(8)
+			for i := 0; i < 5; i++ {
+				_ = q.Enqueue(i)
+				_, _ = q.Dequeue()
+			}
+
+			items := []interface{}{"a", "b", "c", "d", "e", "f"}
+			if n, err := q.EnqueueMany(items); n != len(items) || err != nil {
+				t.Fatalf("EnqueueMany across the end: %v, %v", n, err)
+			}
+			if l := q.Len(); l != uint32(len(items)) {
+				t.Fatalf("Len %v, want %v", l, len(items))
+			}
+
+			var got []interface{}
+			for _, size := range []int{2, 4} { // the second run crosses index 0
+				dst := make([]interface{}, size)
+				n, err := q.DequeueMany(dst)
+				if n != size || err != nil {
+					t.Fatalf("DequeueMany(%v): %v, %v", size, n, err)
+				}
+				got = append(got, dst[:n]...)
+				if l, want := q.Len(), uint32(len(items)-len(got)); l != want {
+					t.Fatalf("Len %v, want %v", l, want)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(items) {
+				t.Fatalf("got %v, want %v", got, items)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+
+	// signalRingBuf is the ring buffer for WithSignaling(true), it
+	// wraps the lock-free one and wakes the blocked parties through
+	// the channels instead of polling.
+	//
+	// notEmpty and notFull are buffered by one, so the signals sent
+	// while nobody waits are coalesced into one, and the one sent
+	// between a failed Dequeue and the select of a consumer is kept
+	// for it, none of them is missed. Since several signals may be
+	// coalesced into one, the party woken up passes it on to the
+	// next one if there are still items (or free slots) left.
+	signalRingBuf struct {
+		RingBuffer
+		rb        *ringBuf // for the counters of waits
+		notEmpty  chan struct{}
+		notFull   chan struct{}
+		done      chan struct{} // closed by Close, wakes up everyone
+		closeOnce *sync.Once
+	}
+)
+
+func newSignalRingBuf(q RingBuffer, rb *ringBuf) *signalRingBuf {
+	return &signalRingBuf{
+		RingBuffer: q,
+		rb:         rb,
+		notEmpty:   make(chan struct{}, 1),
+		notFull:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		closeOnce:  &sync.Once{},
+	}
+}
+
+// signal wakes up one of the parties waiting on ch, if any.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (rb *signalRingBuf) Put(item interface{}) (err error) {
+	err = rb.Enqueue(item)
+	return
+}
+
+func (rb *signalRingBuf) Enqueue(item interface{}) (err error) {
+	if err = rb.RingBuffer.Enqueue(item); err == nil {
+		signal(rb.notEmpty)
+	}
+	return
+}
+
+func (rb *signalRingBuf) EnqueueFrom(fn func() interface{}) (err error) {
+	if err = rb.RingBuffer.EnqueueFrom(fn); err == nil {
+		signal(rb.notEmpty)
+	}
+	return
+}
+
+func (rb *signalRingBuf) EnqueueMany(items []interface{}) (n int, err error) {
+	if n, err = rb.RingBuffer.EnqueueMany(items); n > 0 {
+		signal(rb.notEmpty)
+	}
+	return
+}
+
+func (rb *signalRingBuf) enqueueAll(items []interface{}) (err error) {
+	if err = rb.RingBuffer.(allEnqueuer).enqueueAll(items); err == nil && len(items) > 0 {
+		signal(rb.notEmpty)
+	}
+	return
+}
+
+// BlockingEnqueueMany waits for the free slots by yielding and
+// sleeping as the lock-free ring buffer does, since a single signal
+// of notFull doesn't tell whether the whole batch fits.
+func (rb *signalRingBuf) BlockingEnqueueMany(ctx context.Context, items []interface{}) (err error) {
+	return blockingEnqueueAll(ctx, rb, &rb.rb.putWaits, rb.rb.spins, items)
+}
+
+func (rb *signalRingBuf) TryEnqueue(item interface{}) (ok bool) {
+	return rb.Enqueue(item) == nil
+}
+
+func (rb *signalRingBuf) EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool) {
+	return enqueueOrDrop(rb, item, onDrop)
+}
+
+func (rb *signalRingBuf) Get() (item interface{}, err error) {
+	item, err = rb.Dequeue()
+	return
+}
+
+func (rb *signalRingBuf) Dequeue() (item interface{}, err error) {
+	if item, err = rb.RingBuffer.Dequeue(); err == nil {
+		signal(rb.notFull)
+	}
+	return
+}
+
+func (rb *signalRingBuf) DequeueInto(fn func(item interface{})) (err error) {
+	return dequeueInto(rb, fn)
+}
+
+func (rb *signalRingBuf) DequeueMany(dst []interface{}) (n int, err error) {
+	if n, err = rb.RingBuffer.DequeueMany(dst); n > 0 {
+		signal(rb.notFull)
+	}
+	return
+}
+
+func (rb *signalRingBuf) Drain() (items []interface{}) {
+	if items = rb.RingBuffer.Drain(); len(items) > 0 {
+		signal(rb.notFull)
+	}
+	return
+}
+
+func (rb *signalRingBuf) TryDequeue() (item interface{}, ok bool) {
+	item, err := rb.Dequeue()
+	return item, err == nil
+}
+
+// BlockingEnqueue waits on notFull while the queue is full, no CPU
+// is burnt.
+func (rb *signalRingBuf) BlockingEnqueue(ctx context.Context, item interface{}) (err error) {
+	for {
+		if err = rb.Enqueue(item); err != ErrQueueFull {
+			if err == nil && rb.Free() > 0 {
+				signal(rb.notFull) // pass on the coalesced signals
+			}
+			return
+		}
+		atomic.AddUint64(&rb.rb.putWaits, 1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-rb.done: // Enqueue tells ErrClosed
+		case <-rb.notFull:
+		}
+	}
+}
+
+// BlockingDequeue waits on notEmpty while the queue is empty, no CPU
+// is burnt.
+func (rb *signalRingBuf) BlockingDequeue(ctx context.Context) (item interface{}, err error) {
+	for {
+		if item, err = rb.Dequeue(); err != ErrQueueEmpty {
+			if err == nil && rb.Len() > 0 {
+				signal(rb.notEmpty) // pass on the coalesced signals
+			}
+			return
+		}
+		atomic.AddUint64(&rb.rb.getWaits, 1)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-rb.done: // Dequeue tells ErrClosed once drained
+		case <-rb.notEmpty:
+		}
+	}
+}
+
+func (rb *signalRingBuf) PutTimeout(item interface{}, d time.Duration) (err error) {
+	return putTimeout(rb, item, d)
+}
+
+func (rb *signalRingBuf) GetTimeout(d time.Duration) (item interface{}, err error) {
+	return getTimeout(rb, d)
+}
+
+// Close is CloseWrite, for io.Closer.
+func (rb *signalRingBuf) Close() (err error) {
+	return rb.CloseWrite()
+}
+
+// CloseWrite closes the queue, and wakes up all the blocked
+// producers and consumers.
+func (rb *signalRingBuf) CloseWrite() (err error) {
+	err = rb.RingBuffer.CloseWrite()
+	rb.closeOnce.Do(func() { close(rb.done) })
+	return
+}
+
+func (rb *signalRingBuf) CloseAndSignal() (err error) {
+	return closeAndSignal(rb, rb.rb.initializer != nil)
+}
+
+// Reset empties the queue, and reopens it if closed and force is
+// true, see ringBuf.Reset.
+func (rb *signalRingBuf) Reset(force bool) (err error) {
+	if err = rb.RingBuffer.Reset(force); err == nil {
+		rb.done, rb.closeOnce = make(chan struct{}), &sync.Once{}
+	}
+	return
+}
+
+func (rb *signalRingBuf) GetGetWaits() uint64 {
+	return rb.rb.GetGetWaits()
+}
+
+func (rb *signalRingBuf) GetPutWaits() uint64 {
+	return rb.rb.GetPutWaits()
+}
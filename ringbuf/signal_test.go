@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// cpuTime returns the CPU time used by the process so far.
+func cpuTime(b *testing.B) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// BenchmarkSignalingIdle makes a consumer wait in BlockingDequeue
+// for the items arriving slowly, and reports the CPU time used per
+// item, with WithSignaling and with the yielding/sleeping.
+func BenchmarkSignalingIdle(b *testing.B) {
+	for _, signaling := range []bool{false, true} {
+		b.Run(fmt.Sprintf("signaling=%v", signaling), func(b *testing.B) {
+			q := New(8, WithSignaling(signaling))
+			done := make(chan struct{})
+			b.ResetTimer()
+			start := cpuTime(b)
+			go func() {
+				defer close(done)
+				for i := 0; i < b.N; i++ {
+					if _, err := q.BlockingDequeue(context.Background()); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			}()
+			for i := 0; i < b.N; i++ {
+				time.Sleep(100 * time.Microsecond)
+				enqueue(q, i)
+			}
+			<-done
+			b.ReportMetric(float64(cpuTime(b)-start)/float64(b.N), "cpu-ns/op")
+		})
+	}
+}
@@ -0,0 +1,458 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type (
+
+	// spscRingBuf is a ring buffer for exactly one producer and one
+	// consumer.
+	//
+	// tail is written by the producer only, and head by the consumer
+	// only, so there is no CAS loop nor slot state machine: the
+	// atomic store of tail publishes the slot to the consumer, and
+	// the atomic store of head gives it back to the producer.
+	spscRingBuf struct {
+		cap         uint32
+		capModMask  uint32
+		limit       uint32
+		_           [CacheLinePadSize - 12]byte
+		head        uint32
+		_           [CacheLinePadSize - 4]byte
+		tail        uint32
+		_           [CacheLinePadSize - 4]byte
+		putWaits    uint64
+		_           [CacheLinePadSize - 8]byte
+		getWaits    uint64
+		_           [CacheLinePadSize - 8]byte
+		expired     uint64
+		_           [CacheLinePadSize - 8]byte
+		highWater   uint32 // written by the producer only
+		_           [CacheLinePadSize - 4]byte
+		data        []interface{}
+		ts          []int64 // enqueued time in unix nano, for WithItemTTL
+		ttl         time.Duration
+		closed      uint32
+		debugMode   bool
+		logger      Logger
+		initializer Initializeable
+		spins       int // see WithSpinsBeforeSleep
+	}
+)
+
+// NewSPSC returns a RingBuffer object which is optimized for the
+// single-producer single-consumer usage.
+//
+// It accepts the same options as New. Calling Enqueue (or the other
+// producer methods) from more than one goroutine at a time, or so
+// Dequeue, will corrupt it.
+func NewSPSC(capacity uint32, opts ...Opt) (ringBuffer RingBuffer) {
+	cfg := &ringBuf{logger: nopLogger{}, spins: -1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cfg.applySpins()
+
+	size := roundUpToPower2(capacity)
+	rb := &spscRingBuf{
+		data:        make([]interface{}, size),
+		cap:         size,
+		capModMask:  size - 1, // = 2^n - 1
+		limit:       size - 1,
+		debugMode:   cfg.debugMode,
+		logger:      cfg.logger,
+		initializer: cfg.initializer,
+		ttl:         cfg.ttl,
+		spins:       cfg.spins,
+	}
+	if rb.ttl > 0 {
+		rb.ts = make([]int64, size)
+	}
+	if cfg.exactCap && capacity > 1 {
+		rb.cap = capacity
+		rb.limit = capacity - 1
+	}
+
+	if rb.initializer != nil {
+		for i := 0; i < (int)(size); i++ {
+			rb.data[i] = rb.initializer.PreAlloc(i)
+		}
+	}
+
+	ringBuffer = cfg.tracked(rb)
+	return
+}
+
+func (rb *spscRingBuf) Put(item interface{}) (err error) {
+	err = rb.Enqueue(item)
+	return
+}
+
+func (rb *spscRingBuf) Enqueue(item interface{}) (err error) {
+	return rb.enqueue(item, nil)
+}
+
+// EnqueueFrom calls fn only if there's a free slot.
+func (rb *spscRingBuf) EnqueueFrom(fn func() interface{}) (err error) {
+	return rb.enqueue(nil, fn)
+}
+
+// enqueue puts item, or the one built by fn if fn isn't nil.
+func (rb *spscRingBuf) enqueue(item interface{}, fn func() interface{}) (err error) {
+	if rb.IsClosed() {
+		return ErrClosed
+	}
+	tail := atomic.LoadUint32(&rb.tail)
+	head := atomic.LoadUint32(&rb.head)
+	if tail-head >= rb.limit {
+		err = ErrQueueFull
+		return
+	}
+
+	if fn != nil {
+		item = fn()
+	}
+	rb.fill(tail, item)
+	atomic.StoreUint32(&rb.tail, tail+1)
+	rb.raiseHighWater(tail + 1)
+
+	if rb.debugMode {
+		rb.logger.Debugf("[W] tail %v => %v, head: %v | ENQUEUED value = %v", tail, tail+1, head, toString(item))
+	}
+	return
+}
+
+func (rb *spscRingBuf) EnqueueMany(items []interface{}) (n int, err error) {
+	if rb.IsClosed() {
+		err = ErrClosed
+		return
+	}
+	tail := atomic.LoadUint32(&rb.tail)
+	head := atomic.LoadUint32(&rb.head)
+	count := rb.limit - (tail - head)
+	if uint32(len(items)) < count {
+		count = uint32(len(items))
+	}
+
+	for i := uint32(0); i < count; i++ {
+		rb.fill(tail+i, items[i])
+	}
+	atomic.StoreUint32(&rb.tail, tail+count)
+	rb.raiseHighWater(tail + count)
+
+	n = int(count)
+	if n < len(items) {
+		err = ErrQueueFull
+	}
+	if rb.debugMode {
+		rb.logger.Debugf("[W] tail %v => %v, head: %v | ENQUEUED %v items", tail, tail+count, head, n)
+	}
+	return
+}
+
+func (rb *spscRingBuf) enqueueAll(items []interface{}) (err error) {
+	if uint32(len(items)) > rb.limit {
+		return ErrBatchTooLarge
+	}
+	if !rb.IsClosed() && rb.Free() < uint32(len(items)) {
+		return ErrQueueFull // the free slots only grow until the producer enqueues
+	}
+	_, err = rb.EnqueueMany(items)
+	return
+}
+
+func (rb *spscRingBuf) BlockingEnqueueMany(ctx context.Context, items []interface{}) (err error) {
+	return blockingEnqueueAll(ctx, rb, &rb.putWaits, rb.spins, items)
+}
+
+func (rb *spscRingBuf) fill(pos uint32, item interface{}) {
+	if rb.initializer != nil {
+		rb.initializer.CloneIn(item, rb.data[pos&rb.capModMask])
+	} else {
+		rb.data[pos&rb.capModMask] = item
+	}
+	if rb.ttl > 0 {
+		rb.ts[pos&rb.capModMask] = time.Now().UnixNano()
+	}
+}
+
+func (rb *spscRingBuf) Get() (item interface{}, err error) {
+	item, err = rb.Dequeue()
+	return
+}
+
+func (rb *spscRingBuf) TryEnqueue(item interface{}) (ok bool) {
+	return rb.Enqueue(item) == nil
+}
+
+func (rb *spscRingBuf) EnqueueOrDrop(item interface{}, onDrop func(item interface{})) (ok bool) {
+	return enqueueOrDrop(rb, item, onDrop)
+}
+
+func (rb *spscRingBuf) TryDequeue() (item interface{}, ok bool) {
+	item, err := rb.Dequeue()
+	return item, err == nil
+}
+
+func (rb *spscRingBuf) DequeueInto(fn func(item interface{})) (err error) {
+	return dequeueInto(rb, fn)
+}
+
+func (rb *spscRingBuf) Dequeue() (item interface{}, err error) {
+	var head, tail uint32
+	for expired := true; expired; {
+		head = atomic.LoadUint32(&rb.head)
+		tail = atomic.LoadUint32(&rb.tail)
+		if head == tail {
+			err = rb.emptyOrClosed(tail)
+			return
+		}
+
+		item, expired = rb.take(head) // discard an expired one, and try the next one
+		atomic.StoreUint32(&rb.head, head+1)
+	}
+
+	if rb.debugMode {
+		rb.logger.Debugf("[ringbuf][GET] cap=%v, qty=%v, tail=%v, head=%v, new head=%v, item=%v", rb.Cap(), tail-head, tail, head, head+1, toString(item))
+	}
+	if item == nil {
+		err = ErrCorrupted
+	}
+	return
+}
+
+func (rb *spscRingBuf) DequeueMany(dst []interface{}) (n int, err error) {
+	if len(dst) == 0 {
+		return
+	}
+
+	head := atomic.LoadUint32(&rb.head)
+	tail := atomic.LoadUint32(&rb.tail)
+	if head == tail {
+		err = rb.emptyOrClosed(tail)
+		return
+	}
+
+	count := tail - head
+	if uint32(len(dst)) < count {
+		count = uint32(len(dst))
+	}
+	for i := uint32(0); i < count; i++ {
+		if item, expired := rb.take(head + i); !expired {
+			dst[n] = item
+			n++
+		}
+	}
+	atomic.StoreUint32(&rb.head, head+count)
+
+	if n == 0 {
+		return rb.DequeueMany(dst) // the whole run expired
+	}
+	return
+}
+
+func (rb *spscRingBuf) Drain() (items []interface{}) {
+	head := atomic.LoadUint32(&rb.head)
+	tail := atomic.LoadUint32(&rb.tail)
+	if head == tail {
+		return
+	}
+
+	items = make([]interface{}, 0, tail-head)
+	for pos := head; pos != tail; pos++ {
+		if item, expired := rb.take(pos); !expired {
+			items = append(items, item)
+		}
+	}
+	atomic.StoreUint32(&rb.head, tail)
+	return
+}
+
+func (rb *spscRingBuf) take(pos uint32) (item interface{}, expired bool) {
+	if rb.initializer != nil {
+		item = rb.initializer.CloneOut(rb.data[pos&rb.capModMask])
+	} else {
+		item = rb.data[pos&rb.capModMask]
+		rb.data[pos&rb.capModMask] = nil
+	}
+	if item == poison {
+		expired, item = true, nil // see CloseAndSignal
+	} else if rb.ttl > 0 && time.Now().UnixNano()-rb.ts[pos&rb.capModMask] > int64(rb.ttl) {
+		expired, item = true, nil
+		atomic.AddUint64(&rb.expired, 1)
+	}
+	return
+}
+
+func (rb *spscRingBuf) Peek() (item interface{}, err error) {
+	head := atomic.LoadUint32(&rb.head)
+	tail := atomic.LoadUint32(&rb.tail)
+	if head == tail {
+		err = rb.emptyOrClosed(tail)
+		return
+	}
+	if item = rb.data[head&rb.capModMask]; item == poison {
+		item, err = nil, ErrClosed
+	}
+	return
+}
+
+// ForEach walks the items from head to tail without dequeuing
+// them, and stops as soon as fn returns false. It must be called
+// from the consumer goroutine.
+func (rb *spscRingBuf) ForEach(fn func(index int, item interface{}) bool) {
+	head := atomic.LoadUint32(&rb.head)
+	tail := atomic.LoadUint32(&rb.tail)
+	for i := uint32(0); i < tail-head; i++ {
+		if item := rb.data[(head+i)&rb.capModMask]; item != poison && !fn(int(i), item) {
+			return
+		}
+	}
+}
+
+func (rb *spscRingBuf) BlockingEnqueue(ctx context.Context, item interface{}) (err error) {
+	return blockingEnqueue(ctx, rb, &rb.putWaits, rb.spins, item)
+}
+
+func (rb *spscRingBuf) BlockingDequeue(ctx context.Context) (item interface{}, err error) {
+	return blockingDequeue(ctx, rb, &rb.getWaits, rb.spins)
+}
+
+func (rb *spscRingBuf) PutTimeout(item interface{}, d time.Duration) (err error) {
+	return putTimeout(rb, item, d)
+}
+
+func (rb *spscRingBuf) GetTimeout(d time.Duration) (item interface{}, err error) {
+	return getTimeout(rb, d)
+}
+
+func (rb *spscRingBuf) GetGetWaits() uint64 {
+	return atomic.LoadUint64(&rb.getWaits)
+}
+
+func (rb *spscRingBuf) GetPutWaits() uint64 {
+	return atomic.LoadUint64(&rb.putWaits)
+}
+
+func (rb *spscRingBuf) ExpiredCount() uint64 {
+	return atomic.LoadUint64(&rb.expired)
+}
+
+func (rb *spscRingBuf) ResetCounters() {
+	atomic.StoreUint64(&rb.getWaits, 0)
+	atomic.StoreUint64(&rb.putWaits, 0)
+	atomic.StoreUint64(&rb.expired, 0)
+	atomic.StoreUint32(&rb.highWater, 0)
+}
+
+func (rb *spscRingBuf) HighWaterMark() uint32 {
+	return atomic.LoadUint32(&rb.highWater)
+}
+
+// raiseHighWater is called by the producer after the tail advanced.
+func (rb *spscRingBuf) raiseHighWater(tail uint32) {
+	if qty := tail - atomic.LoadUint32(&rb.head); qty > atomic.LoadUint32(&rb.highWater) {
+		atomic.StoreUint32(&rb.highWater, qty)
+	}
+}
+
+// Close is CloseWrite, for io.Closer.
+func (rb *spscRingBuf) Close() (err error) {
+	return rb.CloseWrite()
+}
+
+func (rb *spscRingBuf) CloseWrite() (err error) {
+	atomic.StoreUint32(&rb.closed, 1)
+	return
+}
+
+func (rb *spscRingBuf) CloseAndSignal() (err error) {
+	return closeAndSignal(rb, rb.initializer != nil)
+}
+
+func (rb *spscRingBuf) IsClosed() bool {
+	return atomic.LoadUint32(&rb.closed) == 1
+}
+
+func (rb *spscRingBuf) emptyOrClosed(tail uint32) error {
+	if rb.IsClosed() && atomic.LoadUint32(&rb.tail) == tail {
+		return ErrClosed
+	}
+	return ErrQueueEmpty
+}
+
+// Quantity is an alias of Len.
+func (rb *spscRingBuf) Quantity() uint32 {
+	return rb.Len()
+}
+
+// Size is an alias of Len.
+func (rb *spscRingBuf) Size() uint32 {
+	return rb.Len()
+}
+
+func (rb *spscRingBuf) Len() (quantity uint32) {
+	head := atomic.LoadUint32(&rb.head)
+	tail := atomic.LoadUint32(&rb.tail)
+	if quantity = tail - head; quantity > rb.limit {
+		quantity = rb.limit // head was loaded before a concurrent dequeue
+	}
+	return
+}
+
+func (rb *spscRingBuf) Free() uint32 {
+	return rb.limit - rb.Len()
+}
+
+func (rb *spscRingBuf) Cap() uint32 {
+	return rb.cap
+}
+
+func (rb *spscRingBuf) CapReal() uint32 {
+	return rb.limit
+}
+
+func (rb *spscRingBuf) IsEmpty() (b bool) {
+	return atomic.LoadUint32(&rb.head) == atomic.LoadUint32(&rb.tail)
+}
+
+func (rb *spscRingBuf) IsFull() (b bool) {
+	return rb.Size() >= rb.limit
+}
+
+// Reset will clear the whole queue without reallocating the slots.
+//
+// It's unsafe while the producer or the consumer is working, so
+// ErrQueueNotEmpty returned if there are items still in the queue,
+// unless force is true. A closed queue is reopened only if force is
+// true, see ringBuf.Reset.
+func (rb *spscRingBuf) Reset(force bool) (err error) {
+	if !force && rb.IsClosed() {
+		return ErrClosed
+	}
+	if !force && rb.Size() != 0 {
+		return ErrQueueNotEmpty
+	}
+	if rb.initializer == nil {
+		for i := range rb.data {
+			rb.data[i] = nil
+		}
+	}
+	atomic.StoreUint32(&rb.head, 0)
+	atomic.StoreUint32(&rb.tail, 0)
+	atomic.StoreUint32(&rb.closed, 0)
+	return
+}
+
+func (rb *spscRingBuf) Debug(enabled bool) (lastState bool) {
+	lastState = rb.debugMode
+	rb.debugMode = enabled
+	return
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestMPMCBoundaries runs many producers and consumers on a tiny
+// queue, so that it's full or empty most of the time, and checks
+// that every item is delivered exactly once. Run it with -race.
+func TestMPMCBoundaries(t *testing.T) {
+	const producers, consumers, perProducer = 4, 4, 2000
+	for _, k := range kinds {
+		if k.name == "spsc" {
+			continue
+		}
+		t.Run(k.name, func(t *testing.T) {
+			q := k.new(4)
+			seen := make([]int32, producers*perProducer)
+			var full, empty uint64
+
+			var pwg, cwg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				pwg.Add(1)
+				go func(p int) {
+					defer pwg.Done()
+					for i := 0; i < perProducer; i++ {
+						for {
+							err := q.Enqueue(p*perProducer + i)
+							if err == nil {
+								break
+							}
+							if !errors.Is(err, ErrQueueFull) {
+								t.Errorf("Enqueue: %v", err)
+								return
+							}
+							atomic.AddUint64(&full, 1)
+							runtime.Gosched()
+						}
+					}
+				}(p)
+			}
+			for c := 0; c < consumers; c++ {
+				cwg.Add(1)
+				go func() {
+					defer cwg.Done()
+					for {
+						item, err := q.Dequeue()
+						switch {
+						case err == nil:
+							atomic.AddInt32(&seen[item.(int)], 1)
+						case errors.Is(err, ErrQueueEmpty):
+							atomic.AddUint64(&empty, 1)
+							runtime.Gosched()
+						case errors.Is(err, ErrClosed):
+							return
+						default:
+							t.Errorf("Dequeue: %v", err)
+							return
+						}
+					}
+				}()
+			}
+
+			pwg.Wait()
+			_ = q.CloseWrite()
+			cwg.Wait()
+
+			for v, n := range seen {
+				if n != 1 {
+					t.Fatalf("item %v delivered %v times", v, n)
+				}
+			}
+			if l := q.Len(); l != 0 {
+				t.Fatalf("Len of the drained queue: %v", l)
+			}
+			t.Logf("%v full and %v empty retries", full, empty)
+		})
+	}
+}
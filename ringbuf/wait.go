@@ -0,0 +1,77 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package ringbuf
+
+import (
+	"runtime"
+	"time"
+)
+
+type (
+	// WaitStrategy decides how a producer or a consumer waits while
+	// it's contending for the head/tail, or waiting on a slot which
+	// is being written/read by others.
+	//
+	// iteration counts the retries from 0 in the current operation.
+	WaitStrategy interface {
+		Wait(iteration int)
+	}
+
+	// BusySpin retries immediately. It gives the lowest latency but
+	// burns a CPU core, only use it if every party owns a core.
+	BusySpin struct{}
+
+	// Yield gives up the processor by runtime.Gosched on each retry.
+	Yield struct{}
+
+	// SleepBackoff sleeps Min on the first retry, and doubles it on
+	// each retry later, up to Max.
+	SleepBackoff struct {
+		Min, Max time.Duration
+	}
+
+	// Hybrid yields for the first Spins retries, and falls back to
+	// the Backoff sleeping after that.
+	Hybrid struct {
+		Spins   int
+		Backoff SleepBackoff
+	}
+)
+
+// DefaultWaitStrategy is used if WithWaitStrategy isn't specified.
+var DefaultWaitStrategy WaitStrategy = Hybrid{
+	Spins:   spinsBeforeSleep,
+	Backoff: SleepBackoff{Min: time.Microsecond, Max: maxBackoff},
+}
+
+func (BusySpin) Wait(iteration int) {}
+
+func (Yield) Wait(iteration int) {
+	runtime.Gosched()
+}
+
+func (s SleepBackoff) Wait(iteration int) {
+	time.Sleep(s.duration(iteration))
+}
+
+// duration returns how long to sleep on the retry iteration.
+func (s SleepBackoff) duration(iteration int) (d time.Duration) {
+	d = s.Min
+	for i := 0; i < iteration && d < s.Max; i++ {
+		d <<= 1
+	}
+	if d > s.Max {
+		d = s.Max
+	}
+	return
+}
+
+func (s Hybrid) Wait(iteration int) {
+	if iteration < s.Spins {
+		runtime.Gosched()
+		return
+	}
+	s.Backoff.Wait(iteration - s.Spins)
+}
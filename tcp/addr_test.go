@@ -0,0 +1,35 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddrEphemeralPort(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", echoLines()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := s.Addr(); a != nil {
+		t.Fatalf("Addr before Start: %v, want nil", a)
+	}
+	if err = s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.StopWithTimeout(0) })
+
+	a, ok := s.Addr().(*net.TCPAddr)
+	if !ok || a.Port == 0 {
+		t.Fatalf("Addr after listening on port 0: %v", s.Addr())
+	}
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "hi")
+	if line, err := c.recv(time.Second); err != nil || line != "hi" {
+		t.Fatalf("echo through %v: %q, %v", a, line, err)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tokenAuth reads a token of 4 bytes, and accepts "good" only, the
+// identity is the token.
+func tokenAuth() ServerOpt {
+	return WithServerAuthenticator(func(conn *Conn) (identity interface{}, err error) {
+		buf := make([]byte, 4)
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		if string(buf) != "good" {
+			return nil, errors.New("bad token")
+		}
+		return "user:" + string(buf), nil
+	})
+}
+
+// whoAmI replies the identity of the connection to each line.
+func whoAmI() ServerOpt {
+	return WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+		return out.WriteMessage([]byte(fmt.Sprint(ConnFromContext(ctx).Identity())))
+	})
+}
+
+func TestAuthenticatorAccepts(t *testing.T) {
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)), tokenAuth(), whoAmI())
+	c := newLineConn(dialTestServer(t, s))
+	if _, err := c.Write([]byte("good")); err != nil {
+		t.Fatal(err)
+	}
+	c.send(t, "who")
+	if line, err := c.recv(time.Second); err != nil || line != "user:good" {
+		t.Fatalf("identity seen by the handler: %q, %v", line, err)
+	}
+	if accepted, rejected := s.AuthStats(); accepted != 1 || rejected != 0 {
+		t.Fatalf("AuthStats: %v accepted, %v rejected", accepted, rejected)
+	}
+}
+
+func TestAuthenticatorRejects(t *testing.T) {
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)), tokenAuth(), whoAmI())
+	c := newLineConn(dialTestServer(t, s))
+	if _, err := c.Write([]byte("bad!")); err != nil {
+		t.Fatal(err)
+	}
+	c.send(t, "who")
+	// closed: io.EOF, or a reset since "who" was left unread
+	var ne net.Error
+	if line, err := c.recv(time.Second); err == nil || errors.As(err, &ne) && ne.Timeout() {
+		t.Fatalf("rejected connection read %q, %v, want it closed", line, err)
+	}
+	if accepted, rejected := s.AuthStats(); accepted != 0 || rejected != 1 {
+		t.Fatalf("AuthStats: %v accepted, %v rejected", accepted, rejected)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	log2 "log"
+	"sync"
+)
+
+// BufferPool is a pool of the reusable byte slices with a fixed
+// size, see WithServerReadBufferPool.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool returns a BufferPool of the size-bytes slices.
+func NewBufferPool(size int) *BufferPool {
+	if size <= 0 {
+		log2.Panicf("wrong buffer size: %v", size)
+	}
+	p := &BufferPool{size: size}
+	p.pool.New = func() interface{} {
+		return make([]byte, size)
+	}
+	return p
+}
+
+// Size returns the length of the slices from Get.
+func (p *BufferPool) Size() int { return p.size }
+
+// Get returns a slice of Size() bytes, its content is undefined.
+func (p *BufferPool) Get() []byte {
+	return p.pool.Get().([]byte)
+}
+
+// Put returns buf to the pool, buf must not be used any more. The
+// slices smaller than Size() are dropped.
+func (p *BufferPool) Put(buf []byte) {
+	if cap(buf) < p.size {
+		return
+	}
+	p.pool.Put(buf[:p.size])
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// BenchmarkReadBufferPool decodes the frames as the server does with
+// and without WithServerReadBufferPool, run it with -benchmem.
+func BenchmarkReadBufferPool(b *testing.B) {
+	c := NewLengthPrefixedCodec(4, 0)
+	var frame bytes.Buffer
+	_ = c.Encode(&frame, bytes.Repeat([]byte("x"), 1024))
+	r := bytes.NewReader(nil)
+
+	b.Run("alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r.Reset(frame.Bytes())
+			if _, err := c.Decode(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		pool := NewBufferPool(4096)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := pool.Get()
+			r.Reset(frame.Bytes())
+			if _, err := c.DecodeBuffer(r, buf); err != nil {
+				b.Fatal(err)
+			}
+			pool.Put(buf)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// BufferedWriter is the writer passed to the handlers by default. It
+// coalesces the small writes into fewer write syscalls: the data is
+// sent when the buffer is full, Flush is called, the server is about
+// to wait for the next request, the connection is closing, or the
+// interval of WithServerWriteFlushInterval elapsed since the first
+// write not flushed yet.
+//
+// BufferedWriter is safe for the concurrent use.
+type BufferedWriter struct {
+	mu       sync.Mutex
+	w        *bufio.Writer
+	interval time.Duration
+	timer    *time.Timer // armed while the data is pending
+	armed    bool
+}
+
+func newBufferedWriter(w io.Writer, interval time.Duration) *BufferedWriter {
+	return &BufferedWriter{w: bufio.NewWriter(w), interval: interval}
+}
+
+func (b *BufferedWriter) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, err = b.w.Write(p)
+	if b.interval > 0 && !b.armed && b.w.Buffered() > 0 {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.interval, func() { _ = b.Flush() })
+		} else {
+			b.timer.Reset(b.interval)
+		}
+		b.armed = true
+	}
+	return
+}
+
+// Flush sends the buffered data to the connection.
+func (b *BufferedWriter) Flush() (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.armed {
+		b.timer.Stop()
+		b.armed = false
+	}
+	return b.w.Flush()
+}
+
+// Buffered returns the number of bytes not sent yet.
+func (b *BufferedWriter) Buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Buffered()
+}
+
+// flushIdle flushes w before waiting on r for the next request, that
+// is, unless r has buffered the data already.
+func flushIdle(r io.Reader, w io.Writer) (err error) {
+	if br, ok := r.(interface{ Buffered() int }); ok && br.Buffered() > 0 {
+		return
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		err = f.Flush()
+	}
+	return
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"testing"
+)
+
+// writeCounter counts the writes reaching it, that is, the write
+// syscalls of a connection.
+type writeCounter struct{ writes int }
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+// BenchmarkWriteCoalescing writes a burst of small messages as a
+// chatty handler does, with a flush per message, and with the
+// coalescing of WithServerWriteFlushInterval and a flush at the end.
+func BenchmarkWriteCoalescing(b *testing.B) {
+	const burst = 16
+	msg := []byte("a small message")
+	for _, coalesce := range []bool{false, true} {
+		name := "flush-each"
+		if coalesce {
+			name = "coalesced"
+		}
+		b.Run(name, func(b *testing.B) {
+			wire := &writeCounter{}
+			out := &messageWriter{codec: NewLineCodec(0), w: newBufferedWriter(wire, 0), coalesce: coalesce}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < burst; j++ {
+					if err := out.WriteMessage(msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+				_ = out.Flush()
+			}
+			b.ReportMetric(float64(wire.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// asyncCalls answers each call after the delay in milliseconds
+// given by the request, so the responses go out of order.
+func asyncCalls() ServerOpt {
+	return WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+		if len(msg) < CallIDSize {
+			return ErrMalformedCall
+		}
+		conn := ConnFromContext(ctx)
+		go func(msg []byte) {
+			ms, _ := strconv.Atoi(string(msg[CallIDSize:]))
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			_ = conn.Send(append(msg, " done"...))
+		}(append([]byte(nil), msg...))
+		return nil
+	})
+}
+
+func dialCalls(t *testing.T, s *Server, opts ...ClientOpt) *Client {
+	t.Helper()
+	c, err := Dial(s.Addr().String(), append([]ClientOpt{WithClientCodec(NewLengthPrefixedCodec(4, 0))}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestCallOutOfOrder(t *testing.T) {
+	s := startTestServer(t, WithServerCodec(NewLengthPrefixedCodec(4, 0)), asyncCalls())
+	c := dialCalls(t, s)
+
+	var wg sync.WaitGroup
+	var order []string
+	var mu sync.Mutex
+	for _, ms := range []int{300, 200, 100, 0} {
+		wg.Add(1)
+		go func(req string) {
+			defer wg.Done()
+			resp, err := c.Call(context.Background(), []byte(req))
+			if err != nil || string(resp) != req+" done" {
+				t.Errorf("call %v: %q, %v", req, resp, err)
+			}
+			mu.Lock()
+			order = append(order, req)
+			mu.Unlock()
+		}(strconv.Itoa(ms))
+		time.Sleep(10 * time.Millisecond) // sent in order
+	}
+	wg.Wait()
+	if got := fmt.Sprint(order); got != "[0 100 200 300]" {
+		t.Fatalf("the responses weren't matched out of order: %v", got)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	s := startTestServer(t, WithServerCodec(NewLengthPrefixedCodec(4, 0)), asyncCalls())
+	c := dialCalls(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, []byte("300")); err != context.DeadlineExceeded {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	c.callsMu.Lock()
+	pending := len(c.calls)
+	c.callsMu.Unlock()
+	if pending != 0 {
+		t.Fatalf("%v calls left pending", pending)
+	}
+	if !waitFor(time.Second, func() bool { return c.DroppedResponses() == 1 }) {
+		t.Fatalf("the late response wasn't dropped: %v", c.DroppedResponses())
+	}
+	if resp, err := c.Call(context.Background(), []byte("0")); err != nil || string(resp) != "0 done" {
+		t.Fatalf("the next call failed: %q, %v", resp, err)
+	}
+}
+
+// TestCallAnswersServerHeartbeat keeps an idle Call client alive
+// under the heartbeat of the server.
+func TestCallAnswersServerHeartbeat(t *testing.T) {
+	var lost int32
+	s := startTestServer(t,
+		WithServerCodec(NewLengthPrefixedCodec(4, 0)),
+		WithServerHeartbeat(50*time.Millisecond, []byte("PING"), []byte("PONG")),
+		WithServerOnDisconnect(func(info ConnInfo, err error) { atomic.AddInt32(&lost, 1) }),
+		asyncCalls())
+	c := dialCalls(t, s, WithClientHeartbeat(0, []byte("PING"), []byte("PONG")))
+
+	time.Sleep(400 * time.Millisecond)
+	if n := atomic.LoadInt32(&lost); n != 0 {
+		t.Fatal("the idle client was declared dead")
+	}
+	if resp, err := c.Call(context.Background(), []byte("0")); err != nil || string(resp) != "0 done" {
+		t.Fatalf("the call after idling failed: %q, %v", resp, err)
+	}
+	if n := c.DroppedResponses(); n != 0 {
+		t.Fatalf("the pings were taken as the responses: %v", n)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/hedzr/go-socketlib/ringbuf"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientSendOrdered(t *testing.T) {
+	const senders, n = 4, 200
+	received := make(chan string, senders*n)
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			received <- string(msg)
+			return nil
+		}))
+	c, err := Dial(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < senders; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				for c.Send([]byte(fmt.Sprintf("%d %d\n", g, i))) != nil { // raw bytes, not encoded
+					time.Sleep(time.Millisecond) // the queue is full
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = c.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	next := make([]int, senders)
+	for k := 0; k < senders*n; k++ {
+		var msg string
+		select {
+		case msg = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %v messages, want %v", k, senders*n)
+		}
+		var g, i int
+		if _, err := fmt.Sscanf(msg, "%d %d", &g, &i); err != nil || g < 0 || g >= senders {
+			t.Fatalf("a message is corrupted: %q", msg)
+		}
+		if i != next[g] {
+			t.Fatalf("sender %v: got #%v, want #%v", g, i, next[g])
+		}
+		next[g]++
+	}
+}
+
+func TestClientSendClosed(t *testing.T) {
+	s := startTestServer(t, echoLines()...)
+	c, err := Dial(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err = c.Send([]byte("late")); err != ErrNotConnected {
+		t.Fatalf("Send after Close: %v, want ErrNotConnected", err)
+	}
+	if err = c.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush after Close: %v", err)
+	}
+}
+
+// TestClientSendIdleWriter checks that the writer of an idle client
+// blocks on the outbound queue instead of polling it.
+func TestClientSendIdleWriter(t *testing.T) {
+	s := startTestServer(t, echoLines()...)
+	c, err := Dial(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = c.Send([]byte("hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = c.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	q := c.sendQueue.(ringbuf.Dbg)
+
+	before := q.GetGetWaits()
+	time.Sleep(100 * time.Millisecond)
+	if waits := q.GetGetWaits() - before; waits > 1 {
+		t.Fatalf("the idle writer waited %v times in 100ms, want it blocked", waits)
+	}
+}
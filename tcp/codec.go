@@ -0,0 +1,289 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	log2 "log"
+	"sync"
+)
+
+var (
+	// ErrMessageTooLarge is returned by a Codec if a message
+	// exceeds the max size, see WithServerMaxMessageSize. The
+	// decoders check the size declared by the header (or
+	// accumulated so far) before allocating for it.
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrFrameTooLarge is the former name of ErrMessageTooLarge.
+	//
+	// Deprecated: use ErrMessageTooLarge.
+	ErrFrameTooLarge = ErrMessageTooLarge
+	// ErrFrameTimeout is the reason of closing a connection which
+	// didn't complete a message in time, see
+	// WithServerFrameReadTimeout.
+	ErrFrameTimeout = errors.New("frame read timed out")
+)
+
+// Codec splits a byte stream into the discrete messages, and vice
+// versa. See also WithServerCodec.
+type Codec interface {
+	// Encode writes msg as one frame.
+	Encode(w io.Writer, msg []byte) error
+	// Decode reads one frame and returns the message in it.
+	Decode(r io.Reader) ([]byte, error)
+}
+
+// BufferedDecoder is implemented by the Codecs which can decode into
+// a given buffer, the server uses it to decode the messages into the
+// buffers from the pool specified by WithServerReadBufferPool.
+type BufferedDecoder interface {
+	// DecodeBuffer is like Codec.Decode but decodes into buf if the
+	// message fits in it, so that the returned message is valid
+	// until buf is reused.
+	DecodeBuffer(r io.Reader, buf []byte) ([]byte, error)
+}
+
+// sizeLimiter is implemented by the builtin Codecs, for
+// WithServerMaxMessageSize and WithClientMaxMessageSize.
+type sizeLimiter interface {
+	// withMaxSize returns a copy of the codec limited to maxSize.
+	withMaxSize(maxSize int) Codec
+}
+
+// limitCodec returns codec limited to maxSize if maxSize > 0 and
+// codec is a builtin one, or codec as is.
+func limitCodec(codec Codec, maxSize int) Codec {
+	if l, ok := codec.(sizeLimiter); ok && maxSize > 0 {
+		return l.withMaxSize(maxSize)
+	}
+	return codec
+}
+
+// MessageWriter writes the whole messages through a Codec.
+type MessageWriter interface {
+	WriteMessage(msg []byte) error
+}
+
+// LengthPrefixedCodec frames each message with a big-endian length
+// header of 2 or 4 bytes.
+type LengthPrefixedCodec struct {
+	headerSize int
+	maxSize    int
+}
+
+// DefaultMaxMessageSize is the max message size of the codecs if
+// not specified, neither by the constructors nor by
+// WithServerMaxMessageSize. It's kept low since a peer can make the
+// decoder allocate that much for each message.
+const DefaultMaxMessageSize = 1024 * 1024
+
+// NewLengthPrefixedCodec returns a LengthPrefixedCodec with a
+// headerSize-bytes (2 or 4) length header. The frames longer than
+// maxSize are rejected by Decode without reading the body, so that
+// a malicious peer cannot exhaust the memory. maxSize <= 0 means
+// DefaultMaxMessageSize.
+func NewLengthPrefixedCodec(headerSize, maxSize int) *LengthPrefixedCodec {
+	if headerSize != 2 && headerSize != 4 {
+		log2.Panicf("wrong header size: %v", headerSize)
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	if headerSize == 2 && maxSize > 0xffff {
+		maxSize = 0xffff
+	}
+	return &LengthPrefixedCodec{headerSize: headerSize, maxSize: maxSize}
+}
+
+func (c *LengthPrefixedCodec) withMaxSize(maxSize int) Codec {
+	return NewLengthPrefixedCodec(c.headerSize, maxSize)
+}
+
+func (c *LengthPrefixedCodec) Encode(w io.Writer, msg []byte) (err error) {
+	if len(msg) > c.maxSize {
+		return ErrMessageTooLarge
+	}
+
+	frame := make([]byte, c.headerSize+len(msg))
+	if c.headerSize == 2 {
+		binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	} else {
+		binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	}
+	copy(frame[c.headerSize:], msg)
+	_, err = w.Write(frame)
+	return
+}
+
+func (c *LengthPrefixedCodec) Decode(r io.Reader) (msg []byte, err error) {
+	return c.DecodeBuffer(r, nil)
+}
+
+// DecodeBuffer implements BufferedDecoder.
+func (c *LengthPrefixedCodec) DecodeBuffer(r io.Reader, buf []byte) (msg []byte, err error) {
+	var header [4]byte
+	if _, err = io.ReadFull(r, header[:c.headerSize]); err != nil {
+		return
+	}
+
+	var size int
+	if c.headerSize == 2 {
+		size = int(binary.BigEndian.Uint16(header[:]))
+	} else {
+		size = int(binary.BigEndian.Uint32(header[:]))
+	}
+	if size > c.maxSize || size < 0 {
+		return nil, ErrMessageTooLarge
+	}
+
+	if buf != nil && size <= cap(buf) {
+		msg = buf[:size]
+	} else {
+		msg = make([]byte, size)
+	}
+	if _, err = io.ReadFull(r, msg); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// DelimiterCodec splits the stream on a delimiter byte, for the
+// line-oriented and text protocols.
+type DelimiterCodec struct {
+	delim   byte
+	maxSize int
+}
+
+// NewDelimiterCodec returns a DelimiterCodec splitting on delim. A
+// message (not including delim) longer than maxSize is rejected
+// with ErrMessageTooLarge rather than buffered unbounded. maxSize <= 0
+// means DefaultMaxMessageSize.
+func NewDelimiterCodec(delim byte, maxSize int) *DelimiterCodec {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	return &DelimiterCodec{delim: delim, maxSize: maxSize}
+}
+
+func (c *DelimiterCodec) withMaxSize(maxSize int) Codec {
+	return NewDelimiterCodec(c.delim, maxSize)
+}
+
+// NewLineCodec returns a DelimiterCodec splitting on '\n'.
+func NewLineCodec(maxSize int) *DelimiterCodec {
+	return NewDelimiterCodec('\n', maxSize)
+}
+
+// Encode writes msg followed by the delimiter.
+func (c *DelimiterCodec) Encode(w io.Writer, msg []byte) (err error) {
+	if len(msg) > c.maxSize {
+		return ErrMessageTooLarge
+	}
+
+	frame := make([]byte, len(msg)+1)
+	copy(frame, msg)
+	frame[len(msg)] = c.delim
+	_, err = w.Write(frame)
+	return
+}
+
+// Decode returns the bytes before the next delimiter. Like
+// bufio.Scanner, the final message without a trailing delimiter is
+// returned at the end of stream.
+//
+// r should be buffered (such as a *bufio.Reader, which the server
+// uses by default), or it is read byte by byte, so that nothing
+// after the delimiter is consumed.
+func (c *DelimiterCodec) Decode(r io.Reader) (msg []byte, err error) {
+	return c.DecodeBuffer(r, make([]byte, 0, 64))
+}
+
+// DecodeBuffer implements BufferedDecoder.
+func (c *DelimiterCodec) DecodeBuffer(r io.Reader, buf []byte) (msg []byte, err error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{r}
+	}
+
+	msg = buf[:0]
+	for {
+		var b byte
+		if b, err = br.ReadByte(); err != nil {
+			if err == io.EOF && len(msg) > 0 {
+				return msg, nil
+			}
+			return nil, err
+		}
+		if b == c.delim {
+			return
+		}
+		if len(msg) >= c.maxSize {
+			return nil, ErrMessageTooLarge
+		}
+		msg = append(msg, b)
+	}
+}
+
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (b byte, err error) {
+	var buf [1]byte
+	if _, err = io.ReadFull(r.Reader, buf[:]); err == nil {
+		b = buf[0]
+	}
+	return
+}
+
+type messageWriter struct {
+	codec    Codec
+	w        io.Writer
+	mu       sync.Mutex // the heartbeat writes concurrently with the handler
+	coalesce bool       // leave the flushing to w, see WithServerWriteFlushInterval
+}
+
+func (mw *messageWriter) WriteMessage(msg []byte) (err error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if err = mw.codec.Encode(mw.w, msg); err != nil || mw.coalesce {
+		return
+	}
+	return mw.flush()
+}
+
+func (mw *messageWriter) setCodec(codec Codec) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.codec = codec
+}
+
+// Flush sends the messages written so far, the handlers can reach it
+// by asserting the MessageWriter to interface{ Flush() error }.
+func (mw *messageWriter) Flush() (err error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	return mw.flush()
+}
+
+// writeFrame writes an encoded frame as is, such as the control
+// frames of WebSocketCodec.
+func (mw *messageWriter) writeFrame(frame []byte) (err error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if _, err = mw.w.Write(frame); err != nil {
+		return
+	}
+	return mw.flush()
+}
+
+func (mw *messageWriter) flush() (err error) {
+	if f, ok := mw.w.(interface{ Flush() error }); ok {
+		err = f.Flush()
+	}
+	return
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDelimiterCodecRoundTrip(t *testing.T) {
+	c := NewDelimiterCodec(0, 0)
+	var buf bytes.Buffer
+	for _, msg := range []string{"first", "", "third"} {
+		if err := c.Encode(&buf, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.String() != "first\x00\x00third\x00" {
+		t.Fatalf("encoded %q", buf.String())
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, want := range []string{"first", "", "third"} {
+		if msg, err := c.Decode(r); err != nil || string(msg) != want {
+			t.Fatalf("Decode: %q, %v, want %q", msg, err, want)
+		}
+	}
+	if msg, err := c.Decode(r); err != io.EOF {
+		t.Fatalf("Decode at the end: %q, %v, want io.EOF", msg, err)
+	}
+}
+
+func TestDelimiterCodecFinalMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("a\nno newline"))
+	c := NewLineCodec(0)
+	for _, want := range []string{"a", "no newline"} {
+		if msg, err := c.Decode(r); err != nil || string(msg) != want {
+			t.Fatalf("Decode: %q, %v, want %q", msg, err, want)
+		}
+	}
+	if _, err := c.Decode(r); err != io.EOF {
+		t.Fatalf("Decode at the end: %v, want io.EOF", err)
+	}
+}
+
+func TestDelimiterCodecMaxSize(t *testing.T) {
+	c := NewLineCodec(4)
+	if _, err := c.Decode(bufio.NewReader(strings.NewReader("12345\n"))); err != ErrMessageTooLarge {
+		t.Fatalf("Decode of 5 bytes: %v, want ErrMessageTooLarge", err)
+	}
+	if msg, err := c.Decode(bufio.NewReader(strings.NewReader("1234\n"))); err != nil || string(msg) != "1234" {
+		t.Fatalf("Decode of 4 bytes: %q, %v", msg, err)
+	}
+	if err := c.Encode(io.Discard, []byte("12345")); err != ErrMessageTooLarge {
+		t.Fatalf("Encode of 5 bytes: %v, want ErrMessageTooLarge", err)
+	}
+}
+
+// TestDelimiterCodecUnbuffered checks nothing after the delimiter is
+// consumed from a reader which isn't buffered.
+func TestDelimiterCodecUnbuffered(t *testing.T) {
+	r := strings.NewReader("line\nrest")
+	if msg, err := NewLineCodec(0).Decode(struct{ io.Reader }{r}); err != nil || string(msg) != "line" {
+		t.Fatalf("Decode: %q, %v", msg, err)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+		t.Fatalf("left %q in the reader, want \"rest\"", rest)
+	}
+}
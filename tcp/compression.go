@@ -0,0 +1,131 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	log2 "log"
+	"net"
+	"sync"
+)
+
+// Compression creates the streams of a compression algorithm, see
+// WithServerCompression and WithClientCompression.
+//
+// The other algorithms, such as snappy, can be plugged by
+// implementing it, the framing format of the algorithm should
+// support flushing since the writer is flushed after each Write.
+type Compression interface {
+	// NewReader returns the decompressing reader of r, it's called
+	// before the first reading.
+	NewReader(r io.Reader) (io.Reader, error)
+	// NewWriter returns the compressing writer to w.
+	NewWriter(w io.Writer) CompressWriter
+}
+
+// CompressWriter is the compressing writer of a Compression.
+type CompressWriter interface {
+	io.WriteCloser
+	// Flush writes the pending data, so that the peer can
+	// decompress all data written so far.
+	Flush() error
+}
+
+var (
+	// CompressionGzip compresses the stream in gzip with the
+	// default level.
+	CompressionGzip = NewGzipCompression(gzip.DefaultCompression)
+	// CompressionDeflate compresses the stream in raw deflate
+	// (RFC 1951) with the default level, which has smaller
+	// overhead than gzip.
+	CompressionDeflate = NewDeflateCompression(flate.DefaultCompression)
+)
+
+type gzipCompression struct {
+	level int
+}
+
+// NewGzipCompression returns the gzip Compression with level, such
+// as gzip.BestSpeed.
+func NewGzipCompression(level int) Compression {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		log2.Panicf("wrong gzip level: %v", level)
+	}
+	return gzipCompression{level: level}
+}
+
+func (c gzipCompression) NewReader(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func (c gzipCompression) NewWriter(w io.Writer) CompressWriter {
+	zw, _ := gzip.NewWriterLevel(w, c.level) // the level is checked
+	return zw
+}
+
+type deflateCompression struct {
+	level int
+}
+
+// NewDeflateCompression returns the raw deflate Compression with
+// level, such as flate.BestSpeed.
+func NewDeflateCompression(level int) Compression {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		log2.Panicf("wrong deflate level: %v", level)
+	}
+	return deflateCompression{level: level}
+}
+
+func (c deflateCompression) NewReader(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+func (c deflateCompression) NewWriter(w io.Writer) CompressWriter {
+	zw, _ := flate.NewWriter(w, c.level) // the level is checked
+	return zw
+}
+
+// compressedConn compresses everything written to it, and
+// decompresses everything read from it. Each Write is flushed, so
+// that a message is never stuck in the compressor's buffer.
+type compressedConn struct {
+	net.Conn
+	algo Compression
+	r    io.Reader // created by the first Read
+	wmu  sync.Mutex
+	w    CompressWriter
+}
+
+func newCompressedConn(c net.Conn, algo Compression) *compressedConn {
+	return &compressedConn{Conn: c, algo: algo, w: algo.NewWriter(c)}
+}
+
+func (c *compressedConn) Read(b []byte) (n int, err error) {
+	if c.r == nil {
+		if c.r, err = c.algo.NewReader(c.Conn); err != nil {
+			return
+		}
+	}
+	return c.r.Read(b)
+}
+
+func (c *compressedConn) Write(b []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if n, err = c.w.Write(b); err == nil {
+		err = c.w.Flush()
+	}
+	return
+}
+
+// Close ends the compressed stream and closes the connection.
+func (c *compressedConn) Close() error {
+	c.wmu.Lock()
+	_ = c.w.Close()
+	c.wmu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// countingConn counts the bytes written to the wire.
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return
+}
+
+func TestCompressedConn(t *testing.T) {
+	payload := bytes.Repeat([]byte("compressible "), 8<<10)
+	for name, algo := range map[string]Compression{"gzip": CompressionGzip, "deflate": CompressionDeflate} {
+		t.Run(name, func(t *testing.T) {
+			a, b := net.Pipe()
+			wire := &countingConn{Conn: a}
+			w, r := newCompressedConn(wire, algo), newCompressedConn(b, algo)
+			defer a.Close()
+			defer b.Close()
+
+			go func() { _, _ = w.Write(payload) }()
+			got := make([]byte, len(payload))
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatal("the payload is corrupted")
+			}
+			if n := atomic.LoadInt64(&wire.written); n == 0 || n > int64(len(payload)/10) {
+				t.Fatalf("%v bytes on the wire for %v bytes", n, len(payload))
+			}
+		})
+	}
+}
+
+func TestCompressionCall(t *testing.T) {
+	s := startTestServer(t, WithServerCodec(NewLengthPrefixedCodec(4, 0)), WithServerCompression(CompressionGzip),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			return out.WriteMessage(msg)
+		}))
+	c := dialCalls(t, s, WithClientCompression(CompressionGzip))
+
+	// each message must be flushed through the compressor at once
+	for _, req := range [][]byte{[]byte("short"), bytes.Repeat([]byte("long "), 4<<10)} {
+		resp, err := c.Call(context.Background(), req)
+		if err != nil || !bytes.Equal(resp, req) {
+			t.Fatalf("Call of %v bytes: %v bytes, %v", len(req), len(resp), err)
+		}
+	}
+}
@@ -1,4 +1,5 @@
-// +build windows,appengine
+//go:build windows || appengine
+// +build windows appengine
 
 /*
  * Copyright © 2020 Hedzr Yeh.
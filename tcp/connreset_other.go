@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"syscall"
+)
+
+// resetErrnos are the errors of the OS meaning the connection was
+// reset by the peer.
+var resetErrnos = []error{syscall.ECONNRESET}
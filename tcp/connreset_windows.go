@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"syscall"
+)
+
+// resetErrnos are the errors of the OS meaning the connection was
+// reset by the peer, Winsock reports WSAECONNRESET rather than the
+// ECONNRESET of the C runtime.
+var resetErrnos = []error{syscall.WSAECONNRESET, syscall.ECONNRESET}
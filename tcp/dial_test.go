@@ -0,0 +1,64 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// closedPort returns a loopback address nobody listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+func TestDialClosedPort(t *testing.T) {
+	c, err := Dial(closedPort(t), WithClientDialTimeout(time.Second))
+	if c != nil {
+		t.Fatal("a client returned for the failure")
+	}
+	if !errors.Is(err, ErrDial) {
+		t.Fatalf("want ErrDial, got %v", err)
+	}
+	var de *DialError
+	if !errors.As(err, &de) || de.Err == nil {
+		t.Fatalf("want *DialError with the cause, got %#v", err)
+	}
+}
+
+func TestDialMalformedAddr(t *testing.T) {
+	if _, err := Dial("localhost"); !errors.Is(err, ErrDial) {
+		t.Fatalf("want ErrDial, got %v", err)
+	}
+}
+
+func TestDialAutoReconnect(t *testing.T) {
+	c, err := Dial(closedPort(t), WithClientAutoReconnect(true), WithClientReconnectBackoff(time.Hour, time.Hour, 2))
+	if err != nil || c == nil {
+		t.Fatalf("want the reconnecting client, got %v", err)
+	}
+	c.Close()
+}
+
+func TestDial(t *testing.T) {
+	s := startTestServer(t, echoLines()...)
+	c, err := Dial(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.IsClosed() {
+		t.Fatal("the client is closed")
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+)
+
+// The kinds of the failures of the TCP layer, test them with
+// errors.Is. The errors returned are *OpError (or *DialError for
+// ErrDial) which also unwrap to the underlying cause, so that
+// errors.Is(err, syscall.EADDRINUSE) and errors.As(err, &netErr)
+// work as well.
+var (
+	// ErrListen means the server couldn't listen, such as the port
+	// is in use.
+	ErrListen = errors.New("listen failed")
+	// ErrAccept means accepting a connection failed.
+	ErrAccept = errors.New("accept failed")
+	// ErrHandshake means the TLS, PROXY protocol or WebSocket
+	// handshake of a connection failed.
+	ErrHandshake = errors.New("handshake failed")
+	// ErrDial means the client couldn't connect to the server.
+	ErrDial = errors.New("dial failed")
+	// ErrConnClosed means the connection has been closed.
+	ErrConnClosed = errors.New("connection closed")
+	// ErrConnReset means the connection was reset by the peer (a TCP
+	// RST), such as the peer process crashed, as opposed to the
+	// graceful close which is read as io.EOF. See IsConnReset.
+	ErrConnReset = errors.New("connection reset by peer")
+)
+
+// OpError is the failure of an operation, Op is one of ErrListen,
+// ErrAccept, ErrHandshake, ErrConnClosed and ErrConnReset.
+type OpError struct {
+	Op   error
+	Addr string // the listening address, or the peer's
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	s := e.Op.Error()
+	if e.Addr != "" {
+		s += " (addr=" + e.Addr + ")"
+	}
+	return s + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *OpError) Unwrap() error { return e.Err }
+
+// Is reports whether target is e.Op.
+func (e *OpError) Is(target error) bool { return target == e.Op }
+
+// IsConnReset reports whether err means the connection was reset by
+// the peer, either the ErrConnReset reported by the server (to
+// WithServerOnDisconnect) or the raw error of the OS from reading or
+// writing a connection, on any platform.
+func IsConnReset(err error) bool {
+	if errors.Is(err, ErrConnReset) {
+		return true
+	}
+	for _, errno := range resetErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyReset wraps err into an *OpError of ErrConnReset if it's a
+// reset of the connection to addr, or returns it as is.
+func classifyReset(err error, addr net.Addr) error {
+	if err == nil || errors.Is(err, ErrConnReset) || !IsConnReset(err) {
+		return err
+	}
+	return &OpError{Op: ErrConnReset, Addr: addr.String(), Err: err}
+}
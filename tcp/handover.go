@@ -0,0 +1,64 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// ErrHandoverUnsupported is returned by the listener handover
+// functions on the platforms other than Linux and BSDs.
+var ErrHandoverUnsupported = errors.New("listener handover is not supported on this platform")
+
+// the environment variables of the systemd socket activation, which
+// are reused to pass the listener to the child process.
+const (
+	listenFDsEnv = "LISTEN_FDS"
+	listenPIDEnv = "LISTEN_PID"
+)
+
+// ListenWithInheritedFD returns the listener inherited from the
+// parent process (see Server.Handover, or the socket activation of
+// systemd) if LISTEN_FDS is set, or listens on addr otherwise.
+// Only the first inherited listener is used.
+func ListenWithInheritedFD(addr string) (l net.Listener, err error) {
+	if l, err = inheritedListener(); l == nil && err == nil {
+		l, err = net.Listen("tcp", addr)
+	}
+	return
+}
+
+// PrepareHandover returns a duplicate of the listening socket (the
+// one on the addr of NewServer), to be passed to another process.
+// The caller should close it once passed.
+func (s *Server) PrepareHandover() (f *os.File, err error) {
+	if len(s.ls) == 0 || !handoverSupported {
+		return nil, ErrHandoverUnsupported
+	}
+	fl, ok := s.ls[0].raw.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, ErrHandoverUnsupported
+	}
+	return fl.File()
+}
+
+// Handover starts the program again, with the same arguments, and
+// passes the listening socket to it through LISTEN_FDS, so that the
+// connections are never refused during a restart. The new process
+// should start its server with WithServerListenInheritedFD.
+//
+// Both processes accept the new connections until this one is
+// stopped, so call Stop() afterwards to drain the active connections
+// and exit.
+func (s *Server) Handover() (p *os.Process, err error) {
+	var f *os.File
+	if f, err = s.PrepareHandover(); err != nil {
+		return
+	}
+	defer f.Close()
+	return execWithListener(f)
+}
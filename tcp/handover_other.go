@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"net"
+	"os"
+)
+
+const handoverSupported = false
+
+func inheritedListener() (l net.Listener, err error) {
+	return
+}
+
+func execWithListener(f *os.File) (p *os.Process, err error) {
+	return nil, ErrHandoverUnsupported
+}
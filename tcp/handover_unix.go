@@ -0,0 +1,62 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const handoverSupported = true
+
+const listenFDsStart = 3 // the first passed fd, as SD_LISTEN_FDS_START
+
+// inheritedListener returns the listener passed by LISTEN_FDS, or
+// nil if there isn't.
+func inheritedListener() (l net.Listener, err error) {
+	if n, _ := strconv.Atoi(os.Getenv(listenFDsEnv)); n < 1 {
+		return
+	}
+	if pid := os.Getenv(listenPIDEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return // passed to another process
+	}
+	// the children of this process shouldn't take it again
+	_ = os.Unsetenv(listenFDsEnv)
+	_ = os.Unsetenv(listenPIDEnv)
+
+	f := os.NewFile(listenFDsStart, "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// execWithListener starts the executable of this process with the
+// same arguments, passing f as the fd 3.
+func execWithListener(f *os.File) (p *os.Process, err error) {
+	var path string
+	if path, err = os.Executable(); err != nil {
+		return
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenFDsEnv+"=") && !strings.HasPrefix(kv, listenPIDEnv+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, listenFDsEnv+"=1")
+
+	if err = cmd.Start(); err == nil {
+		p = cmd.Process
+	}
+	return
+}
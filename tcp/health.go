@@ -0,0 +1,95 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+)
+
+// healthProbeTimeout bounds a probe connection, so that a stuck
+// prober can't hold it.
+const healthProbeTimeout = 5 * time.Second
+
+// healthProbe is the dedicated listener of WithServerHealthProbe.
+type healthProbe struct {
+	addr     string
+	request  []byte
+	response []byte
+	ln       net.Listener
+}
+
+// Ready reports whether the server is accepting the connections,
+// false before Start and since Close or Stop, including the graceful
+// shutdown draining the active connections. It's what the health
+// probe answers, see WithServerHealthProbe.
+func (s *Server) Ready() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return len(s.ls) > 0 && !s.exitingFlag
+}
+
+// startHealthProbe listens on the address of WithServerHealthProbe,
+// replacing the listener left by the last run.
+func (s *Server) startHealthProbe() (err error) {
+	h := s.health
+	if h.ln != nil {
+		_ = h.ln.Close()
+	}
+	t, a := s.transportFor(h.addr)
+	if t == nil {
+		t = &TCPTransport{Network: s.network}
+	}
+	if h.ln, err = t.Listen(a); err != nil {
+		err = &OpError{Op: ErrListen, Addr: h.addr, Err: err}
+		s.Errorf("error listening for the health probe: %v", err)
+		return
+	}
+	s.Debugf("health probe listening on %v", h.addr)
+	go s.serveHealthProbe(h.ln)
+	return
+}
+
+// stopHealthProbe closes the probe listener once the active
+// connections finished, so it keeps answering "not ready" while the
+// server is draining.
+func (s *Server) stopHealthProbe() {
+	if s.health == nil || s.health.ln == nil {
+		return
+	}
+	go func(ln net.Listener) {
+		s.wg.Wait()
+		_ = ln.Close()
+	}(s.health.ln)
+}
+
+func (s *Server) serveHealthProbe(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return // closed
+		}
+		go s.answerHealthProbe(c)
+	}
+}
+
+// answerHealthProbe writes the response if the request matched and
+// the server is ready, or closes c without a word.
+func (s *Server) answerHealthProbe(c net.Conn) {
+	defer c.Close()
+	h := s.health
+	_ = c.SetDeadline(time.Now().Add(healthProbeTimeout))
+	if len(h.request) > 0 {
+		buf := make([]byte, len(h.request))
+		if _, err := io.ReadFull(c, buf); err != nil || !bytes.Equal(buf, h.request) {
+			return
+		}
+	}
+	if s.Ready() {
+		_, _ = c.Write(h.response)
+	}
+}
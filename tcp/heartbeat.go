@@ -0,0 +1,71 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat tracks the liveness of a peer, see WithServerHeartbeat
+// and WithClientHeartbeat.
+type heartbeat struct {
+	interval   time.Duration
+	lastSeen   int64 // unix nano of the last inbound frame
+	pingSentAt int64 // unix nano, or 0 if no ping outstanding
+}
+
+func newHeartbeat(interval time.Duration) *heartbeat {
+	return &heartbeat{interval: interval, lastSeen: time.Now().UnixNano()}
+}
+
+// seen records an inbound frame, any of which proves the peer alive.
+func (h *heartbeat) seen() {
+	atomic.StoreInt64(&h.lastSeen, time.Now().UnixNano())
+	atomic.StoreInt64(&h.pingSentAt, 0)
+}
+
+// tick reports whether a ping should be sent since the peer is
+// silent for interval, or the peer is dead since the ping isn't
+// answered for another interval.
+func (h *heartbeat) tick() (ping, dead bool) {
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&h.lastSeen) < int64(h.interval) {
+		return
+	}
+	if sent := atomic.LoadInt64(&h.pingSentAt); sent != 0 {
+		dead = now-sent >= int64(h.interval)
+		return
+	}
+	atomic.StoreInt64(&h.pingSentAt, now)
+	ping = true
+	return
+}
+
+// run calls tick periodically until done, sendPing is called to
+// send a ping and onDead once the peer is dead.
+func (h *heartbeat) run(done <-chan struct{}, sendPing func() error, onDead func()) {
+	ticker := time.NewTicker(h.interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ping, dead := h.tick()
+			if dead {
+				onDead()
+				return
+			}
+			if ping {
+				if err := sendPing(); err != nil {
+					onDead()
+					return
+				}
+			}
+		}
+	}
+}
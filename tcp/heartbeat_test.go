@@ -0,0 +1,78 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestClientHeartbeatSilentPeer connects to a peer which accepts and
+// never says anything, the client closes the connection after the
+// ping unanswered and reconnects.
+func TestClientHeartbeatSilentPeer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			defer c.Close() // silent, and never reads
+		}
+	}()
+
+	var reconnects, disconnects int32
+	const interval = 100 * time.Millisecond
+	c, err := Dial(ln.Addr().String(),
+		WithClientCodec(NewLineCodec(0)),
+		WithClientHeartbeat(interval, []byte("PING"), []byte("PONG")),
+		WithClientAutoReconnect(true),
+		WithClientReconnectBackoff(10*time.Millisecond, 10*time.Millisecond, 1),
+		WithClientOnDisconnectedFunc(func(c *Client) { atomic.AddInt32(&disconnects, 1) }),
+		WithClientOnReconnectFunc(func(c *Client, attempt int) { atomic.AddInt32(&reconnects, 1) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	t0 := time.Now()
+	if !waitFor(2*time.Second, func() bool { return atomic.LoadInt32(&accepted) >= 2 }) {
+		t.Fatalf("the silent peer wasn't detected: %v disconnects, %v reconnects", atomic.LoadInt32(&disconnects), atomic.LoadInt32(&reconnects))
+	}
+	if d := time.Since(t0); d < interval {
+		t.Fatalf("reconnected before the heartbeat elapsed: %v", d)
+	}
+	if atomic.LoadInt32(&reconnects) == 0 {
+		t.Fatal("OnReconnect not called")
+	}
+}
+
+// TestClientHeartbeatAnswered keeps the connection to a server
+// answering the pings beyond the heartbeat window.
+func TestClientHeartbeatAnswered(t *testing.T) {
+	s := startTestServer(t, append(echoLines(), WithServerHeartbeat(time.Hour, []byte("PING"), []byte("PONG")))...)
+	var disconnects int32
+	c, err := Dial(s.Addr().String(),
+		WithClientCodec(NewLineCodec(0)),
+		WithClientHeartbeat(50*time.Millisecond, []byte("PING"), []byte("PONG")),
+		WithClientOnDisconnectedFunc(func(c *Client) { atomic.AddInt32(&disconnects, 1) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	time.Sleep(500 * time.Millisecond)
+	if n := atomic.LoadInt32(&disconnects); n != 0 || c.IsClosed() {
+		t.Fatalf("the connection answering pings was closed, %v disconnects", n)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestServer starts a server on a loopback ephemeral port, it's
+// stopped at the end of the test.
+func startTestServer(t *testing.T, opts ...ServerOpt) *Server {
+	t.Helper()
+	s, err := NewServer("127.0.0.1:0", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.StopWithTimeout(0) })
+	return s
+}
+
+// dialTestServer connects to s, the connection is closed at the end
+// of the test.
+func dialTestServer(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	c, err := net.DialTimeout("tcp", s.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// echoLines is the options of a server echoing each line back.
+func echoLines() []ServerOpt {
+	return []ServerOpt{
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			return out.WriteMessage(msg)
+		}),
+	}
+}
+
+// lineConn reads and writes the lines of a test connection.
+type lineConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func newLineConn(c net.Conn) *lineConn {
+	return &lineConn{Conn: c, br: bufio.NewReader(c)}
+}
+
+func (c *lineConn) send(t *testing.T, line string) {
+	t.Helper()
+	if _, err := c.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recv reads a line within d.
+func (c *lineConn) recv(d time.Duration) (line string, err error) {
+	_ = c.SetReadDeadline(time.Now().Add(d))
+	var msg []byte
+	if msg, err = NewLineCodec(0).Decode(c.br); err == nil {
+		line = string(msg)
+	}
+	return
+}
+
+// waitFor polls cond until it's true or d elapsed.
+func waitFor(d time.Duration, cond func() bool) bool {
+	for deadline := time.Now().Add(d); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+// Package kcp provides the tcp.Transport over KCP, the reliable
+// protocol on UDP, which has lower latency than TCP on the lossy
+// links at the cost of more bandwidth. It's a separate package so
+// that the users of TCP only don't link kcp-go in.
+//
+//	s, err := tcp.StartServer(":7000", tcp.WithServerTransport(kcp.NewTransport()))
+//	c := tcp.NewClient("server:7000", tcp.WithClientTransport(kcp.NewTransport()))
+//
+// KCP has no closing handshake, so the server can't tell a client
+// gone away from an idle one, the connections should be reaped with
+// tcp.WithServerIdleTimeout or tcp.WithServerHeartbeat.
+package kcp
+
+import (
+	kcpgo "github.com/xtaci/kcp-go/v5"
+	"net"
+)
+
+// Transport implements tcp.Transport. The settings must be the same
+// on both sides, except the window sizes.
+type Transport struct {
+	// Block encrypts the packets, such as the one returned by
+	// kcpgo.NewAESBlockCrypt, nil for no encryption.
+	Block kcpgo.BlockCrypt
+	// DataShards and ParityShards configure the forward error
+	// correction, 0 to disable it.
+	DataShards, ParityShards int
+	// NoDelay, Interval (ms), Resend and NoCongestion are the
+	// parameters of kcpgo.UDPSession.SetNoDelay.
+	NoDelay, Interval, Resend, NoCongestion int
+	// SendWindow and RecvWindow are the window sizes in packets.
+	SendWindow, RecvWindow int
+}
+
+// NewTransport returns a Transport in the fast mode (no delay, 10ms
+// interval, fast resend, no congestion control), without encryption
+// and FEC.
+func NewTransport() *Transport {
+	return &Transport{
+		NoDelay:      1,
+		Interval:     10,
+		Resend:       2,
+		NoCongestion: 1,
+		SendWindow:   128,
+		RecvWindow:   512,
+	}
+}
+
+// Listen announces on the UDP address addr.
+func (t *Transport) Listen(addr string) (l net.Listener, err error) {
+	var kl *kcpgo.Listener
+	if kl, err = kcpgo.ListenWithOptions(addr, t.Block, t.DataShards, t.ParityShards); err != nil {
+		return
+	}
+	return &listener{Listener: kl, t: t}, nil
+}
+
+// Dial connects to the UDP address addr. It doesn't wait for the
+// server since there's no handshake in KCP.
+func (t *Transport) Dial(addr string) (c net.Conn, err error) {
+	var sess *kcpgo.UDPSession
+	if sess, err = kcpgo.DialWithOptions(addr, t.Block, t.DataShards, t.ParityShards); err != nil {
+		return
+	}
+	t.tune(sess)
+	return sess, nil
+}
+
+func (t *Transport) tune(sess *kcpgo.UDPSession) {
+	sess.SetStreamMode(true) // a byte stream as TCP, for the codecs
+	sess.SetNoDelay(t.NoDelay, t.Interval, t.Resend, t.NoCongestion)
+	if t.SendWindow > 0 || t.RecvWindow > 0 {
+		sess.SetWindowSize(t.SendWindow, t.RecvWindow)
+	}
+}
+
+// listener tunes the accepted sessions.
+type listener struct {
+	*kcpgo.Listener
+	t *Transport
+}
+
+func (l *listener) Accept() (c net.Conn, err error) {
+	var sess *kcpgo.UDPSession
+	if sess, err = l.AcceptKCP(); err != nil {
+		return
+	}
+	l.t.tune(sess)
+	return sess, nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrMemoryBudget is returned by Conn.Send if the memory budget of
+// WithServerMemoryBudget is exhausted, and the reason of closing a
+// connection which can never fit in it.
+var ErrMemoryBudget = errors.New("memory budget exhausted")
+
+// memBudget is the weighted semaphore of WithServerMemoryBudget,
+// shared by all the connections of a server. The methods of a nil
+// memBudget do nothing, for the server without a budget.
+type memBudget struct {
+	used  int64 // 64-bit aligned for atomic
+	waits uint64
+	size  int64
+	mu    sync.Mutex
+	freed chan struct{} // closed and renewed on each release
+}
+
+func newMemBudget(size int64) *memBudget {
+	return &memBudget{size: size, freed: make(chan struct{})}
+}
+
+// acquire takes n bytes from the budget, waits until enough bytes
+// released or ctx done. ErrMemoryBudget returned at once if n
+// exceeds the whole budget.
+func (b *memBudget) acquire(ctx context.Context, n int) (err error) {
+	if b == nil {
+		return
+	}
+	if int64(n) > b.size {
+		return ErrMemoryBudget
+	}
+	for waited := false; ; waited = true {
+		b.mu.Lock()
+		if b.used+int64(n) <= b.size {
+			atomic.AddInt64(&b.used, int64(n))
+			b.mu.Unlock()
+			return
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		if !waited {
+			atomic.AddUint64(&b.waits, 1)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// tryAcquire takes n bytes from the budget without waiting,
+// ErrMemoryBudget returned if they don't fit.
+func (b *memBudget) tryAcquire(n int) (err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+int64(n) > b.size {
+		return ErrMemoryBudget
+	}
+	atomic.AddInt64(&b.used, int64(n))
+	return
+}
+
+// release gives n bytes back, and wakes up the waiters.
+func (b *memBudget) release(n int) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	atomic.AddInt64(&b.used, -int64(n))
+	close(b.freed)
+	b.freed = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// stats returns the usage, zeros for a nil memBudget.
+func (b *memBudget) stats() (size, used int64, waits uint64) {
+	if b == nil {
+		return
+	}
+	return b.size, atomic.LoadInt64(&b.used), atomic.LoadUint64(&b.waits)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memoryUsed waits until the budget in use is n bytes.
+func memoryUsed(t *testing.T, s *Server, n int64) {
+	t.Helper()
+	if !waitFor(time.Second, func() bool { return s.Stats().MemoryUsed == n }) {
+		t.Fatalf("memory used: %v, want %v", s.Stats().MemoryUsed, n)
+	}
+}
+
+func TestMemoryBudgetSend(t *testing.T) {
+	results := make(chan error, 1)
+	s := startTestServer(t, WithServerBufferSize(64), WithServerMemoryBudget(192),
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			results <- ConnFromContext(ctx).Send(bytes.Repeat([]byte("x"), 100))
+			return nil
+		}))
+	a := newLineConn(dialTestServer(t, s))
+	b := dialTestServer(t, s)
+	memoryUsed(t, s, 128)
+
+	a.send(t, "send")
+	if err := <-results; !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("Send beyond the budget: %v, want ErrMemoryBudget", err)
+	}
+
+	_ = b.Close()
+	memoryUsed(t, s, 64)
+	a.send(t, "send")
+	if err := <-results; err != nil {
+		t.Fatalf("Send after the budget released: %v", err)
+	}
+	if line, err := a.recv(time.Second); err != nil || len(line) != 100 {
+		t.Fatalf("the message sent: %q, %v", line, err)
+	}
+	memoryUsed(t, s, 64)
+}
+
+func TestMemoryBudgetWaits(t *testing.T) {
+	s := startTestServer(t, append(echoLines(), WithServerBufferSize(64), WithServerMemoryBudget(128))...)
+	a := dialTestServer(t, s)
+	_ = dialTestServer(t, s)
+	memoryUsed(t, s, 128)
+
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "hi")
+	if line, err := c.recv(100 * time.Millisecond); err == nil {
+		t.Fatalf("served beyond the budget: %q", line)
+	}
+	if waits := s.Stats().MemoryWaits; waits != 1 {
+		t.Fatalf("MemoryWaits: %v, want 1", waits)
+	}
+
+	_ = a.Close()
+	if line, err := c.recv(time.Second); err != nil || line != "hi" {
+		t.Fatalf("after the budget released: %q, %v", line, err)
+	}
+	memoryUsed(t, s, 128)
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+// Package metrics exports the counters of tcp.Server to Prometheus.
+//
+// It's a standalone package so that the core tcp doesn't pull in
+// the Prometheus client.
+package metrics
+
+import (
+	"github.com/hedzr/go-socketlib/tcp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements prometheus.Collector for a named server.
+type Collector struct {
+	s                 *tcp.Server
+	accepted          *prometheus.Desc
+	active            *prometheus.Desc
+	acceptErrors      *prometheus.Desc
+	bytesRead         *prometheus.Desc
+	bytesWritten      *prometheus.Desc
+	panicsRecovered   *prometheus.Desc
+	frameTimeouts     *prometheus.Desc
+	handshakeTimeouts *prometheus.Desc
+}
+
+// NewCollector returns a Collector for s. The metrics are labeled
+// with name so that multiple servers can be tracked by one registry.
+func NewCollector(s *tcp.Server, name string) *Collector {
+	labels := prometheus.Labels{"name": name}
+	return &Collector{
+		s: s,
+		accepted: prometheus.NewDesc("tcp_server_accepted_total",
+			"The connections accepted by the server.", nil, labels),
+		active: prometheus.NewDesc("tcp_server_active_connections",
+			"The connections being served.", nil, labels),
+		acceptErrors: prometheus.NewDesc("tcp_server_accept_errors_total",
+			"The failures of accepting the connections.", nil, labels),
+		bytesRead: prometheus.NewDesc("tcp_server_read_bytes_total",
+			"The bytes read from the connections.", nil, labels),
+		bytesWritten: prometheus.NewDesc("tcp_server_written_bytes_total",
+			"The bytes written to the connections.", nil, labels),
+		panicsRecovered: prometheus.NewDesc("tcp_server_panics_recovered_total",
+			"The panics of the handlers recovered.", nil, labels),
+		frameTimeouts: prometheus.NewDesc("tcp_server_frame_timeouts_total",
+			"The connections closed for not completing a message in time.", nil, labels),
+		handshakeTimeouts: prometheus.NewDesc("tcp_server_tls_handshake_timeouts_total",
+			"The connections closed for not completing the TLS handshake in time.", nil, labels),
+	}
+}
+
+// RegisterMetrics creates a Collector for s and registers it into reg.
+func RegisterMetrics(reg prometheus.Registerer, name string, s *tcp.Server) (c *Collector, err error) {
+	c = NewCollector(s, name)
+	if err = reg.Register(c); err != nil {
+		c = nil
+	}
+	return
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.accepted
+	ch <- c.active
+	ch <- c.acceptErrors
+	ch <- c.bytesRead
+	ch <- c.bytesWritten
+	ch <- c.panicsRecovered
+	ch <- c.frameTimeouts
+	ch <- c.handshakeTimeouts
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	st := c.s.Stats()
+	ch <- prometheus.MustNewConstMetric(c.accepted, prometheus.CounterValue, float64(st.Accepted))
+	ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(st.Active))
+	ch <- prometheus.MustNewConstMetric(c.acceptErrors, prometheus.CounterValue, float64(st.AcceptErrors))
+	ch <- prometheus.MustNewConstMetric(c.bytesRead, prometheus.CounterValue, float64(st.BytesRead))
+	ch <- prometheus.MustNewConstMetric(c.bytesWritten, prometheus.CounterValue, float64(st.BytesWritten))
+	ch <- prometheus.MustNewConstMetric(c.panicsRecovered, prometheus.CounterValue, float64(st.PanicsRecovered))
+	ch <- prometheus.MustNewConstMetric(c.frameTimeouts, prometheus.CounterValue, float64(st.FrameTimeouts))
+	ch <- prometheus.MustNewConstMetric(c.handshakeTimeouts, prometheus.CounterValue, float64(st.HandshakeTimeouts))
+}
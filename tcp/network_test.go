@@ -0,0 +1,91 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startServerOn starts an echo server on addr, the test is skipped
+// if the system can't listen on it.
+func startServerOn(t *testing.T, addr string, opts ...ServerOpt) *Server {
+	t.Helper()
+	s, err := NewServer(addr, append(echoLines(), opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(); err != nil {
+		t.Skipf("can't listen on %v: %v", addr, err)
+	}
+	t.Cleanup(func() { s.StopWithTimeout(0) })
+	return s
+}
+
+// echoes reports whether the server at host:port echoes a line.
+func echoes(t *testing.T, host string, port int) bool {
+	t.Helper()
+	nc, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return false
+	}
+	defer nc.Close()
+	c := newLineConn(nc)
+	c.send(t, "hi")
+	line, err := c.recv(time.Second)
+	return err == nil && line == "hi"
+}
+
+func TestListenIPv6(t *testing.T) {
+	s := startServerOn(t, "[::1]:0")
+	a := s.Addr().(*net.TCPAddr)
+	if a.IP.To4() != nil {
+		t.Fatalf("Addr: %v, want an IPv6 address", a)
+	}
+	if !echoes(t, "::1", a.Port) {
+		t.Fatal("not served over IPv6")
+	}
+}
+
+func TestListenTCP4(t *testing.T) {
+	s := startServerOn(t, ":0", WithServerNetwork("tcp4"))
+	a := s.Addr().(*net.TCPAddr)
+	if a.IP.To4() == nil {
+		t.Fatalf("Addr: %v, want an IPv4 address", a)
+	}
+	if !echoes(t, "127.0.0.1", a.Port) {
+		t.Fatal("not served over IPv4")
+	}
+	if echoes(t, "::1", a.Port) {
+		t.Fatal("served over IPv6 with tcp4")
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	s := startServerOn(t, ":0")
+	port := s.Addr().(*net.TCPAddr).Port
+	if !echoes(t, "127.0.0.1", port) {
+		t.Fatal("not served over IPv4")
+	}
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("no IPv6: %v", err)
+	} else {
+		_ = ln.Close()
+	}
+	if !echoes(t, "::1", port) {
+		t.Fatal("not served over IPv6")
+	}
+}
+
+func TestWithServerNetworkWrong(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic on a wrong network")
+		}
+	}()
+	_, _ = NewServer(":0", WithServerNetwork("udp"))
+}
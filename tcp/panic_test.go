@@ -0,0 +1,57 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHandlerPanic(t *testing.T) {
+	panicked, disconnected := make(chan string, 1), make(chan error, 2)
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			if string(msg) == "panic" {
+				panic("boom")
+			}
+			return out.WriteMessage(msg)
+		}),
+		WithServerPanicHandler(func(connID string, r interface{}) {
+			panicked <- connID
+		}),
+		WithServerOnDisconnect(func(info ConnInfo, err error) {
+			disconnected <- err
+		}))
+
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "panic")
+	if !closedWithin(c, time.Second) {
+		t.Fatal("the connection of the panicked handler isn't closed")
+	}
+	select {
+	case id := <-panicked:
+		if id == "" {
+			t.Fatal("the panic handler got no connection ID")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the panic handler isn't called")
+	}
+	var pe *PanicError
+	if err := <-disconnected; !errors.As(err, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Fatalf("OnDisconnect got %v, want a *PanicError of \"boom\"", err)
+	}
+	if n := s.Stats().PanicsRecovered; n != 1 {
+		t.Fatalf("PanicsRecovered: %v, want 1", n)
+	}
+
+	// the server keeps serving
+	c = newLineConn(dialTestServer(t, s))
+	c.send(t, "hi")
+	if line, err := c.recv(time.Second); err != nil || line != "hi" {
+		t.Fatalf("after a panic: %q, %v", line, err)
+	}
+}
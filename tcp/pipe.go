@@ -0,0 +1,126 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// PipeTransport carries the connections in memory through net.Pipe,
+// no socket opened, so that the handlers of Server and the logic of
+// Client can be tested fast and hermetically. The server and the
+// clients must share one PipeTransport:
+//
+//	pt := tcp.NewPipeTransport()
+//	s, _ := tcp.NewServer("echo", tcp.WithServerTransport(pt),
+//	    tcp.WithServerCodec(tcp.NewLineCodec(0)),
+//	    tcp.WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out tcp.MessageWriter) error {
+//	        return out.WriteMessage(msg)
+//	    }))
+//	_ = s.Start()
+//	defer s.Close()
+//
+//	c, _ := pt.Dial("echo")
+//	_, _ = c.Write([]byte("hi\n"))
+//	reply, _ := tcp.NewLineCodec(0).Decode(bufio.NewReader(c)) // "hi"
+//
+// The addresses are arbitrary names. Since net.Pipe is synchronous,
+// a write blocks until the other end reads it.
+type PipeTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*pipeListener
+	nextPort  uint64
+}
+
+// NewPipeTransport returns an empty PipeTransport.
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{listeners: make(map[string]*pipeListener)}
+}
+
+// Listen announces on the name addr, which must not be in use.
+func (t *PipeTransport) Listen(addr string) (net.Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.listeners[addr]; ok {
+		return nil, &net.OpError{Op: "listen", Net: pipeNetwork, Addr: pipeAddr(addr), Err: syscall.EADDRINUSE}
+	}
+	ln := &pipeListener{t: t, addr: pipeAddr(addr), conns: make(chan net.Conn), done: make(chan struct{})}
+	t.listeners[addr] = ln
+	return ln, nil
+}
+
+// Dial connects to the listener on the name addr, and returns the
+// client end of the pipe once the server end accepted.
+func (t *PipeTransport) Dial(addr string) (net.Conn, error) {
+	t.mu.Lock()
+	ln := t.listeners[addr]
+	t.mu.Unlock()
+	if ln == nil {
+		return nil, &net.OpError{Op: "dial", Net: pipeNetwork, Addr: pipeAddr(addr), Err: syscall.ECONNREFUSED}
+	}
+
+	local := pipeAddr("pipe-client-" + strconv.FormatUint(atomic.AddUint64(&t.nextPort, 1), 10))
+	cc, sc := net.Pipe()
+	select {
+	case ln.conns <- &pipeConn{Conn: sc, local: ln.addr, remote: local}:
+		return &pipeConn{Conn: cc, local: local, remote: ln.addr}, nil
+	case <-ln.done:
+		_, _ = cc.Close(), sc.Close()
+		return nil, &net.OpError{Op: "dial", Net: pipeNetwork, Addr: pipeAddr(addr), Err: syscall.ECONNREFUSED}
+	}
+}
+
+const pipeNetwork = "pipe"
+
+// pipeAddr is the name of an end of PipeTransport.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return pipeNetwork }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn names the ends of net.Pipe, which are both "pipe".
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+type pipeListener struct {
+	t         *PipeTransport
+	addr      pipeAddr
+	conns     chan net.Conn // the server ends handed over by Dial
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (ln *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case <-ln.done:
+		return nil, &net.OpError{Op: "accept", Net: pipeNetwork, Addr: ln.addr, Err: net.ErrClosed}
+	}
+}
+
+// Close stops accepting, and frees the name for Listen.
+func (ln *pipeListener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.done)
+		ln.t.mu.Lock()
+		delete(ln.t.listeners, string(ln.addr))
+		ln.t.mu.Unlock()
+	})
+	return nil
+}
+
+func (ln *pipeListener) Addr() net.Addr {
+	return ln.addr
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func ExamplePipeTransport() {
+	pt := NewPipeTransport()
+	s, _ := NewServer("echo", WithServerTransport(pt),
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			return out.WriteMessage(msg)
+		}))
+	_ = s.Start()
+	defer s.StopWithTimeout(0)
+
+	c, _ := pt.Dial("echo")
+	defer c.Close()
+	_, _ = c.Write([]byte("hi\n"))
+	reply, _ := NewLineCodec(0).Decode(bufio.NewReader(c))
+	fmt.Println(string(reply))
+	// Output: hi
+}
+
+func TestPipeListenerClosed(t *testing.T) {
+	pt := NewPipeTransport()
+	ln, err := pt.Listen("closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ln.Close()
+	if _, err = ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept on a closed listener: %v, want net.ErrClosed", err)
+	}
+	if _, err = pt.Dial("closed"); err == nil {
+		t.Fatal("Dial to a closed listener succeeded")
+	}
+	if ln, err = pt.Listen("closed"); err != nil {
+		t.Fatalf("the name isn't freed by Close: %v", err)
+	}
+	_ = ln.Close()
+}
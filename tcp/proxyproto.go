@@ -0,0 +1,164 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidProxyHeader is the reason of closing a connection which
+// doesn't begin with a valid PROXY protocol header, see
+// WithServerProxyProtocol.
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyV1MaxLength = 107 // including the CRLF, see the spec
+
+// proxyListener wraps each accepted connection into a proxyConn. It
+// sits under the TLS listener since the PROXY header precedes the
+// TLS handshake, so the proxyConn of the connection just accepted is
+// kept in last for runLoop, which is the only caller of Accept.
+type proxyListener struct {
+	net.Listener
+	last *proxyConn
+}
+
+func (l *proxyListener) Accept() (c net.Conn, err error) {
+	if c, err = l.Listener.Accept(); err == nil {
+		l.last = &proxyConn{Conn: c}
+		c = l.last
+	}
+	return
+}
+
+// take returns the proxyConn accepted last time.
+func (l *proxyListener) take() (pc *proxyConn) {
+	pc, l.last = l.last, nil
+	return
+}
+
+// proxyConn reports the addresses in the PROXY header, if any, as
+// its remote (source) and local (destination) addresses.
+type proxyConn struct {
+	net.Conn
+	src, dst net.Addr
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader consumes the PROXY header (v1 or v2) from the
+// connection, without reading anything after it.
+func (c *proxyConn) readHeader() (err error) {
+	var sig [12]byte
+	if _, err = io.ReadFull(c.Conn, sig[:]); err != nil {
+		return
+	}
+	if bytes.Equal(sig[:], proxyV2Signature) {
+		c.src, c.dst, err = readProxyV2(c.Conn)
+	} else if bytes.HasPrefix(sig[:], []byte("PROXY ")) {
+		c.src, c.dst, err = readProxyV1(c.Conn, sig[:])
+	} else {
+		err = ErrInvalidProxyHeader
+	}
+	return
+}
+
+// readProxyV1 parses the text header, such as
+// "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", whose
+// leading bytes have been read into line.
+func readProxyV1(r io.Reader, line []byte) (src, dst net.Addr, err error) {
+	line = append(make([]byte, 0, proxyV1MaxLength), line...)
+	var b [1]byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, nil, ErrInvalidProxyHeader
+		}
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return // the addresses are ignored by the spec
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	var addrs [2]*net.TCPAddr
+	for i := range addrs {
+		ip := net.ParseIP(fields[2+i])
+		port, e := strconv.ParseUint(fields[4+i], 10, 16)
+		if ip == nil || e != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+			return nil, nil, ErrInvalidProxyHeader
+		}
+		addrs[i] = &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+	return addrs[0], addrs[1], nil
+}
+
+// readProxyV2 parses the binary header after the signature.
+func readProxyV2(r io.Reader) (src, dst net.Addr, err error) {
+	var hdr [4]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	verCmd, family := hdr[0], hdr[1]
+	if verCmd>>4 != 2 || verCmd&0xf > 1 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return
+	}
+	if verCmd&0xf == 0 {
+		return // LOCAL, such as the health checks from the proxy
+	}
+
+	var ipLen int
+	switch family {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default: // UNSPEC, UDP and unix sockets carry no TCP addresses
+		return
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	ports := body[2*ipLen:]
+	src = &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), body[:ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(ports)),
+	}
+	dst = &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), body[ipLen:2*ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(ports[2:])),
+	}
+	return
+}
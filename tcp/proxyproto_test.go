@@ -0,0 +1,36 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProxyHeaderTimeout(t *testing.T) {
+	s := startTestServer(t, append(echoLines(),
+		WithServerProxyProtocol(true),
+		WithServerProxyHeaderTimeout(100*time.Millisecond))...)
+
+	c := newLineConn(dialTestServer(t, s))
+	start := time.Now()
+	if !closedWithin(c, time.Second) {
+		t.Fatal("a peer sending no PROXY header isn't closed on the timeout")
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("closed after %v, before the header timeout", d)
+	}
+
+	// the deadline is cleared once the header read
+	c = newLineConn(dialTestServer(t, s))
+	if _, err := c.Write([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	c.send(t, "hi")
+	if line, err := c.recv(time.Second); err != nil || line != "hi" {
+		t.Fatalf("after the header: %q, %v", line, err)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// idOf asks the server for the ID of the connection c.
+func idOf(t *testing.T, c *lineConn) string {
+	t.Helper()
+	c.send(t, "id")
+	id, err := c.recv(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestConnRegistry(t *testing.T) {
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			return out.WriteMessage([]byte(ConnFromContext(ctx).ID()))
+		}))
+	a, b := newLineConn(dialTestServer(t, s)), newLineConn(dialTestServer(t, s))
+	idA, idB := idOf(t, a), idOf(t, b)
+	if idA == "" || idA == idB {
+		t.Fatalf("IDs %q and %q aren't unique", idA, idB)
+	}
+	if again := idOf(t, a); again != idA {
+		t.Fatalf("ID changed from %q to %q", idA, again)
+	}
+
+	if conn := s.Conn(idA); conn == nil || conn.RemoteAddr().String() != a.LocalAddr().String() {
+		t.Fatalf("Conn(%q): %v", idA, conn)
+	}
+	ids := map[string]bool{}
+	for _, info := range s.Conns() {
+		ids[info.ID] = true
+	}
+	if len(ids) != 2 || !ids[idA] || !ids[idB] {
+		t.Fatalf("Conns: %v, want %q and %q", ids, idA, idB)
+	}
+
+	if err := s.CloseConn(idA); err != nil {
+		t.Fatal(err)
+	}
+	if !closedWithin(a, time.Second) {
+		t.Fatal("CloseConn doesn't close the connection")
+	}
+	if !waitFor(time.Second, func() bool { return s.Conn(idA) == nil }) {
+		t.Fatal("the closed connection is still registered")
+	}
+	if len(s.Conns()) != 1 || idOf(t, b) != idB {
+		t.Fatal("the other connection is affected")
+	}
+	if err := s.CloseConn(idA); err != ErrConnNotFound {
+		t.Fatalf("CloseConn of a closed connection: %v, want ErrConnNotFound", err)
+	}
+}
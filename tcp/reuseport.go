@@ -0,0 +1,26 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"syscall"
+)
+
+const reusePortSupported = true
+
+// reusePortControl sets SO_REUSEPORT on the listening socket, see
+// net.ListenConfig.Control.
+func reusePortControl(network, address string, c syscall.RawConn) (err error) {
+	e := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if e != nil {
+		err = e
+	}
+	return
+}
@@ -5,7 +5,7 @@
 package tcp
 
 import (
-	"github.com/hedzr/go-ringbuf/fast"
+	"github.com/hedzr/go-socketlib/ringbuf"
 )
 
 //// NewRingBuffer will allocate, initialize, and return a ring buffer
@@ -21,9 +21,16 @@ import (
 
 // newRingBuf will allocate, initialize, and return a ring buffer
 // with the specified size.
-func newRingBuf(size uint32) fast.RingBuffer {
-	if x := fast.New(size); x != nil {
+func newRingBuf(size uint32, opts ...ringbuf.Opt) ringbuf.RingBuffer {
+	if x := ringbuf.New(size, opts...); x != nil {
 		return x
 	}
 	return nil
 }
+
+// newSendQueue returns the outbound queue of a connection, on which
+// an idle writer blocks until a message is queued, instead of
+// polling.
+func newSendQueue(size uint32) ringbuf.RingBuffer {
+	return newRingBuf(size, ringbuf.WithSignaling(true))
+}
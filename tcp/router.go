@@ -0,0 +1,102 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrUnknownMessageType is returned by NotFound, which closes the
+	// connection sending a message of the type not handled.
+	ErrUnknownMessageType = errors.New("unknown message type")
+	// ErrMalformedMessage means a message is too short to carry the
+	// type of Router.
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// MessageTypeSize is the size of the message type which prefixes the
+// messages routed by Router, in big-endian.
+const MessageTypeSize = 2
+
+// HandlerFunc handles a message routed by Router, payload is the
+// message without the type. out writes the whole messages, such as
+// the replies built by TypedMessage. An error closes the connection.
+type HandlerFunc func(ctx context.Context, msgType uint16, payload []byte, out MessageWriter) error
+
+// NotFound is the default handler of the types not registered, it
+// rejects the message with ErrUnknownMessageType.
+func NotFound(ctx context.Context, msgType uint16, payload []byte, out MessageWriter) error {
+	return ErrUnknownMessageType
+}
+
+// Router dispatches the messages decoded by the codec of
+// WithServerCodec to the handlers by their types:
+//
+//	r := tcp.NewRouter()
+//	r.Handle(1, onLogin)
+//	r.Handle(2, onChat)
+//	tcp.StartServer(addr,
+//	    tcp.WithServerCodec(tcp.NewLengthPrefixedCodec(4, 0)),
+//	    tcp.WithServerOnMessageFunc(r.ServeMessage))
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[uint16]HandlerFunc
+	notFound HandlerFunc
+}
+
+// NewRouter returns an empty Router, which answers every message
+// with NotFound.
+func NewRouter() *Router {
+	return &Router{handlers: make(map[uint16]HandlerFunc), notFound: NotFound}
+}
+
+// Handle registers h for the messages of msgType, replacing the
+// previous one.
+func (r *Router) Handle(msgType uint16, h HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[msgType] = h
+}
+
+// HandleNotFound registers the fallback of the types not handled,
+// such as ignoring them for the forward compatibility. nil restores
+// NotFound.
+func (r *Router) HandleNotFound(h HandlerFunc) {
+	if h == nil {
+		h = NotFound
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notFound = h
+}
+
+// ServeMessage is the OnTcpServerMessageFunc to be passed into
+// WithServerOnMessageFunc.
+func (r *Router) ServeMessage(ctx context.Context, msg []byte, out MessageWriter) error {
+	if len(msg) < MessageTypeSize {
+		return ErrMalformedMessage
+	}
+	msgType := binary.BigEndian.Uint16(msg)
+
+	r.mu.RLock()
+	h, ok := r.handlers[msgType]
+	if !ok {
+		h = r.notFound
+	}
+	r.mu.RUnlock()
+	return h(ctx, msgType, msg[MessageTypeSize:], out)
+}
+
+// TypedMessage returns the message of msgType carrying payload, in
+// the format routed by Router.
+func TypedMessage(msgType uint16, payload []byte) []byte {
+	msg := make([]byte, MessageTypeSize, MessageTypeSize+len(payload))
+	binary.BigEndian.PutUint16(msg, msgType)
+	return append(msg, payload...)
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"testing"
+)
+
+// messages collects the messages written.
+type messages [][]byte
+
+func (m *messages) WriteMessage(msg []byte) error {
+	*m = append(*m, msg)
+	return nil
+}
+
+// replyWith answers each message with reply, of the same type.
+func replyWith(reply string) HandlerFunc {
+	return func(ctx context.Context, msgType uint16, payload []byte, out MessageWriter) error {
+		return out.WriteMessage(TypedMessage(msgType, []byte(reply+":"+string(payload))))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	r.Handle(1, replyWith("login"))
+	r.Handle(2, replyWith("chat"))
+
+	var out messages
+	for _, msg := range [][]byte{TypedMessage(2, []byte("hello")), TypedMessage(1, []byte("me"))} {
+		if err := r.ServeMessage(context.Background(), msg, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"\x00\x02chat:hello", "\x00\x01login:me"}
+	if len(out) != len(want) {
+		t.Fatalf("replies: %q, want %q", out, want)
+	}
+	for i := range want {
+		if string(out[i]) != want[i] {
+			t.Fatalf("replies: %q, want %q", out, want)
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := NewRouter()
+	var out messages
+	if err := r.ServeMessage(context.Background(), TypedMessage(9, nil), &out); err != ErrUnknownMessageType {
+		t.Fatalf("an unknown type: %v, want ErrUnknownMessageType", err)
+	}
+	if err := r.ServeMessage(context.Background(), []byte{1}, &out); err != ErrMalformedMessage {
+		t.Fatalf("a message shorter than the type: %v, want ErrMalformedMessage", err)
+	}
+
+	r.HandleNotFound(replyWith("fallback"))
+	if err := r.ServeMessage(context.Background(), TypedMessage(9, []byte("x")), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || string(out[0]) != "\x00\x09fallback:x" {
+		t.Fatalf("replies of the fallback: %q", out)
+	}
+
+	r.HandleNotFound(nil)
+	if err := r.ServeMessage(context.Background(), TypedMessage(9, nil), &out); err != ErrUnknownMessageType {
+		t.Fatalf("after the fallback removed: %v, want ErrUnknownMessageType", err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hedzr/go-socketlib/ringbuf"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSendSlowConsumer sends far more than the socket buffers hold
+// to a peer not reading, the handler isn't blocked, and every message
+// is delivered in order once the peer reads, although the handler
+// ends the connection right after sending.
+func TestSendSlowConsumer(t *testing.T) {
+	const count = 1000
+	payload := strings.Repeat("x", 4096)
+	sent := make(chan time.Duration, 1)
+	s := startTestServer(t,
+		WithServerSendQueueSize(count),
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			conn := ConnFromContext(ctx)
+			t0 := time.Now()
+			for i := 0; i < count; i++ {
+				if err := conn.Send([]byte(fmt.Sprintf("%d %s", i, payload))); err != nil {
+					return err
+				}
+			}
+			sent <- time.Since(t0)
+			return errors.New("done") // ends the connection
+		}))
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "go")
+
+	select {
+	case d := <-sent:
+		t.Logf("queued %v messages in %v", count, d)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler was blocked by the peer not reading")
+	}
+	time.Sleep(100 * time.Millisecond) // the writer is stuck meanwhile
+
+	for i := 0; i < count; i++ {
+		line, err := c.recv(2 * time.Second)
+		if err != nil {
+			t.Fatalf("message %v lost: %v", i, err)
+		}
+		if want := fmt.Sprintf("%d %s", i, payload); line != want {
+			t.Fatalf("message %v out of order: %.10q", i, line)
+		}
+	}
+	if _, err := c.recv(2 * time.Second); err != io.EOF {
+		t.Fatalf("want EOF after the messages, got %v", err)
+	}
+}
+
+// TestSendDuringShutdown sends after StopWithTimeout began, which has
+// canceled the context of the connection.
+func TestSendDuringShutdown(t *testing.T) {
+	s := startTestServer(t,
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			<-ctx.Done()
+			return ConnFromContext(ctx).Send([]byte("bye"))
+		}))
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "hi")
+	time.Sleep(50 * time.Millisecond)
+
+	go s.StopWithTimeout(5 * time.Second)
+	if line, err := c.recv(2 * time.Second); err != nil || line != "bye" {
+		t.Fatalf("the message sent while draining was dropped: %q, %v", line, err)
+	}
+}
+
+func TestSendAfterFinished(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	s := startTestServer(t,
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			conns <- ConnFromContext(ctx)
+			return errors.New("done")
+		}))
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "hi")
+	conn := <-conns
+	if !waitFor(time.Second, func() bool { return s.ActiveConnections() == 0 }) {
+		t.Fatal("the connection didn't finish")
+	}
+	if err := conn.Send([]byte("late")); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("want ErrConnClosed, got %v", err)
+	}
+}
+
+// TestSendIdleWriter checks that the writer of an idle connection
+// blocks on the outbound queue instead of polling it.
+func TestSendIdleWriter(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			conn := ConnFromContext(ctx)
+			err := conn.Send(msg)
+			conns <- conn
+			return err
+		}))
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "hi")
+	if line, err := c.recv(time.Second); err != nil || line != "hi" {
+		t.Fatalf("got %q, %v", line, err)
+	}
+	q := (<-conns).sendQueue.(ringbuf.Dbg)
+
+	before := q.GetGetWaits()
+	time.Sleep(100 * time.Millisecond)
+	if waits := q.GetGetWaits() - before; waits > 1 {
+		t.Fatalf("the idle writer waited %v times in 100ms, want it blocked", waits)
+	}
+}
@@ -118,7 +118,7 @@ func (s *connectionObj) handleMessage(ctx context.Context, msg []byte) {
 		case message == "/quit":
 			fmt.Println("Quitting.")
 			s.WriteString("I'm shutting down now.\n")
-			fmt.Println("< " + "%quit%")
+			fmt.Printf("< %%quit%%\n")
 			s.WriteString("%quit%\n")
 			//os.Exit(0)
 			//s.Close()
@@ -0,0 +1,56 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetCodec(t *testing.T) {
+	binary := NewLengthPrefixedCodec(4, 0)
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			switch string(msg) {
+			case "STARTBIN":
+				err := out.WriteMessage([]byte("OK")) // still a line
+				ConnFromContext(ctx).SetCodec(binary)
+				return err
+			case "STOPBIN":
+				ConnFromContext(ctx).SetCodec(nil)
+			}
+			return out.WriteMessage(msg)
+		}))
+	c := newLineConn(dialTestServer(t, s))
+
+	// the frame following the switch comes in the same packet
+	var buf bytes.Buffer
+	buf.WriteString("STARTBIN\n")
+	_ = binary.Encode(&buf, []byte("bin\nary"))
+	if _, err := c.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := c.recv(time.Second); err != nil || line != "OK" {
+		t.Fatalf("the reply before the switch: %q, %v", line, err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	if msg, err := binary.Decode(c.br); err != nil || string(msg) != "bin\nary" {
+		t.Fatalf("the reply after the switch: %q, %v", msg, err)
+	}
+
+	buf.Reset()
+	_ = binary.Encode(&buf, []byte("STOPBIN"))
+	buf.WriteString("line\n")
+	if _, err := c.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"STOPBIN", "line"} {
+		if line, err := c.recv(time.Second); err != nil || line != want {
+			t.Fatalf("after the codec restored: %q, %v, want %q", line, err, want)
+		}
+	}
+}
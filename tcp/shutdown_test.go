@@ -0,0 +1,78 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStopWithTimeoutDrainsSlowHandler(t *testing.T) {
+	s := startTestServer(t,
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			time.Sleep(200 * time.Millisecond) // in flight while stopping
+			return out.WriteMessage(msg)
+		}))
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "slow")
+
+	stopped := make(chan time.Duration)
+	go func() {
+		t0 := time.Now()
+		s.StopWithTimeout(5 * time.Second)
+		stopped <- time.Since(t0)
+	}()
+
+	if line, err := c.recv(2 * time.Second); err != nil || line != "slow" {
+		t.Fatalf("the in-flight request was dropped: %q, %v", line, err)
+	}
+	_ = c.Close()
+	select {
+	case d := <-stopped:
+		if d >= 5*time.Second {
+			t.Fatalf("Stop waited for the timeout: %v", d)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop didn't return after the connection finished")
+	}
+}
+
+func TestStopWithTimeoutClosesStuckHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := startTestServer(t,
+		WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			<-release // stuck, ignoring ctx
+			return nil
+		}))
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "stuck")
+	time.Sleep(50 * time.Millisecond)
+
+	const d = 300 * time.Millisecond
+	t0 := time.Now()
+	s.StopWithTimeout(d)
+	if elapsed := time.Since(t0); elapsed < d {
+		t.Fatalf("Stop returned before the timeout: %v", elapsed)
+	}
+	if _, err := c.recv(time.Second); err == nil {
+		t.Fatal("the stuck connection wasn't closed")
+	} else if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+		t.Fatalf("the stuck connection is still open: %v", err)
+	}
+}
+
+func TestStopDefaultsToDrain(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.shutdownTimeout != DefaultShutdownTimeout {
+		t.Fatalf("the default shutdown timeout is %v", s.shutdownTimeout)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"github.com/hedzr/log"
+	"net"
+)
+
+// sockOpts holds the socket tuning options, the unset ones keep the
+// OS defaults.
+type sockOpts struct {
+	noDelay     *bool
+	readBuffer  int
+	writeBuffer int
+	linger      *int // applied on closing, see Conn.Close
+}
+
+// apply tunes c if it's a *net.TCPConn.
+func (o *sockOpts) apply(c net.Conn) (err error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if o.noDelay != nil {
+		if err = tc.SetNoDelay(*o.noDelay); err != nil {
+			return
+		}
+	}
+	if o.readBuffer > 0 {
+		if err = tc.SetReadBuffer(o.readBuffer); err != nil {
+			return
+		}
+	}
+	if o.writeBuffer > 0 {
+		err = tc.SetWriteBuffer(o.writeBuffer)
+	}
+	return
+}
+
+// tuningListener applies sockOpts to each accepted connection, it
+// wraps the raw listener so that the TLS connections are tuned too.
+// The connection just accepted is kept in last for runLoop, as
+// proxyListener does, see Conn.NetConn.
+type tuningListener struct {
+	net.Listener
+	opts   *sockOpts
+	logger log.Logger
+	last   net.Conn
+}
+
+// Accept closes and logs the connection which can't be tuned, and
+// goes on accepting the next one, since it's no failure of the
+// listener.
+func (l *tuningListener) Accept() (c net.Conn, err error) {
+	for {
+		if c, err = l.Listener.Accept(); err != nil {
+			break
+		}
+		e := l.opts.apply(c)
+		if e == nil {
+			break
+		}
+		l.logger.Warnf("conn(from: %v) can't be tuned, closing: %v", c.RemoteAddr(), e)
+		_ = c.Close()
+	}
+	l.last = c
+	return
+}
+
+// take returns the connection accepted last time.
+func (l *tuningListener) take() (c net.Conn) {
+	c, l.last = l.last, nil
+	return
+}
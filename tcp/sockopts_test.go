@@ -0,0 +1,63 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"github.com/hedzr/log"
+	"net"
+	"testing"
+)
+
+// connsListener hands out the given connections, then fails.
+type connsListener struct {
+	net.Listener
+	conns []net.Conn
+}
+
+func (l *connsListener) Accept() (c net.Conn, err error) {
+	if len(l.conns) == 0 {
+		return nil, net.ErrClosed
+	}
+	c, l.conns = l.conns[0], l.conns[1:]
+	return
+}
+
+// tcpConn returns the client end of a loopback connection.
+func tcpConn(t *testing.T) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestTuningListenerSkipsUntunable(t *testing.T) {
+	bad, good := tcpConn(t), tcpConn(t)
+	_ = bad.Close() // setting the options fails on a closed socket
+
+	noDelay := true
+	l := &tuningListener{
+		Listener: &connsListener{conns: []net.Conn{bad, good}},
+		opts:     &sockOpts{noDelay: &noDelay},
+		logger:   log.NewStdLogger(),
+	}
+	c, err := l.Accept()
+	if err != nil || c != good {
+		t.Fatalf("Accept: %v, %v, want the tunable connection", c, err)
+	}
+	if l.take() != good {
+		t.Fatal("take doesn't return the connection accepted")
+	}
+	if _, err = l.Accept(); err == nil {
+		t.Fatal("the error of the listener isn't returned")
+	}
+}
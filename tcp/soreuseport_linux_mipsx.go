@@ -0,0 +1,12 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+// soReusePort is SO_REUSEPORT, which the syscall package lacks.
+const soReusePort = 0x200
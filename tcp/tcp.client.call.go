@@ -0,0 +1,156 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+var (
+	// ErrNoCodec is returned by Client.Call if WithClientCodec isn't
+	// specified.
+	ErrNoCodec = errors.New("no codec specified")
+	// ErrMalformedCall means a message is too short to carry the
+	// correlation ID of Client.Call.
+	ErrMalformedCall = errors.New("malformed call message")
+)
+
+// CallIDSize is the size of the correlation ID which prefixes the
+// requests and responses of Client.Call, in big-endian.
+const CallIDSize = 8
+
+type callResult struct {
+	resp []byte
+	err  error
+}
+
+// Call sends msg as a request and waits for the matching response,
+// the calls can be made concurrently over the connection. The
+// request is framed by the codec of WithClientCodec, with a
+// correlation ID before msg, the server should answer it with the
+// same ID, see ServeCalls.
+//
+// The call fails with ErrNotConnected if the connection dropped
+// before the response arrived, and ctx.Err() if ctx is done.
+func (s *Client) Call(ctx context.Context, msg []byte) (resp []byte, err error) {
+	if s.codec == nil {
+		return nil, ErrNoCodec
+	}
+
+	id := atomic.AddUint64(&s.nextCallID, 1)
+	req := make([]byte, CallIDSize, CallIDSize+len(msg))
+	binary.BigEndian.PutUint64(req, id)
+	var frame bytes.Buffer
+	if err = s.codec.Encode(&frame, append(req, msg...)); err != nil {
+		return
+	}
+
+	ch := make(chan callResult, 1)
+	s.callsMu.Lock()
+	if s.calls == nil {
+		s.calls = make(map[uint64]chan callResult)
+	}
+	s.calls[id] = ch
+	s.callsMu.Unlock()
+	defer func() {
+		s.callsMu.Lock()
+		delete(s.calls, id)
+		s.callsMu.Unlock()
+	}()
+
+	if err = s.Send(frame.Bytes()); err != nil {
+		return
+	}
+	select {
+	case r := <-ch:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// DroppedResponses returns the count of the responses dropped since
+// no call was waiting for them, such as the calls timed out.
+func (s *Client) DroppedResponses() uint64 {
+	return atomic.LoadUint64(&s.droppedResponses)
+}
+
+// readResponses decodes the messages from conn with the codec, and
+// hands them over to the pending calls. The heartbeat messages are
+// handled here too, see WithClientHeartbeat.
+func (s *Client) readResponses(conn net.Conn, hb *heartbeat) {
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := s.codec.Decode(r)
+		if err != nil {
+			if err != io.EOF && !s.IsClosed() {
+				s.Errorf("   tcp: decoding message failed: %v", err)
+			}
+			return
+		}
+		if hb != nil {
+			hb.seen()
+		}
+		if len(s.hbPing) > 0 && bytes.Equal(msg, s.hbPing) {
+			if err = s.writeMessage(conn, s.hbPong); err != nil {
+				s.Errorf("   tcp: writing pong failed: %v", err)
+			}
+			continue
+		}
+		if len(s.hbPong) > 0 && bytes.Equal(msg, s.hbPong) {
+			continue
+		}
+
+		var ch chan callResult
+		if len(msg) >= CallIDSize {
+			id := binary.BigEndian.Uint64(msg)
+			s.callsMu.Lock()
+			ch = s.calls[id]
+			delete(s.calls, id)
+			s.callsMu.Unlock()
+		}
+		if ch == nil {
+			atomic.AddUint64(&s.droppedResponses, 1)
+			s.Debugf("   tcp: response dropped, no call is waiting for it")
+			continue
+		}
+		ch <- callResult{resp: msg[CallIDSize:]}
+	}
+}
+
+// failCalls fails all of the pending calls with err.
+func (s *Client) failCalls(err error) {
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+	for id, ch := range s.calls {
+		ch <- callResult{err: err}
+		delete(s.calls, id)
+	}
+}
+
+// ServeCalls returns the message handler for WithServerOnMessageFunc
+// which answers the requests of Client.Call with fn. An error from
+// fn closes the connection, so the errors of the application should
+// be encoded into the response.
+func ServeCalls(fn func(ctx context.Context, req []byte) (resp []byte, err error)) OnTcpServerMessageFunc {
+	return func(ctx context.Context, msg []byte, out MessageWriter) (err error) {
+		if len(msg) < CallIDSize {
+			return ErrMalformedCall
+		}
+		var resp []byte
+		if resp, err = fn(ctx, msg[CallIDSize:]); err != nil {
+			return
+		}
+		frame := make([]byte, 0, CallIDSize+len(resp))
+		return out.WriteMessage(append(append(frame, msg[:CallIDSize]...), resp...))
+	}
+}
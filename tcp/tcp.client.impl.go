@@ -6,23 +6,58 @@ package tcp
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	tls2 "crypto/tls"
+	"crypto/x509"
+	"errors"
 	"github.com/hedzr/cmdr"
+	"github.com/hedzr/go-socketlib/ringbuf"
 	"github.com/hedzr/go-socketlib/tcp/tls"
 	"github.com/hedzr/log"
 	"github.com/hedzr/log/trace"
 	"io"
+	log2 "log"
+	"math/rand"
 	"net"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// ErrNotConnected is returned by Client.Send while the client is
+// disconnected (or reconnecting).
+var ErrNotConnected = errors.New("not connected")
+
+// DialError means connecting to the server failed, as opposed to
+// the I/O errors on an established connection.
+type DialError struct {
+	Addr string
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return "dial " + e.Addr + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error, such as a net.Error which
+// reports Timeout() for WithClientDialTimeout.
+func (e *DialError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrDial.
+func (e *DialError) Is(target error) bool { return target == ErrDial }
+
 type Client struct {
-	host   string
-	port   int
+	nextCallID       uint64 // 64-bit aligned for atomic
+	droppedResponses uint64
+	pending          int64 // the messages queued by Send and not written yet
+
+	addr   string
 	conn   net.Conn
+	connMu sync.Mutex
+	wmu    sync.Mutex    // serializes the writes, see SendContext
+	ready  chan struct{} // closed while connected
 	done   chan struct{}
 	wg     sync.WaitGroup
 	closed int32
@@ -32,20 +67,67 @@ type Client struct {
 
 	readBufferSize int
 	verbose        bool
+	sock           sockOpts
 
 	connectedCh       chan net.Conn
-	sendCh            chan []byte
+	sendQueue         ringbuf.RingBuffer
+	sendQueueSize     uint32
+	idleMu            sync.Mutex
+	idle              chan struct{} // closed once pending drops to 0, see Flush
 	onTcpProcess      OnTcpProcessFunc
 	onTcpConnected    OnTcpConnectedFunc
 	onTcpDisconnected OnTcpDisconnectedFunc
+	onTcpReconnect    OnTcpReconnectFunc
+
+	autoReconnect bool
+	backoffMin    time.Duration
+	backoffMax    time.Duration
+	backoffFactor float64
+	backoff       time.Duration // the current delay of reconnecting
+	sendTimeout   time.Duration
+	dialTimeout   time.Duration
+	keepAlive     time.Duration
+	alpn          []string
+	tlsConfig     *tls2.Config
+	tlsServerName string
+	tlsInsecure   bool
+	compression   Compression
+	transport     Transport
+	codec         Codec
+	maxMsgSize    int
+	calls         map[uint64]chan callResult // the pending calls
+	callsMu       sync.Mutex
+
+	hbInterval time.Duration
+	hbPing     []byte
+	hbPong     []byte
 }
 
 type OnTcpConnectedFunc func(c *Client, conn net.Conn)
 type OnTcpProcessFunc func(buf []byte, in *bufio.Reader, out *bufio.Writer) (nn int, err error)
 type OnTcpDisconnectedFunc func(c *Client)
 
+// OnTcpReconnectFunc is called before each reconnecting attempt,
+// attempt starts from 1.
+type OnTcpReconnectFunc func(c *Client, attempt int)
+
+// NewClient connects to addr, see Dial. The client returned is
+// closed already if the connecting failed without
+// WithClientAutoReconnect, nil if addr is malformed.
 func NewClient(addr string, opts ...ClientOpt) *Client {
-	return newClient(addr, opts...)
+	c, _ := newClient(addr, opts...)
+	return c
+}
+
+// Dial connects to addr like NewClient, but returns the failure: a
+// *DialError, test it with errors.Is(err, ErrDial). With
+// WithClientAutoReconnect the client keeps connecting in background
+// after the first attempt failed, so it's returned without error.
+func Dial(addr string, opts ...ClientOpt) (c *Client, err error) {
+	if c, err = newClient(addr, opts...); err != nil {
+		c = nil
+	}
+	return
 }
 
 // func WithClientVerbose(verbose bool) ClientOpt {
@@ -60,6 +142,41 @@ func WithClientTlsConfig(s *tls.CmdrTlsConfig) ClientOpt {
 	}
 }
 
+// WithClientTLS connects over TLS with config, it takes precedence
+// over WithClientTlsConfig. The server name is taken from the
+// address if config.ServerName is empty, see WithClientServerName.
+func WithClientTLS(config *tls2.Config) ClientOpt {
+	return func(client *Client) {
+		client.tlsConfig = config
+	}
+}
+
+// WithClientServerName sets the name of the server to verify its
+// certificate against, and to send by SNI, instead of the host of
+// the address, such as connecting by the IP address. It enables TLS
+// with the system roots if neither WithClientTLS nor
+// WithClientTlsConfig is specified.
+func WithClientServerName(sni string) ClientOpt {
+	return func(client *Client) {
+		client.tlsServerName = sni
+	}
+}
+
+// WithClientInsecureSkipVerify accepts any certificate presented by
+// the server, without verifying the chain nor the name.
+//
+// DANGEROUS: the connection is open to the man-in-the-middle
+// attacks, use it for the testing against a self-signed server only,
+// never in production. Trust the server by WithClientTLS with the
+// RootCAs instead, or check the certificate by
+// Client.PeerCertificates at least. It enables TLS as
+// WithClientServerName does.
+func WithClientInsecureSkipVerify(b bool) ClientOpt {
+	return func(client *Client) {
+		client.tlsInsecure = b
+	}
+}
+
 func WithClientReadBufferSize(size int) ClientOpt {
 	return func(client *Client) {
 		client.readBufferSize = size
@@ -84,6 +201,169 @@ func WithClientOnDisconnectedFunc(fn OnTcpDisconnectedFunc) ClientOpt {
 	}
 }
 
+// WithClientAutoReconnect redials the server once the connection
+// dropped (or the initial dialing failed), with the delays specified
+// by WithClientReconnectBackoff.
+func WithClientAutoReconnect(b bool) ClientOpt {
+	return func(client *Client) {
+		client.autoReconnect = b
+	}
+}
+
+// WithClientReconnectBackoff sets the exponential backoff of
+// reconnecting: the delay starts from min, and is multiplied by
+// factor after each failed attempt, up to max. A random jitter of
+// up to the half of the delay is applied.
+//
+// The delay is reset to min once a connection stays up for max
+// at least.
+func WithClientReconnectBackoff(min, max time.Duration, factor float64) ClientOpt {
+	return func(client *Client) {
+		client.backoffMin, client.backoffMax, client.backoffFactor = min, max, factor
+	}
+}
+
+// WithClientOnReconnectFunc sets the callback called before each
+// reconnecting attempt.
+func WithClientOnReconnectFunc(fn OnTcpReconnectFunc) ClientOpt {
+	return func(client *Client) {
+		client.onTcpReconnect = fn
+	}
+}
+
+// WithClientSendTimeout makes Send wait up to d for the connection
+// while reconnecting. By default, Send returns ErrNotConnected at
+// once.
+func WithClientSendTimeout(d time.Duration) ClientOpt {
+	return func(client *Client) {
+		client.sendTimeout = d
+	}
+}
+
+// WithClientSendQueueSize sets the capacity of the outbound queue of
+// Send, DefaultSendQueueSize by default. The capacity is rounded up
+// to a power of 2.
+func WithClientSendQueueSize(n int) ClientOpt {
+	return func(client *Client) {
+		if n <= 0 {
+			log2.Panicf("wrong send queue size: %v", n)
+		}
+		client.sendQueueSize = uint32(n)
+	}
+}
+
+// WithClientDialTimeout limits the duration of connecting to the
+// server, including the reconnecting attempts, and the TLS
+// handshake if any. It overrides the DialTimeout of
+// WithClientTlsConfig. Zero means no limit other than the OS's.
+func WithClientDialTimeout(d time.Duration) ClientOpt {
+	return func(client *Client) {
+		client.dialTimeout = d
+	}
+}
+
+// WithClientKeepAlive sets the period of the OS-level TCP keepalive
+// probes, negative d disables them. Zero means the default of the
+// net package (15s currently).
+func WithClientKeepAlive(d time.Duration) ClientOpt {
+	return func(client *Client) {
+		client.keepAlive = d
+	}
+}
+
+// WithClientALPNProtocols sets the application protocols offered
+// to the server over TLS, in the order of preference. See
+// Client.NegotiatedProtocol.
+func WithClientALPNProtocols(protos []string) ClientOpt {
+	return func(client *Client) {
+		client.alpn = protos
+	}
+}
+
+// WithClientTransport connects to the server over t instead of the
+// default TCPTransport, which should be the same as the server's,
+// see WithServerTransport. WithClientKeepAlive is for the default
+// TCPTransport only.
+// The TLS of WithClientTLS or WithClientTlsConfig is layered over
+// it, and the handshake is limited by WithClientDialTimeout.
+func WithClientTransport(t Transport) ClientOpt {
+	return func(client *Client) {
+		client.transport = t
+	}
+}
+
+// WithClientCompression compresses all bytes on the connection with
+// algo, which must be the same as the server's, see
+// WithServerCompression.
+func WithClientCompression(algo Compression) ClientOpt {
+	return func(client *Client) {
+		client.compression = algo
+	}
+}
+
+// WithClientCodec frames the stream into the messages with codec,
+// which is required by Call. The messages received are treated as
+// the responses of Call, and WithClientOnProcessFunc is ignored.
+func WithClientCodec(codec Codec) ClientOpt {
+	return func(client *Client) {
+		client.codec = codec
+	}
+}
+
+// WithClientMaxMessageSize limits the messages of the codec of
+// WithClientCodec to n bytes, see WithServerMaxMessageSize.
+func WithClientMaxMessageSize(n int) ClientOpt {
+	return func(client *Client) {
+		if n <= 0 {
+			log2.Panicf("wrong max message size: %v", n)
+		}
+		client.maxMsgSize = n
+	}
+}
+
+// WithClientHeartbeat sends ping to the server if it's silent for
+// interval, and closes the connection if nothing arrives within
+// another interval, which triggers the reconnecting if
+// WithClientAutoReconnect enabled. The ping from the server is
+// answered with pong.
+//
+// The heartbeat works on the messages of the codec specified by
+// WithClientCodec, as WithServerHeartbeat does, so ping and pong
+// should be the same as the server's. It's ignored if no codec,
+// since the raw reads can't tell a ping from the data around it.
+// interval 0 only answers the pings of the server, such as for an
+// idle client of Client.Call.
+func WithClientHeartbeat(interval time.Duration, ping, pong []byte) ClientOpt {
+	return func(client *Client) {
+		client.hbInterval, client.hbPing, client.hbPong = interval, ping, pong
+	}
+}
+
+// WithClientNoDelay controls the Nagle's algorithm of the
+// connection, see net.TCPConn.SetNoDelay. The socket options are
+// applied to the plain TCP connections only.
+func WithClientNoDelay(b bool) ClientOpt {
+	return func(client *Client) {
+		client.sock.noDelay = &b
+	}
+}
+
+// WithClientSocketReadBuffer sets the size of the kernel receive
+// buffer of the connection.
+func WithClientSocketReadBuffer(size int) ClientOpt {
+	return func(client *Client) {
+		client.sock.readBuffer = size
+	}
+}
+
+// WithClientSocketWriteBuffer sets the size of the kernel send
+// buffer of the connection.
+func WithClientSocketWriteBuffer(size int) ClientOpt {
+	return func(client *Client) {
+		client.sock.writeBuffer = size
+	}
+}
+
 //func WithClientLoggerConfig(config *log.LoggerConfig) ClientOpt {
 //	return func(client *Client) {
 //		client.Logger = build.New(config)
@@ -96,39 +376,46 @@ func WithClientLogger(l log.Logger) ClientOpt {
 	}
 }
 
-func newClient(addr string, opts ...ClientOpt) *Client {
-	s := &Client{
+func newClient(addr string, opts ...ClientOpt) (s *Client, err error) {
+	s = &Client{
 		base:           newBase(nil),
 		done:           make(chan struct{}),
 		connectedCh:    make(chan net.Conn),
-		sendCh:         make(chan []byte),
+		sendQueueSize:  DefaultSendQueueSize,
+		idle:           make(chan struct{}),
 		readBufferSize: 4096,
+		ready:          make(chan struct{}),
+		backoffMin:     500 * time.Millisecond,
+		backoffMax:     30 * time.Second,
+		backoffFactor:  2,
 	}
 
-	var port string
-	var err error
-	s.host, port, err = net.SplitHostPort(addr)
-	// s.wrong(err, "can't split addr to host & port")
-	// s.wrong(err, "can't split addr to host & port")
-	// s.wrong(err, "can't split addr to host & port")
-	if err != nil {
-		s.Errorf("can't split addr to host & port: %v", err)
-		return nil
-	}
-	s.port, err = strconv.Atoi(port)
-	if err != nil {
-		s.Errorf("can't parse port to integer: %v", err)
-		return nil
-	}
+	s.addr = addr
 
 	for _, opt := range opts {
 		opt(s)
 	}
+	s.backoff = s.backoffMin
+	s.codec = limitCodec(s.codec, s.maxMsgSize)
+
+	if s.transport == nil {
+		var port string
+		_, port, err = net.SplitHostPort(addr)
+		// s.wrong(err, "can't split addr to host & port")
+		if err != nil {
+			s.Errorf("can't split addr to host & port: %v", err)
+			return nil, &DialError{Addr: addr, Err: err}
+		}
+		if _, err = strconv.Atoi(port); err != nil {
+			s.Errorf("can't parse port to integer: %v", err)
+			return nil, &DialError{Addr: addr, Err: err}
+		}
+	}
 
 	if err = s.run(); err != nil {
-		s.Errorf("can't run(): 5v", err)
+		s.Errorf("can't run(): %v", err)
 	}
-	return s
+	return
 }
 
 func (s *Client) run() (err error) {
@@ -136,39 +423,182 @@ func (s *Client) run() (err error) {
 		s.done = make(chan struct{})
 	}
 
-	if s.sendCh == nil {
-		s.sendCh = make(chan []byte)
+	if s.sendQueue == nil {
+		s.sendQueue = newSendQueue(s.sendQueueSize)
 	}
 
 	if s.onTcpProcess == nil {
 		s.onTcpProcess = s.defaultOnRead
 	}
 
-	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	addr := s.addr
 
-	go s.runLoop(s.done)
+	done := s.done
+	go s.runLoop(done)
+	go s.writeLoop(done)
 
 	var c net.Conn
-	c, err = s.CmdrTlsConfig.Dial("tcp", addr)
+	c, err = s.dial(addr)
 	// s.conn, err = net.Dial("tcp", addr)
 	if err != nil {
 		s.Errorf("[tcp][client] error connecting to %v: %v", addr, err)
+		if s.autoReconnect {
+			go s.reconnect(done)
+			return nil
+		}
 		s.Close()
 		return // os.Exit(1)
 	}
+	s.connected(c, done)
+
+	// s.debug("[tcp][client] end of client looper")
+	return
+}
+
+// dial connects to addr with the settings of
+// WithClientDialTimeout and WithClientKeepAlive, a *DialError
+// returned on failure.
+func (s *Client) dial(addr string) (c net.Conn, err error) {
+	dialer := &net.Dialer{Timeout: s.dialTimeout, KeepAlive: s.keepAlive}
+	if s.dialTimeout == 0 && s.CmdrTlsConfig != nil {
+		dialer.Timeout = s.CmdrTlsConfig.DialTimeout
+	}
+	t := s.transport
+	if t == nil {
+		t = &TCPTransport{Dialer: *dialer}
+	}
+	var tc *tls2.Config
+	if tc, err = s.clientTlsConfig(); err == nil {
+		c, err = s.dialTransport(t, tc, dialer.Timeout, addr)
+	}
+	if err != nil {
+		err = &DialError{Addr: addr, Err: err}
+	}
+	return
+}
+
+// clientTlsConfig builds the tls.Config from WithClientTLS or
+// WithClientTlsConfig, and the other TLS options. nil returned if
+// TLS isn't enabled.
+func (s *Client) clientTlsConfig() (tc *tls2.Config, err error) {
+	if s.tlsConfig != nil {
+		tc = s.tlsConfig.Clone()
+	} else if tc, err = s.CmdrTlsConfig.ToClientTlsConfig(); err != nil {
+		return
+	}
+	if tc == nil {
+		if s.tlsServerName == "" && !s.tlsInsecure {
+			return
+		}
+		tc = &tls2.Config{}
+	}
+
+	if s.tlsServerName != "" {
+		tc.ServerName = s.tlsServerName
+	}
+	if s.tlsInsecure {
+		tc.InsecureSkipVerify = true
+	}
+	if len(s.alpn) > 0 {
+		tc.NextProtos = s.alpn
+	}
+	return
+}
+
+// dialTransport connects over t, and then does the TLS handshake
+// if tc isn't nil.
+func (s *Client) dialTransport(t Transport, tc *tls2.Config, timeout time.Duration, addr string) (c net.Conn, err error) {
+	if c, err = t.Dial(addr); err != nil || tc == nil {
+		return
+	}
+
+	if tc.ServerName == "" && !tc.InsecureSkipVerify {
+		if host, _, e := net.SplitHostPort(addr); e == nil {
+			tc.ServerName = host
+		}
+	}
+	conn := tls2.Client(c, tc)
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err = conn.Handshake(); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connected starts serving the connection c.
+func (s *Client) connected(c net.Conn, done <-chan struct{}) {
+	if err := s.sock.apply(c); err != nil {
+		s.Warnf("[tcp][client] tuning socket failed: %v", err)
+	}
+	if s.compression != nil {
+		c = newCompressedConn(c, s.compression)
+	}
+
+	s.connMu.Lock()
 	s.conn = c
-	s.Debugf("➠ [tcp][client] connected to %v", addr)
+	close(s.ready)
+	s.connMu.Unlock()
+	s.Debugf("➠ [tcp][client] connected to %v", c.RemoteAddr())
 	// defer conn.Close()
 
 	s.wg.Add(1)
 	// go s.handleWrite(s.conn, &s.wg)
-	go s.handleRead(s.conn, &s.wg)
+	go s.handleRead(c, &s.wg, done)
 	// s.wg.Wait()
 
-	s.connectedCh <- s.conn
+	select {
+	case s.connectedCh <- c:
+	case <-done:
+	}
+}
 
-	// s.debug("[tcp][client] end of client looper")
-	return
+// disconnected is called once the connection c dropped, and starts
+// reconnecting if WithClientAutoReconnect enabled.
+func (s *Client) disconnected(c net.Conn, connectedAt time.Time, done <-chan struct{}) {
+	s.connMu.Lock()
+	s.ready = make(chan struct{})
+	s.connMu.Unlock()
+	_ = c.Close()
+	s.failCalls(ErrNotConnected)
+
+	if !s.autoReconnect || s.IsClosed() {
+		return
+	}
+	if time.Since(connectedAt) >= s.backoffMax {
+		s.backoff = s.backoffMin // it was stable
+	}
+	go s.reconnect(done)
+}
+
+// reconnect redials until succeeded or the client closed.
+func (s *Client) reconnect(done <-chan struct{}) {
+	addr := s.addr
+	for attempt := 1; ; attempt++ {
+		delay := s.backoff/2 + time.Duration(rand.Int63n(int64(s.backoff/2)+1))
+		select {
+		case <-done:
+			return
+		case <-time.After(delay):
+		}
+
+		if s.onTcpReconnect != nil {
+			s.onTcpReconnect(s, attempt)
+		}
+		c, err := s.dial(addr)
+		if err == nil {
+			s.connected(c, done)
+			return
+		}
+		s.Warnf("[tcp][client] reconnecting to %v failed (attempt %v, retry in %v): %v", addr, attempt, s.backoff, err)
+
+		if s.backoff = time.Duration(float64(s.backoff) * s.backoffFactor); s.backoff > s.backoffMax {
+			s.backoff = s.backoffMax
+		}
+	}
 }
 
 func (s *Client) IsClosed() bool {
@@ -176,6 +606,42 @@ func (s *Client) IsClosed() bool {
 	return c == 1
 }
 
+// NegotiatedProtocol returns the application protocol negotiated by
+// TLS ALPN on the current connection, or "" if none.
+func (s *Client) NegotiatedProtocol() string {
+	if tc := s.tlsConn(); tc != nil {
+		return tc.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
+
+// PeerCertificates returns the certificate chain presented by the
+// server on the current connection, the leaf first, so that the
+// caller can pin it, such as comparing the SHA-256 of
+// PeerCertificates()[0].RawSubjectPublicKeyInfo. nil returned if the
+// client isn't connected over TLS.
+//
+// To reject a connection before any data sent, check the chain in
+// the VerifyPeerCertificate of WithClientTLS instead.
+func (s *Client) PeerCertificates() []*x509.Certificate {
+	if tc := s.tlsConn(); tc != nil {
+		return tc.ConnectionState().PeerCertificates
+	}
+	return nil
+}
+
+// tlsConn returns the TLS connection under the current one, or nil.
+func (s *Client) tlsConn() *tls2.Conn {
+	s.connMu.Lock()
+	c := s.conn
+	s.connMu.Unlock()
+	if cc, ok := c.(*compressedConn); ok {
+		c = cc.Conn
+	}
+	tc, _ := c.(*tls2.Conn)
+	return tc
+}
+
 func (s *Client) Close() {
 	if s.done != nil {
 		close(s.done)
@@ -187,9 +653,11 @@ func (s *Client) Close() {
 
 func (s *Client) closeConn() {
 	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		s.connMu.Lock()
+		defer s.connMu.Unlock()
 		if s.conn != nil {
 			if err := s.conn.Close(); err != nil {
-				if strings.Contains(err.Error(), "use of closed network connection") {
+				if errors.Is(err, net.ErrClosed) {
 					s.Tracef("s.conn closed by others.")
 				} else {
 					s.Errorf("closing s.conn: %v", err)
@@ -220,22 +688,214 @@ func (s *Client) runLoop(done <-chan struct{}) {
 			if s.onTcpConnected != nil {
 				s.onTcpConnected(s, c)
 			}
-		case data := <-s.sendCh:
-			s.write_(data)
 		}
 	}
 }
 
-func (s *Client) Send(data []byte) {
+// Send puts data into the outbound queue without blocking. A
+// dedicated goroutine writes the queued messages to the server in
+// order, coalescing the ones queued meanwhile into one write, so
+// that Send is safe to call from multiple goroutines and the
+// messages never interleave. Flush waits for them written.
+//
+// ErrNotConnected returned if the client is disconnected (see also
+// WithClientSendTimeout), and ringbuf.ErrQueueFull if the server is
+// too slow to keep up, see WithClientSendQueueSize.
+func (s *Client) Send(data []byte) (err error) {
 	if s.IsClosed() {
+		return ErrNotConnected
+	}
+
+	s.connMu.Lock()
+	ready := s.ready
+	s.connMu.Unlock()
+
+	select {
+	case <-ready:
+	default:
+		if s.sendTimeout <= 0 {
+			return ErrNotConnected
+		}
+		select {
+		case <-ready:
+		case <-time.After(s.sendTimeout):
+			return ErrNotConnected
+		}
+	}
+
+	atomic.AddInt64(&s.pending, 1)
+	if err = s.sendQueue.Enqueue(data); err != nil {
+		s.sent(1)
+		if err == ringbuf.ErrClosed {
+			err = ErrNotConnected
+		}
+	}
+	return
+}
+
+// Flush waits until the messages queued by Send so far have been
+// written, or dropped since the connection failed (which is logged).
+// ctx.Err() returned if ctx is done before.
+func (s *Client) Flush(ctx context.Context) (err error) {
+	for {
+		s.idleMu.Lock()
+		pending, idle := atomic.LoadInt64(&s.pending), s.idle
+		s.idleMu.Unlock()
+		if pending == 0 {
+			return
+		}
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sent counts n messages of the queue out, and wakes up Flush if
+// none left.
+func (s *Client) sent(n int64) {
+	if atomic.AddInt64(&s.pending, -n) == 0 {
+		s.idleMu.Lock()
+		close(s.idle)
+		s.idle = make(chan struct{})
+		s.idleMu.Unlock()
+	}
+}
+
+// writeLoop drains the outbound queue of Send until the client
+// closed, the messages found in the queue are written at once.
+func (s *Client) writeLoop(done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+	defer func() {
+		_ = s.sendQueue.Close()
+		if n := len(s.sendQueue.Drain()); n > 0 {
+			s.sent(int64(n))
+		}
+	}()
+
+	batch := make([]interface{}, sendBatchSize)
+	var buf []byte
+	for {
+		it, err := s.sendQueue.BlockingDequeue(ctx)
+		if err != nil {
+			return
+		}
+		batch[0] = it
+		n, _ := s.sendQueue.DequeueMany(batch[1:])
+
+		buf = buf[:0]
+		for i := 0; i <= n; i++ {
+			buf = append(buf, batch[i].([]byte)...)
+			batch[i] = nil
+		}
+		s.write_(buf)
+		s.sent(int64(n + 1))
+	}
+}
+
+// sendBatchSize is the max count of the messages coalesced into one
+// write by Client.writeLoop.
+const sendBatchSize = 64
+
+// SendContext writes data to the server like Send, but returns after
+// data written. It waits for the connection, if reconnecting, and the
+// writing no longer than ctx allows, and returns ctx.Err() then: the
+// deadline of ctx is set as the write deadline, and the write is
+// aborted once ctx cancelled.
+//
+// data is never left half-written in the stream, which would corrupt
+// the framing: if the write failed or aborted, the connection is
+// closed, and reconnected if WithClientAutoReconnect enabled.
+//
+// It writes at once, bypassing the outbound queue of Send, call
+// Flush before it to keep the order of the messages.
+func (s *Client) SendContext(ctx context.Context, data []byte) (err error) {
+	if s.IsClosed() {
+		return ErrNotConnected
+	}
+
+	s.connMu.Lock()
+	ready := s.ready
+	s.connMu.Unlock()
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.connMu.Lock()
+	conn := s.conn
+	s.connMu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	deadline, _ := ctx.Deadline() // zero for no deadline
+	if err = conn.SetWriteDeadline(deadline); err != nil {
 		return
 	}
-	s.sendCh <- data
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetWriteDeadline(time.Unix(1, 0)) // wakes up the write
+		case <-stop:
+		}
+	}()
+	_, err = conn.Write(data)
+	close(stop)
+	<-stopped
+	_ = conn.SetWriteDeadline(time.Time{})
+
+	if err != nil {
+		if e := ctx.Err(); e != nil {
+			err = e
+		}
+		s.Warnf("[tcp][client] sending failed, closing the connection: %v", err)
+		_ = conn.Close()
+	}
+	return
+}
+
+// writeConn writes data to conn, exclusive of the others.
+func (s *Client) writeConn(conn net.Conn, data []byte) (err error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err = conn.Write(data)
+	return
+}
+
+// writeMessage encodes msg with the codec, and writes the frame to
+// conn as a whole.
+func (s *Client) writeMessage(conn net.Conn, msg []byte) (err error) {
+	var frame bytes.Buffer
+	if err = s.codec.Encode(&frame, msg); err == nil {
+		err = s.writeConn(conn, frame.Bytes())
+	}
+	return
 }
 
 func (s *Client) write_(data []byte) {
 	if data != nil {
-		_, err := s.conn.Write(data)
+		s.connMu.Lock()
+		conn := s.conn
+		s.connMu.Unlock()
+		if conn == nil {
+			s.Errorf("error to send message: %v", ErrNotConnected)
+			return
+		}
+		err := s.writeConn(conn, data)
 		if err != nil {
 			s.Errorf("error to send message: %v", err)
 		} else if trace.IsEnabled() {
@@ -260,14 +920,35 @@ func (s *Client) defaultOnRead(p []byte, in *bufio.Reader, out *bufio.Writer) (n
 	return 0, nil
 }
 
-func (s *Client) handleRead(conn net.Conn, wg *sync.WaitGroup) {
+func (s *Client) handleRead(conn net.Conn, wg *sync.WaitGroup, done <-chan struct{}) {
+	connectedAt := time.Now()
+
+	var hb *heartbeat
+	if s.hbInterval > 0 && s.codec != nil {
+		hb = newHeartbeat(s.hbInterval)
+		stop := make(chan struct{})
+		defer close(stop)
+		go hb.run(stop, func() error {
+			return s.writeMessage(conn, s.hbPing)
+		}, func() {
+			s.Debugf("➠ [tcp][client] heartbeat lost, closing the connection")
+			_ = conn.Close()
+		})
+	}
+
 	defer func() {
 		if s.onTcpDisconnected != nil {
 			s.onTcpDisconnected(s)
 		}
+		s.disconnected(conn, connectedAt, done)
 		wg.Done()
 	}()
 
+	if s.codec != nil {
+		s.readResponses(conn, hb)
+		return
+	}
+
 	var nProcessed, n int
 	var err error
 	verbose := cmdr.GetBoolR("verbose")
@@ -296,9 +977,9 @@ func (s *Client) handleRead(conn net.Conn, wg *sync.WaitGroup) {
 				break // can't recovery from this point, exit and close socket right now
 			} else if e, ok := err.(net.Error); ok && e.Timeout() {
 				continue
-			} else if strings.Contains(err.Error(), "use of closed network connection") {
+			} else if errors.Is(err, net.ErrClosed) {
 				s.Tracef("conn(from %v) closed by others.", conn.RemoteAddr())
-			} else if strings.Contains(err.Error(), "connection reset by peer") {
+			} else if IsConnReset(err) {
 				s.Tracef("conn(from %v) closed by peer.", conn.RemoteAddr())
 			} else {
 				s.Errorf("   tcp: read failed. reason: %v", err)
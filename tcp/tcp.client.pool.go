@@ -0,0 +1,272 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"errors"
+	"github.com/hedzr/go-socketlib/tcp/tls"
+	"github.com/hedzr/log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by ClientPool.Acquire after the pool
+// closed.
+var ErrPoolClosed = errors.New("pool closed")
+
+// ClientPool keeps a set of persistent connections to one server,
+// so that many goroutines can talk with it in the request/response
+// way without dialing per request nor sharing one net.Conn.
+//
+// A connection is owned by one goroutine between Acquire and
+// Release:
+//
+//	conn, err := pool.Acquire(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	defer pool.Release(conn)
+//	... write the request, read the response ...
+//
+// or simply, by Do.
+type ClientPool struct {
+	addr        string
+	min, max    int
+	idleTimeout time.Duration
+
+	base
+	CmdrTlsConfig *tls.CmdrTlsConfig
+
+	mu       sync.Mutex
+	idle     []idleConn
+	slots    chan struct{} // one for each connection, idle or in use
+	released chan struct{}
+	done     chan struct{}
+	closed   bool
+}
+
+type idleConn struct {
+	net.Conn
+	since time.Time
+}
+
+// NewClientPool returns a ClientPool of the server at addr, and
+// dials the minimal connections (see WithPoolSize) at once.
+func NewClientPool(addr string, opts ...PoolOpt) *ClientPool {
+	p := &ClientPool{
+		addr: addr,
+		base: newBase(nil),
+		min:  0,
+		max:  8,
+		done: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.slots = make(chan struct{}, p.max)
+	p.released = make(chan struct{}, p.max)
+
+	for i := 0; i < p.min; i++ {
+		p.slots <- struct{}{}
+		conn, err := p.CmdrTlsConfig.Dial("tcp", p.addr)
+		if err != nil {
+			<-p.slots
+			p.Warnf("[tcp][pool] can't dial to %v: %v", p.addr, err)
+			break
+		}
+		p.idle = append(p.idle, idleConn{conn, time.Now()})
+	}
+
+	if p.idleTimeout > 0 {
+		go p.reapLoop(p.done)
+	}
+	return p
+}
+
+// WithPoolSize sets the count of connections kept at least (dialed
+// in advance), and the count allowed at most. The pool grows lazily
+// from min up to max.
+func WithPoolSize(min, max int) PoolOpt {
+	return func(pool *ClientPool) {
+		if max < 1 {
+			max = 1
+		}
+		if min > max {
+			min = max
+		}
+		pool.min, pool.max = min, max
+	}
+}
+
+// WithPoolIdleTimeout closes the connection if it was idle in the
+// pool for d, the minimal connections are kept.
+func WithPoolIdleTimeout(d time.Duration) PoolOpt {
+	return func(pool *ClientPool) {
+		pool.idleTimeout = d
+	}
+}
+
+func WithPoolTlsConfig(s *tls.CmdrTlsConfig) PoolOpt {
+	return func(pool *ClientPool) {
+		pool.CmdrTlsConfig = s
+	}
+}
+
+func WithPoolLogger(l log.Logger) PoolOpt {
+	return func(pool *ClientPool) {
+		pool.Logger = l
+	}
+}
+
+// Acquire takes an idle connection, or dials a new one if the pool
+// hasn't grown to the max size. Otherwise it waits until a
+// connection released or ctx done.
+//
+// The idle connections are health-checked before being handed out,
+// the broken ones are discarded.
+func (p *ClientPool) Acquire(ctx context.Context) (conn net.Conn, err error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if n := len(p.idle); n > 0 {
+			ic := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.mu.Unlock()
+
+			if p.expired(ic) || connCheck(ic.Conn) != nil {
+				p.Discard(ic.Conn)
+				continue
+			}
+			return ic.Conn, nil
+		}
+		p.mu.Unlock()
+
+		select {
+		case p.slots <- struct{}{}:
+			if conn, err = p.CmdrTlsConfig.Dial("tcp", p.addr); err != nil {
+				<-p.slots
+			}
+			return
+		case <-p.released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Release gives conn back to the pool. The broken connection is
+// discarded.
+func (p *ClientPool) Release(conn net.Conn) {
+	if connCheck(conn) != nil {
+		p.Discard(conn)
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.Discard(conn)
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn, time.Now()})
+	p.mu.Unlock()
+
+	select {
+	case p.released <- struct{}{}:
+	default:
+	}
+}
+
+// Discard closes conn and frees its room in the pool, it should be
+// called instead of Release once an i/o error occurred on conn.
+func (p *ClientPool) Discard(conn net.Conn) {
+	_ = conn.Close()
+	<-p.slots
+}
+
+// Do runs fn with an acquired connection. The connection is
+// discarded if fn returns an error, or released otherwise.
+func (p *ClientPool) Do(ctx context.Context, fn func(conn net.Conn) error) (err error) {
+	var conn net.Conn
+	if conn, err = p.Acquire(ctx); err != nil {
+		return
+	}
+
+	if err = fn(conn); err != nil {
+		p.Discard(conn)
+		return
+	}
+	p.Release(conn)
+	return
+}
+
+// Len returns the count of connections, idle or in use.
+func (p *ClientPool) Len() int {
+	return len(p.slots)
+}
+
+// Close closes the idle connections, the ones in use are closed
+// when released.
+func (p *ClientPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	close(p.done)
+	p.mu.Unlock()
+
+	for _, ic := range idle {
+		p.Discard(ic.Conn)
+	}
+}
+
+func (p *ClientPool) expired(ic idleConn) bool {
+	return p.idleTimeout > 0 && time.Since(ic.since) > p.idleTimeout
+}
+
+func (p *ClientPool) reapLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p.reap()
+		}
+	}
+}
+
+// reap discards the expired idle connections, but keeps min ones.
+func (p *ClientPool) reap() {
+	p.mu.Lock()
+	var expired []net.Conn
+	kept := p.idle[:0]
+	for _, ic := range p.idle {
+		if p.expired(ic) && len(p.slots)-len(expired) > p.min {
+			expired = append(expired, ic.Conn)
+			continue
+		}
+		kept = append(kept, ic)
+	}
+	p.idle = kept
+	p.mu.Unlock()
+
+	for _, conn := range expired {
+		p.Discard(conn)
+	}
+}
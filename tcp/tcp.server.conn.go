@@ -0,0 +1,587 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	tls2 "crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/hedzr/go-socketlib/ringbuf"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn wraps an accepted net.Conn with its per-connection states.
+//
+// Conn implements net.Conn, so it is the object passed into the
+// server callbacks (OnTcpServerCreateReadWriter,
+// OnTcpServerConnectedWithClient, ...). A callback can type-assert
+// it back to *tcp.Conn to retrieve the extra states:
+//
+//	func onCreateReadWriter(ss *tcp.Server, conn net.Conn, ts time.Time) (in io.Reader, out io.Writer) {
+//	    if c, ok := conn.(*tcp.Conn); ok {
+//	        log.Printf("user: %v", c.Identity())
+//	    }
+//	    ...
+//	}
+type Conn struct {
+	lastActive int64 // unix nano of the last successful read/write
+	bytesIn    uint64
+	bytesOut   uint64
+	net.Conn
+	raw         net.Conn // the connection of the transport, see NetConn
+	tlsConn     *tls2.Conn
+	id          string
+	server      *Server
+	ctx         context.Context
+	tsConnected time.Time
+	identity    interface{}
+	peerCert    *x509.Certificate
+	protocol    string // negotiated by ALPN
+	proxySrc    net.Addr
+	proxyDst    net.Addr
+	listenAddr  net.Addr // of the listener accepted it
+	deadlines   int32    // 1: apply the read/write/idle timeouts of server
+	sendOnce    sync.Once
+	sendQueue   ringbuf.RingBuffer
+	sendDone    chan struct{}   // closed after writeLoop returned
+	congested   int32           // 1: above the low-water mark since the high-water one crossed
+	framing     bool            // decoding a message, see WithServerFrameReadTimeout
+	readCtx     context.Context // bounds Read, see ReadFull
+	frameStart  time.Time       // when the first bytes of the message arrived
+	codecMu     sync.Mutex
+	codec       Codec          // switched by SetCodec, nil for the server's
+	recodec     bool           // SetCodec called since the decoder built
+	out         *messageWriter // of serveMessages
+	linger      int32          // of SetLinger, lingerUnset for the server's
+}
+
+// lingerUnset marks Conn.linger not overridden.
+const lingerUnset = -1 << 31
+
+func newConn(s *Server, conn net.Conn, tsConnected time.Time) *Conn {
+	return &Conn{
+		Conn:        conn,
+		id:          strconv.FormatUint(atomic.AddUint64(&s.nextConnID, 1), 10),
+		server:      s,
+		tsConnected: tsConnected,
+		lastActive:  tsConnected.UnixNano(),
+		linger:      lingerUnset,
+	}
+}
+
+// Close closes the connection, under SO_LINGER of SetLinger or
+// WithServerLinger if set.
+func (c *Conn) Close() error {
+	c.applyLinger()
+	return c.Conn.Close()
+}
+
+// SetLinger overrides WithServerLinger for this connection, such as
+// 0 for an abortive close on a protocol error. It takes effect when
+// the connection is being closed, and negative means the OS default
+// behaviour.
+func (c *Conn) SetLinger(seconds int) {
+	atomic.StoreInt32(&c.linger, int32(seconds))
+}
+
+func (c *Conn) applyLinger() {
+	sec := int(atomic.LoadInt32(&c.linger))
+	if sec == lingerUnset {
+		if c.server.sock.linger == nil {
+			return
+		}
+		sec = *c.server.sock.linger
+	}
+	if tc, ok := c.raw.(*net.TCPConn); ok {
+		if err := tc.SetLinger(sec); err != nil && !errors.Is(err, net.ErrClosed) {
+			c.server.Warnf("conn(from: %v) setting linger %v failed: %v", c.RemoteAddr(), sec, err)
+		}
+	}
+}
+
+// Read reads from the underlying connection, under the deadline
+// set by WithServerReadTimeout, WithServerIdleTimeout and
+// WithServerFrameReadTimeout, and the one of ReadFull.
+func (c *Conn) Read(b []byte) (n int, err error) {
+	timeouts := atomic.LoadInt32(&c.deadlines) == 1 && (c.server.readTimeout > 0 || c.server.idleTimeout > 0 || c.framing)
+	if !timeouts && c.readCtx == nil {
+		n, err = c.Conn.Read(b)
+		c.received(n)
+		return
+	}
+
+	for {
+		var deadline, idleAt time.Time
+		if timeouts && c.server.readTimeout > 0 {
+			deadline = time.Now().Add(c.server.readTimeout)
+		}
+		if timeouts && c.server.idleTimeout > 0 {
+			idleAt = c.LastActive().Add(c.server.idleTimeout)
+			if deadline.IsZero() || idleAt.Before(deadline) {
+				deadline = idleAt
+			}
+		}
+		if !c.frameStart.IsZero() {
+			if frameAt := c.frameStart.Add(c.server.frameTimeout); deadline.IsZero() || frameAt.Before(deadline) {
+				deadline = frameAt
+			}
+		}
+		if c.readCtx != nil {
+			if d, ok := c.readCtx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+				deadline = d
+			}
+		}
+		if err = c.Conn.SetReadDeadline(deadline); err != nil {
+			return
+		}
+		if c.readCtx != nil && c.readCtx.Err() != nil {
+			return 0, c.readCtx.Err() // cancelled before the deadline above took place
+		}
+
+		n, err = c.Conn.Read(b)
+		c.received(n)
+		if n > 0 && c.framing && c.frameStart.IsZero() {
+			c.frameStart = time.Now()
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && n == 0 && deadline.Equal(idleAt) &&
+			c.LastActive().Add(c.server.idleTimeout).After(idleAt) {
+			continue // the connection was active by writing, rearm the idle timer
+		}
+		return
+	}
+}
+
+// ReadFull reads exactly len(buf) bytes from the connection, across
+// as many reads as needed, for the handlers doing their own framing
+// without WithServerCodec. It returns the bytes read so far with the
+// error if it failed halfway: ctx.Err() if ctx is cancelled or its
+// deadline exceeded, the timeout of WithServerReadTimeout or
+// WithServerIdleTimeout, or io.ErrUnexpectedEOF if the peer closed
+// the connection in the middle (io.EOF if nothing was read).
+//
+// It reads the connection directly, so it mustn't be mixed with the
+// buffered reader of OnTcpServerCreateReadWriter, which may have
+// taken the bytes ahead.
+func (c *Conn) ReadFull(ctx context.Context, buf []byte) (n int, err error) {
+	c.readCtx = ctx
+	stop, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			_ = c.Conn.SetReadDeadline(time.Unix(1, 0)) // interrupts the pending Read
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-exited
+		c.readCtx = nil
+		_ = c.Conn.SetReadDeadline(time.Time{}) // the next Read sets its own
+	}()
+
+	for n < len(buf) && err == nil {
+		var nn int
+		nn, err = c.Read(buf[n:])
+		n += nn
+	}
+	if n == len(buf) {
+		return n, nil
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			err = context.DeadlineExceeded // the read deadline fired ahead of ctx
+		}
+	}
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// Write writes to the underlying connection, under the deadline set
+// by WithServerWriteTimeout.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	if atomic.LoadInt32(&c.deadlines) == 1 && c.server.writeTimeout > 0 {
+		if err = c.Conn.SetWriteDeadline(time.Now().Add(c.server.writeTimeout)); err != nil {
+			return
+		}
+	}
+	n, err = c.Conn.Write(b)
+	if n > 0 {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		atomic.AddUint64(&c.server.bytesWritten, uint64(n))
+		c.touch()
+	}
+	return
+}
+
+// beginFrame starts timing the message decoded from r, which begins
+// at once if r has buffered some bytes, or at the next bytes read.
+func (c *Conn) beginFrame(r io.Reader) {
+	c.framing = true
+	if br, ok := r.(interface{ Buffered() int }); ok && br.Buffered() > 0 {
+		c.frameStart = time.Now()
+	}
+}
+
+// endFrame stops timing the message, and reports whether it has run
+// out of the time of WithServerFrameReadTimeout.
+func (c *Conn) endFrame() (timedOut bool) {
+	if c.framing && !c.frameStart.IsZero() {
+		timedOut = time.Since(c.frameStart) >= c.server.frameTimeout
+	}
+	c.framing, c.frameStart = false, time.Time{}
+	return
+}
+
+func (c *Conn) received(n int) {
+	if n > 0 {
+		atomic.AddUint64(&c.bytesIn, uint64(n))
+		atomic.AddUint64(&c.server.bytesRead, uint64(n))
+		c.touch()
+	}
+}
+
+// Send puts msg into the outbound queue of the connection without
+// blocking, ringbuf.ErrQueueFull returned if the peer is too slow
+// to keep up. A dedicated goroutine drains the queue to the socket,
+// encoding each msg with the codec if WithServerCodec specified.
+//
+// Since the messages are written by another goroutine, don't mix
+// Send with writing through the writer passed to the handlers.
+//
+// The messages queued are written out before the connection is
+// closed, including the ones sent right before the handler returned
+// and the ones sent during the graceful shutdown (see
+// StopWithTimeout). ErrConnClosed (wrapping ringbuf.ErrClosed)
+// returned after the connection finished, and ErrMemoryBudget if
+// msg doesn't fit in the budget of WithServerMemoryBudget.
+func (c *Conn) Send(msg []byte) (err error) {
+	if err = c.server.budget.tryAcquire(len(msg)); err != nil {
+		return
+	}
+	q := c.queue()
+	if err = q.Enqueue(msg); err == nil {
+		c.backpressure(q)
+	} else {
+		c.server.budget.release(len(msg))
+	}
+	return c.closedErr(err)
+}
+
+// SendContext puts msg into the outbound queue like Send, but waits
+// for the free room, and the memory budget, until ctx done.
+func (c *Conn) SendContext(ctx context.Context, msg []byte) (err error) {
+	if err = c.server.budget.acquire(ctx, len(msg)); err != nil {
+		return
+	}
+	q := c.queue()
+	if err = q.BlockingEnqueue(ctx, msg); err == nil {
+		c.backpressure(q)
+	} else {
+		c.server.budget.release(len(msg))
+	}
+	return c.closedErr(err)
+}
+
+// backpressure fires the callbacks of WithServerOnBackpressure and
+// WithServerOnBackpressureCleared if the depth of q crossed the
+// water marks.
+func (c *Conn) backpressure(q ringbuf.RingBuffer) {
+	s := c.server
+	if s.onBackpressure == nil && s.onBackpressureCleared == nil {
+		return
+	}
+
+	depth, capacity := q.Quantity(), q.Cap()
+	if atomic.LoadInt32(&c.congested) == 0 {
+		if float64(depth) >= s.highWater*float64(capacity) && atomic.CompareAndSwapInt32(&c.congested, 0, 1) && s.onBackpressure != nil {
+			s.onBackpressure(c.id, depth, capacity)
+		}
+	} else if float64(depth) <= s.lowWater*float64(capacity) && atomic.CompareAndSwapInt32(&c.congested, 1, 0) && s.onBackpressureCleared != nil {
+		s.onBackpressureCleared(c.id, depth, capacity)
+	}
+}
+
+// closedErr wraps ringbuf.ErrClosed into ErrConnClosed.
+func (c *Conn) closedErr(err error) error {
+	if err == ringbuf.ErrClosed {
+		return &OpError{Op: ErrConnClosed, Addr: c.RemoteAddr().String(), Err: err}
+	}
+	return err
+}
+
+// queue creates the outbound queue and its writer on the first use.
+func (c *Conn) queue() ringbuf.RingBuffer {
+	c.sendOnce.Do(func() {
+		c.sendQueue = newSendQueue(c.server.sendQueueSize)
+		c.sendDone = make(chan struct{})
+		go c.writeLoop(c.sendQueue)
+	})
+	return c.sendQueue
+}
+
+// finishSending closes the outbound queue, and waits for the writer
+// to write out the messages queued, before the connection closed. A
+// peer not reading holds it until WithServerWriteTimeout elapsed or
+// the connection closed by StopWithTimeout.
+func (c *Conn) finishSending() {
+	c.sendOnce.Do(func() {
+		c.sendQueue = newRingBuf(1) // never used, Send fails from now on
+	})
+	_ = c.sendQueue.CloseWrite()
+	if c.sendDone != nil {
+		<-c.sendDone
+	}
+}
+
+// writeLoop writes the messages queued until q closed by
+// finishSending and drained, or the writing failed. It doesn't stop
+// on the cancellation of the context of the connection, so that the
+// messages sent during the graceful shutdown are delivered.
+func (c *Conn) writeLoop(q ringbuf.RingBuffer) {
+	defer func() {
+		_ = q.CloseWrite()
+		for _, it := range q.Drain() {
+			c.server.budget.release(len(it.([]byte)))
+		}
+		close(c.sendDone)
+	}()
+
+	for {
+		it, err := q.BlockingDequeue(context.Background())
+		if err != nil {
+			return // ErrClosed
+		}
+
+		c.backpressure(q)
+		msg := it.([]byte)
+		if codec := c.currentCodec(); codec != nil {
+			err = codec.Encode(c, msg)
+		} else {
+			_, err = c.Write(msg)
+		}
+		c.server.budget.release(len(msg))
+		if err != nil {
+			c.server.Warnf("conn(from: %v) sending queued message failed, closing: %v", c.RemoteAddr(), err)
+			_ = c.Close()
+			return
+		}
+	}
+}
+
+func (c *Conn) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+// LastActive returns the time of the last successful read or write.
+func (c *Conn) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActive))
+}
+
+// BytesRead returns the count of bytes read from this connection so
+// far, after the decryption and decompression, including the ones
+// still buffered by the reader.
+//
+// It's safe to be called concurrently, and never reset: the count is
+// monotonic over the lifetime of the connection, so a handler can
+// take the delta between two calls.
+func (c *Conn) BytesRead() uint64 {
+	return atomic.LoadUint64(&c.bytesIn)
+}
+
+// BytesWritten returns the count of bytes written to this connection
+// so far, before the compression and encryption, including the ones
+// still buffered by the writer. It's monotonic as BytesRead.
+func (c *Conn) BytesWritten() uint64 {
+	return atomic.LoadUint64(&c.bytesOut)
+}
+
+// ID returns the identifier of this connection, which is unique
+// within the server.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// Server returns the server which accepted this connection.
+func (c *Conn) Server() *Server {
+	return c.server
+}
+
+// Context returns the context of this connection, it's cancelled
+// once the connection closed or the server is shutting down.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// SetCodec switches the framing of the connection to codec, such as
+// from a line-based handshake to the length-prefixed messages. The
+// messages written (through the MessageWriter or Send) since then
+// are encoded by codec, and the ones read after the current message
+// are decoded by it. A nil codec restores the one of
+// WithServerCodec. It's for the message handlers
+// (OnTcpServerMessageFunc), which reach the connection by
+// ConnFromContext:
+//
+//	func onMessage(ctx context.Context, msg []byte, out tcp.MessageWriter) error {
+//	    if string(msg) == "STARTBIN" {
+//	        tcp.ConnFromContext(ctx).SetCodec(tcp.NewLengthPrefixedCodec(4, 0))
+//	    }
+//	    ...
+//	}
+//
+// The bytes following the current message, which may have been
+// read into the buffered reader already, are kept for codec, since
+// the codecs decode from the same reader and none of the builtin
+// ones reads past the end of its message. A custom Codec must not
+// read ahead either.
+func (c *Conn) SetCodec(codec Codec) {
+	codec = limitCodec(codec, c.server.maxMessageSize)
+	c.codecMu.Lock()
+	c.codec, c.recodec = codec, true
+	out := c.out
+	c.codecMu.Unlock()
+	if out != nil {
+		out.setCodec(c.currentCodec())
+	}
+}
+
+// currentCodec returns the codec of SetCodec, or the server's.
+func (c *Conn) currentCodec() Codec {
+	c.codecMu.Lock()
+	defer c.codecMu.Unlock()
+	if c.codec != nil {
+		return c.codec
+	}
+	return c.server.codec
+}
+
+// newMessageWriter returns the writer of the messages to w with the
+// current codec, which is switched by SetCodec later.
+func (c *Conn) newMessageWriter(w io.Writer) *messageWriter {
+	c.codecMu.Lock()
+	defer c.codecMu.Unlock()
+	c.out = &messageWriter{codec: c.server.codec, w: w}
+	if c.codec != nil {
+		c.out.codec = c.codec
+	}
+	return c.out
+}
+
+// codecSwitched reports whether SetCodec has been called since the
+// last call, and returns the current codec.
+func (c *Conn) codecSwitched() (codec Codec, switched bool) {
+	c.codecMu.Lock()
+	switched, c.recodec = c.recodec, false
+	c.codecMu.Unlock()
+	return c.currentCodec(), switched
+}
+
+// connKey is the key of the *Conn in the context of the connection.
+type connKey struct{}
+
+// ConnFromContext returns the connection whose context (see
+// Conn.Context) is ctx or derived from it, so that the message
+// handlers (OnTcpServerMessageFunc) can reach the connection. nil
+// returned if ctx doesn't belong to a connection.
+func ConnFromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(connKey{}).(*Conn)
+	return c
+}
+
+// NetConn returns the connection accepted by the transport, under
+// the TLS, compression and PROXY protocol layers, such as the
+// *net.TCPConn, for the advanced uses: the socket options, File()
+// to pass it to another process, and so on.
+//
+// Reading or writing it directly bypasses everything above it: the
+// bytes are neither encrypted nor compressed, and they are mixed up
+// with the framed messages of the codec, the data buffered by the
+// reader and the writer (see WithServerWriteFlushInterval) and the
+// send queue of Send. Do it only when the connection is idle or
+// about to be handed over, and never over TLS.
+func (c *Conn) NetConn() net.Conn {
+	return c.raw
+}
+
+// TLSConn returns the TLS connection, under the compression layer
+// (see WithServerCompression), or nil if the connection isn't TLS.
+// It's for the states not covered by PeerCertificate and
+// NegotiatedProtocol, see ConnectionState. The caveats of NetConn
+// apply to its Read and Write too, except the encryption.
+func (c *Conn) TLSConn() *tls2.Conn {
+	return c.tlsConn
+}
+
+// ConnectedAt returns the time (UTC) at which the connection was accepted.
+func (c *Conn) ConnectedAt() time.Time {
+	return c.tsConnected
+}
+
+// Identity returns the identity which was attached by the
+// authenticator (see WithServerAuthenticator), or nil if no
+// authenticator was configured.
+func (c *Conn) Identity() interface{} {
+	return c.identity
+}
+
+// PeerCertificate returns the certificate presented by the client
+// over mutual TLS (see WithServerClientCAs), so that the handlers
+// can authorize by its Subject.CommonName or DNSNames. nil returned
+// if the connection isn't TLS or no client certificate was sent.
+func (c *Conn) PeerCertificate() *x509.Certificate {
+	return c.peerCert
+}
+
+// NegotiatedProtocol returns the application protocol negotiated
+// by TLS ALPN, see WithServerALPNProtocols, or "" if none.
+func (c *Conn) NegotiatedProtocol() string {
+	return c.protocol
+}
+
+// ConnInfo is the snapshot of the states of a connection, see
+// Server.Conns.
+type ConnInfo struct {
+	ID          string
+	RemoteAddr  net.Addr
+	ConnectedAt time.Time
+	LastActive  time.Time
+	BytesIn     uint64
+	BytesOut    uint64
+	// NegotiatedProtocol is the protocol negotiated by TLS ALPN.
+	NegotiatedProtocol string
+	// ProxySource and ProxyDestination are the addresses carried by
+	// the PROXY header (see WithServerProxyProtocol), or nil.
+	ProxySource      net.Addr
+	ProxyDestination net.Addr
+	// ListenAddr is the address of the listener which accepted the
+	// connection, see WithServerListenAddrs.
+	ListenAddr net.Addr
+}
+
+// Info returns the snapshot of the states of this connection.
+func (c *Conn) Info() ConnInfo {
+	return ConnInfo{
+		ID:                 c.id,
+		RemoteAddr:         c.RemoteAddr(),
+		ConnectedAt:        c.tsConnected,
+		LastActive:         c.LastActive(),
+		BytesIn:            c.BytesRead(),
+		BytesOut:           c.BytesWritten(),
+		NegotiatedProtocol: c.protocol,
+		ProxySource:        c.proxySrc,
+		ProxyDestination:   c.proxyDst,
+		ListenAddr:         c.listenAddr,
+	}
+}
@@ -12,19 +12,48 @@ import (
 
 type ServerOpt func(*Server)
 type ClientOpt func(*Client)
+type UDPServerOpt func(*UDPServer)
+type PoolOpt func(*ClientPool)
 
-func StartServer(addr string, opts ...ServerOpt) *Server {
-	s := newServer(addr, opts...)
-	if err := s.Start(); err != nil {
+// NewServer returns a Server to listen on addr, which is started by
+// Server.Start or Server.Run.
+func NewServer(addr string, opts ...ServerOpt) (s *Server, err error) {
+	return newServer(addr, opts...)
+}
+
+// StartServer starts a Server listening on addr. The error, such as
+// the port is in use or addr is malformed, is returned rather than a
+// dead Server, test it with errors.Is(err, ErrListen).
+func StartServer(addr string, opts ...ServerOpt) (s *Server, err error) {
+	if s, err = newServer(addr, opts...); err != nil {
+		return
+	}
+	if err = s.Start(); err != nil {
 		s.Errorf("can't start tcp server (addr=%v): %v", addr, err)
+		return nil, err
 	}
-	return s
+	return
 }
 
 func StopServer(s *Server) {
 	s.Stop()
 }
 
+// StartUDPServer starts a UDPServer listening on addr. Like
+// StartServer, the error is returned rather than a dead UDPServer.
+func StartUDPServer(addr string, opts ...UDPServerOpt) (s *UDPServer, err error) {
+	s = newUDPServer(addr, opts...)
+	if err = s.Start(); err != nil {
+		s.Errorf("can't start udp server (addr=%v): %v", addr, err)
+		return nil, err
+	}
+	return
+}
+
+func StopUDPServer(s *UDPServer) {
+	s.Stop()
+}
+
 // HandleSignals returns a waiter function to listen some predefined os signals.
 //
 //
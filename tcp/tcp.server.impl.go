@@ -6,25 +6,125 @@ package tcp
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	tls2 "crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"github.com/hedzr/go-socketlib/tcp/tls"
 	"io"
 	"net"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// ErrConnNotFound is returned by Server.CloseConn if no connection
+// has the given id.
+var ErrConnNotFound = errors.New("connection not found")
+
+// ErrReusePortUnsupported is returned by Server.Start if
+// WithServerReusePort is specified on the platform which doesn't
+// support SO_REUSEPORT.
+var ErrReusePortUnsupported = errors.New("SO_REUSEPORT is not supported on this platform")
+
+// ErrCertNotReloadable is returned by Server.ReloadCert if the
+// server isn't serving TLS with WithServerTLS or WithServerTLSFiles.
+var ErrCertNotReloadable = errors.New("certificate is not reloadable")
+
+// PanicError is the error passed to the hook of
+// WithServerOnDisconnect if the handler of the connection panicked.
+type PanicError struct {
+	Value interface{} // the value passed to panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 const (
 	DefaultBufferSize = 4096
+	// DefaultSendQueueSize is the capacity of the outbound queue of
+	// each connection, see Conn.Send.
+	DefaultSendQueueSize = 256
+	// DefaultHighWater and DefaultLowWater are the water marks of
+	// WithServerOnBackpressure, as the fractions of the capacity of
+	// the outbound queue.
+	DefaultHighWater = 0.75
+	DefaultLowWater  = 0.25
+	// DefaultAcceptBackoffMin and DefaultAcceptBackoffMax are the
+	// bounds of the delay after Accept failed, see
+	// WithServerAcceptBackoff.
+	DefaultAcceptBackoffMin = 5 * time.Millisecond
+	DefaultAcceptBackoffMax = time.Second
+	// DefaultShutdownTimeout is how long Stop waits for the active
+	// connections, see WithServerShutdownTimeout.
+	DefaultShutdownTimeout = 30 * time.Second
+	// DefaultProxyHeaderTimeout is how long a connection may take to
+	// send its PROXY header, see WithServerProxyHeaderTimeout.
+	DefaultProxyHeaderTimeout = 5 * time.Second
+	// DefaultTLSHandshakeTimeout is how long a TLS connection may
+	// take to complete the handshake, see
+	// WithServerTLSHandshakeTimeout.
+	DefaultTLSHandshakeTimeout = 10 * time.Second
 )
 
+// acceptLogInterval throttles the logs of the accepting errors.
+const acceptLogInterval = time.Second
+
 type OnTcpServerCreateReadWriter func(ss *Server, conn net.Conn, tsConnected time.Time) (in io.Reader, out io.Writer)
 type OnTcpServerConnectedWithClient func(ss *Server, conn net.Conn)
 type OnTcpServerDisconnectedWithClient func(ss *Server, conn net.Conn, reader io.Reader)
-type OnTcpServerProcessFunc func(buf []byte, in io.Reader, out io.Writer) (nn int, err error)
+
+// OnTcpServerListening is called when the server starts accepting
+// from l, once for each address, see WithServerListenAddrs.
 type OnTcpServerListening func(ss *Server, l net.Listener)
 
+// OnTcpServerProcessFunc processes the data read from a connection.
+//
+// ctx is cancelled once the connection closed (by the peer, or
+// timed out) or the server is shutting down, so a blocking handler
+// should select on ctx.Done() to give up cooperatively.
+type OnTcpServerProcessFunc func(ctx context.Context, buf []byte, in io.Reader, out io.Writer) (nn int, err error)
+
+// OnTcpServerMessageFunc processes a whole message decoded by the
+// Codec specified with WithServerCodec, and can reply through out.
+// Returning an error closes the connection.
+type OnTcpServerMessageFunc func(ctx context.Context, msg []byte, out MessageWriter) (err error)
+
+// OnTcpServerAuthenticate runs the authentication phase of a
+// connection, before the reading loop started.
+//
+// It can talk with the peer through conn directly (read the
+// credentials, write the challenge, ...). Returning an error
+// rejects and closes the connection; the returned identity will
+// be attached to the connection, see also Conn.Identity().
+type OnTcpServerAuthenticate func(conn *Conn) (identity interface{}, err error)
+
+// OverLimitPolicy decides what to do with the new connections once
+// the limit set by WithServerMaxConnections is reached.
+type OverLimitPolicy int
+
+const (
+	// OverLimitReject closes the new connection right after accepted.
+	OverLimitReject OverLimitPolicy = iota
+	// OverLimitWait holds the new connection until an active one
+	// finished, the further ones are left in the listen backlog.
+	OverLimitWait
+)
+
 // Processor 代表在reader处理读取到到报文的同时会立即进行报文的处理。
 //
 // Reader负责从读取的报文数据块中按照协议进行分包，切分成功
@@ -38,64 +138,162 @@ type Processor interface {
 }
 
 type Server struct {
-	host        string
-	port        int
-	l           net.Listener
+	accepted          uint64 // the counters of Stats, 64-bit aligned for atomic
+	acceptErrors      uint64
+	bytesRead         uint64
+	bytesWritten      uint64
+	panics            uint64
+	frameTimeouts     uint64
+	handshakeTimeouts uint64
+	filtered          uint64
+	authAccepted      uint64
+	authRejected      uint64
+
+	addr        string
+	addrs       []string // the extra ones, see WithServerListenAddrs
+	network     string   // tcp, tcp4 or tcp6
+	ls          []*listener
 	done        chan struct{}
-	wg          sync.WaitGroup
-	exitingFlag bool
+	loops       sync.WaitGroup
+	loopDone    chan struct{} // closed after all runLoop returned
+	acceptErr   error         // why the first runLoop returned, nil if stopped
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup     // active connections
+	exitingFlag bool               // guarded by connsMu, see exiting
+	conns       map[net.Conn]*Conn // nil value until the handshake finished
+	connsByID   map[string]*Conn
+	nextConnID  uint64
+	connsMu     sync.Mutex
+	slots       chan struct{} // for WithServerMaxConnections
+	overLimit   OverLimitPolicy
 
 	base
 
 	CmdrTlsConfig *tls.CmdrTlsConfig
 
+	tlsConfig     *tls2.Config
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsClientCAs  *x509.CertPool
+	tlsClientAuth tls2.ClientAuthType
+	alpn          []string
+	cert          atomic.Value // *tls2.Certificate, see ReloadCert
+	tlsHardening  tlsOpts
+
 	bufferSize                        int
+	sock                              sockOpts
+	reusePort                         bool
+	inheritFD                         bool
+	proxyProtocol                     bool
+	wsUpgrade                         bool
+	compression                       Compression
+	transport                         Transport
+	sendQueueSize                     uint32
+	highWater                         float64 // the fractions of sendQueueSize
+	lowWater                          float64
+	acceptBackoffMin                  time.Duration
+	acceptBackoffMax                  time.Duration
+	onBackpressure                    func(connID string, queueDepth, cap uint32)
+	onBackpressureCleared             func(connID string, queueDepth, cap uint32)
+	readPool                          *BufferPool
+	flushInterval                     time.Duration
 	onTcpProcess                      OnTcpServerProcessFunc
+	onTcpMessage                      OnTcpServerMessageFunc
+	codec                             Codec
+	budget                            *memBudget
+	health                            *healthProbe
+	acceptFilter                      func(remote net.Addr) bool
+	maxMessageSize                    int
+	hbInterval                        time.Duration
+	hbPing                            []byte
+	hbPong                            []byte
 	onTcpServerCreateReadWriter       OnTcpServerCreateReadWriter
 	onTcpServerConnectedWithClient    OnTcpServerConnectedWithClient
 	onTcpServerDisconnectedWithClient OnTcpServerDisconnectedWithClient
 	onTcpServerListening              OnTcpServerListening
+	onTcpServerAuthenticate           OnTcpServerAuthenticate
+	onConnect                         func(info ConnInfo)
+	onDisconnect                      func(info ConnInfo, err error)
+	onPanic                           func(connID string, r interface{})
+	authTimeout                       time.Duration
+	shutdownTimeout                   time.Duration
+	readTimeout                       time.Duration
+	writeTimeout                      time.Duration
+	idleTimeout                       time.Duration
+	frameTimeout                      time.Duration
+	tlsHandshakeTimeout               time.Duration
+	proxyHeaderTimeout                time.Duration
 }
 
-func newServer(addr string, opts ...ServerOpt) *Server {
-	s := &Server{
-		base:       newBase(nil),
-		done:       make(chan struct{}),
-		bufferSize: DefaultBufferSize,
-	}
+func newServer(addr string, opts ...ServerOpt) (s *Server, err error) {
+	s = &Server{
+		base:          newBase(nil),
+		done:          make(chan struct{}),
+		network:       "tcp",
+		bufferSize:    DefaultBufferSize,
+		sendQueueSize: DefaultSendQueueSize,
+		highWater:     DefaultHighWater,
+		lowWater:      DefaultLowWater,
 
-	var port string
-	var err error
-	s.host, port, err = net.SplitHostPort(addr)
-	if err != nil {
-		s.Errorf("can't split addr to host & port: %v", err)
-		return nil
-	}
-	s.port, err = strconv.Atoi(port)
-	if err != nil {
-		s.Errorf("can't parse port to integer: %v", err)
-		return nil
+		acceptBackoffMin: DefaultAcceptBackoffMin,
+		acceptBackoffMax: DefaultAcceptBackoffMax,
+		shutdownTimeout:  DefaultShutdownTimeout,
+
+		proxyHeaderTimeout:  DefaultProxyHeaderTimeout,
+		tlsHandshakeTimeout: DefaultTLSHandshakeTimeout,
 	}
 
+	s.addr = addr
+
 	for _, opt := range opts {
 		opt(s)
 	}
-	return s
+	s.codec = limitCodec(s.codec, s.maxMessageSize)
+
+	for _, a := range append([]string{addr}, s.addrs...) {
+		if t, _ := s.transportFor(a); t != nil {
+			continue
+		}
+		var port string
+		if _, port, err = net.SplitHostPort(a); err != nil {
+			s.Errorf("can't split addr to host & port: %v", err)
+			return nil, &OpError{Op: ErrListen, Addr: a, Err: err}
+		}
+		if _, err = strconv.Atoi(port); err != nil {
+			s.Errorf("can't parse port to integer: %v", err)
+			return nil, &OpError{Op: ErrListen, Addr: a, Err: err}
+		}
+	}
+	return
+}
+
+// transportFor returns the Transport to listen on addr and the
+// address for it, nil if it's the default TCPTransport. The addr
+// prefixed by "unix:" is the path of a Unix domain socket.
+func (s *Server) transportFor(addr string) (t Transport, a string) {
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		return &UnixTransport{}, strings.TrimPrefix(addr, unixAddrPrefix)
+	}
+	return s.transport, addr
 }
 
 func (s *Server) defaultCreateReadWriter(ss *Server, conn net.Conn, tsConnected time.Time) (in io.Reader, out io.Writer) {
 	in = bufio.NewReader(conn)
-	out = bufio.NewWriter(conn)
+	out = newBufferedWriter(conn, s.flushInterval)
 	// o = conn // bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
 	return
 }
 
 func (s *Server) Start() (err error) {
+	s.connsMu.Lock()
 	s.exitingFlag = false
-
 	if s.done == nil {
 		s.done = make(chan struct{})
 	}
+	s.connsMu.Unlock()
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	if s.onTcpProcess == nil {
 		s.onTcpProcess = s.defaultProcess
@@ -105,55 +303,457 @@ func (s *Server) Start() (err error) {
 		s.onTcpServerCreateReadWriter = s.defaultCreateReadWriter
 	}
 
-	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
-	// var l net.Listener
-	s.l, err = net.Listen("tcp", addr)
-	if err != nil {
-		s.Errorf("error listening: addr=%v: %v", addr, err)
-		return // os.Exit(1)
-	}
 	// NOTE NOTE NOTE: we ignore s.InitTlsConfigFromConfigFile() NOW because it has been done by via tcp.NewCmdrTlsConfig()
-	if s.CmdrTlsConfig.IsCertValid() {
-		s.l, err = s.CmdrTlsConfig.NewTlsListener(s.l)
-		if err != nil {
-			s.Errorf("error listening over TLS: addr=%v: %v", addr, err)
+	if s.tlsCertFile != "" || s.tlsConfig != nil {
+		if err = s.buildTlsConfig(); err != nil {
+			err = &OpError{Op: ErrListen, Addr: s.addr, Err: err}
+			s.Errorf("error loading TLS certificate: %v", err)
+			return
+		}
+	}
+
+	s.ls = nil
+	for i, addr := range append([]string{s.addr}, s.addrs...) {
+		var ln *listener
+		if ln, err = s.listen(addr, i == 0 && s.inheritFD); err != nil {
+			for _, ln = range s.ls {
+				_ = ln.Close()
+			}
+			s.ls = nil
 			return // os.Exit(1)
 		}
-		s.Debugf("A tcp server listening on %v (over TLS)", addr)
-	} else {
-		// defer l.Close()
-		s.Debugf("A tcp server listening on %v", addr)
+		s.ls = append(s.ls, ln)
+	}
+	if s.health != nil {
+		if err = s.startHealthProbe(); err != nil {
+			for _, ln := range s.ls {
+				_ = ln.Close()
+			}
+			s.ls = nil
+			return
+		}
 	}
-	// s.debug("  > listening on %v", addr)
-	// s.debug("    > listening on %v", addr)
 
 	// s.wg.Add(2)
 	// go s.handleWrite(s.conn, &s.wg)
 	// go s.handleRead(s.conn, &s.wg)
 	// s.wg.Wait()
 
-	go s.runLoop(s.l, s.done)
+	s.acceptErr, s.loopDone = nil, make(chan struct{})
+	s.loops.Add(len(s.ls))
+	for _, ln := range s.ls {
+		go s.runLoop(ln, s.done)
+	}
+	go func(loopDone chan struct{}) {
+		s.loops.Wait()
+		close(loopDone)
+	}(s.loopDone)
 	return
 }
 
+// Addr returns the address actually bound for the addr of
+// NewServer, so that the port picked by the OS for ":0" can be
+// learned, such as to advertise it or to connect to it in a test:
+//
+//	s, _ := tcp.NewServer("127.0.0.1:0")
+//	if err := s.Start(); err == nil {
+//	    conn, _ := net.Dial("tcp", s.Addr().String())
+//	    ...
+//	}
+//
+// It must be called after Start succeeded, nil returned before
+// that.
+func (s *Server) Addr() net.Addr {
+	if len(s.ls) == 0 {
+		return nil
+	}
+	return s.ls[0].Addr()
+}
+
+// listener is one of the addresses which the server listening on,
+// see WithServerListenAddrs. The embedded net.Listener is the
+// outermost one to accept from, which wraps the others.
+type listener struct {
+	net.Listener
+	addr    string
+	raw     net.Listener // the listener of the transport
+	tuningL *tuningListener
+	proxyL  *proxyListener
+}
+
+// listen announces on addr, or takes the inherited listener if
+// inherit is set, and wraps it for the socket options, the PROXY
+// protocol and TLS.
+func (s *Server) listen(addr string, inherit bool) (ln *listener, err error) {
+	ln = &listener{addr: addr}
+	if inherit {
+		if ln.raw, err = inheritedListener(); err != nil {
+			err = &OpError{Op: ErrListen, Addr: addr, Err: err}
+			s.Errorf("error listening on the inherited fd: %v", err)
+			return
+		}
+	}
+	if ln.raw != nil {
+		s.Debugf("inherited the listener on %v", ln.raw.Addr())
+	} else {
+		t, a := s.transportFor(addr)
+		if t == nil {
+			t = &TCPTransport{Network: s.network, ReusePort: s.reusePort}
+		}
+		if ln.raw, err = t.Listen(a); err != nil {
+			err = &OpError{Op: ErrListen, Addr: addr, Err: err}
+			s.Errorf("error listening: %v", err)
+			return
+		}
+	}
+
+	ln.tuningL = &tuningListener{Listener: ln.raw, opts: &s.sock, logger: s.Logger}
+	ln.Listener = ln.tuningL
+	if s.proxyProtocol {
+		ln.proxyL = &proxyListener{Listener: ln.Listener}
+		ln.Listener = ln.proxyL
+	}
+	if s.tlsConfig != nil {
+		ln.Listener = tls2.NewListener(ln.Listener, s.tlsConfig)
+		s.Debugf("A tcp server listening on %v (over TLS)", addr)
+	} else if s.CmdrTlsConfig.IsCertValid() {
+		cfg := s.CmdrTlsConfig
+		if len(s.alpn) > 0 {
+			c := *cfg
+			c.NextProtos = s.alpn
+			cfg = &c
+		}
+		if ln.Listener, err = cfg.NewTlsListener(ln.Listener); err != nil {
+			_ = ln.raw.Close()
+			err = &OpError{Op: ErrListen, Addr: addr, Err: err}
+			s.Errorf("error listening over TLS: %v", err)
+			return
+		}
+		s.Debugf("A tcp server listening on %v (over TLS)", addr)
+	} else {
+		s.Debugf("A tcp server listening on %v", addr)
+	}
+	return
+}
+
+// buildTlsConfig loads the certificate specified by
+// WithServerTLSFiles, and applies the client verification settings
+// (WithServerClientCAs, WithServerClientAuth) into s.tlsConfig.
+func (s *Server) buildTlsConfig() (err error) {
+	if s.tlsConfig == nil {
+		s.tlsConfig = &tls2.Config{}
+	} else {
+		s.tlsConfig = s.tlsConfig.Clone()
+	}
+
+	if s.tlsCertFile != "" {
+		var cert tls2.Certificate
+		if cert, err = tls2.LoadX509KeyPair(s.tlsCertFile, s.tlsKeyFile); err != nil {
+			return
+		}
+		s.cert.Store(&cert)
+	} else if len(s.tlsConfig.Certificates) == 1 {
+		s.cert.Store(&s.tlsConfig.Certificates[0])
+		s.tlsConfig.Certificates = nil
+	}
+	getCertificate := s.tlsConfig.GetCertificate
+	s.tlsConfig.GetCertificate = func(hello *tls2.ClientHelloInfo) (*tls2.Certificate, error) {
+		if cert, _ := s.cert.Load().(*tls2.Certificate); cert != nil {
+			return cert, nil
+		}
+		if getCertificate != nil {
+			return getCertificate(hello)
+		}
+		return nil, nil // falls back to Certificates
+	}
+
+	if s.tlsClientCAs != nil {
+		s.tlsConfig.ClientCAs = s.tlsClientCAs
+		if s.tlsClientAuth == tls2.NoClientCert {
+			s.tlsClientAuth = tls2.RequireAndVerifyClientCert
+		}
+	}
+	if s.tlsClientAuth != tls2.NoClientCert {
+		s.tlsConfig.ClientAuth = s.tlsClientAuth
+	}
+	if len(s.alpn) > 0 {
+		s.tlsConfig.NextProtos = s.alpn
+	}
+	err = s.tlsHardening.apply(s.tlsConfig)
+	return
+}
+
+// ReloadCert loads the PEM encoded certificate and key files, and
+// serves the new TLS handshakes with them, such as after the
+// certificate renewed. The established connections keep using the
+// old one.
+//
+// It works for the server serving TLS with WithServerTLSFiles, or
+// WithServerTLS with one certificate, ErrCertNotReloadable returned
+// otherwise.
+func (s *Server) ReloadCert(certFile, keyFile string) (err error) {
+	if s.cert.Load() == nil {
+		return ErrCertNotReloadable
+	}
+
+	var cert tls2.Certificate
+	if cert, err = tls2.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return
+	}
+	s.cert.Store(&cert)
+	s.Infof("TLS certificate reloaded from %v", certFile)
+	return
+}
+
+// Stop shuts down the server gracefully, see StopWithTimeout and
+// WithServerShutdownTimeout.
 func (s *Server) Stop() {
+	s.StopWithTimeout(s.shutdownTimeout)
+}
+
+// Run starts the server like Start, and serves until ctx done, then
+// stops it like Stop. So it composes with signal.NotifyContext and
+// errgroup.Group:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+//	defer stop()
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error { return s.Run(ctx) })
+//
+// nil returned for a clean shutdown, including the server is stopped
+// by others, or the error of Start, or ErrAccept if the listener
+// failed.
+func (s *Server) Run(ctx context.Context) (err error) {
+	if err = s.Start(); err != nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-s.loopDone:
+		if err = s.acceptErr; err == nil {
+			return // stopped by others
+		}
+	}
+	s.Stop()
+	return
+}
+
+// StopWithTimeout stops accepting new connections, and waits up to
+// d for the active connections to be finished by the peers. The
+// remained connections are closed forcibly after d elapsed, or at
+// once if d is zero. A negative d waits for them indefinitely.
+func (s *Server) StopWithTimeout(d time.Duration) {
 	_ = s.Close()
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	if d < 0 {
+		<-finished
+		return
+	} else if d > 0 {
+		select {
+		case <-finished:
+			return
+		case <-time.After(d):
+			s.Warnf("graceful shutdown timed out after %v, closing the remained connections", d)
+		}
+	}
+
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for nc, conn := range s.conns {
+		if conn != nil {
+			_ = conn.Close() // under the linger
+		} else {
+			_ = nc.Close()
+		}
+	}
+}
+
+// exiting reports whether Close has been called since Start.
+func (s *Server) exiting() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return s.exitingFlag
+}
+
+// trackConn registers an accepted connection, false returned if the
+// server is stopping.
+func (s *Server) trackConn(nc net.Conn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.exitingFlag {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]*Conn)
+	}
+	s.conns[nc] = nil
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(nc net.Conn) {
+	s.connsMu.Lock()
+	if conn := s.conns[nc]; conn != nil {
+		delete(s.connsByID, conn.id)
+	}
+	delete(s.conns, nc)
+	s.connsMu.Unlock()
+	s.releaseSlot()
+	s.wg.Done()
+}
+
+// registerConn attaches the Conn wrapper to the tracked connection
+// nc, so that it can be reached by Broadcast.
+func (s *Server) registerConn(nc net.Conn, conn *Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if _, ok := s.conns[nc]; ok {
+		s.conns[nc] = conn
+		if s.connsByID == nil {
+			s.connsByID = make(map[string]*Conn)
+		}
+		s.connsByID[conn.id] = conn
+	}
+}
+
+// Conn returns the connection being served by its id, or nil if
+// not found.
+func (s *Server) Conn(id string) *Conn {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return s.connsByID[id]
+}
+
+// Conns returns the snapshot of all the connections being served.
+func (s *Server) Conns() (infos []ConnInfo) {
+	conns := s.snapshotConns()
+	infos = make([]ConnInfo, 0, len(conns))
+	for _, conn := range conns {
+		infos = append(infos, conn.Info())
+	}
+	return
+}
+
+// CloseConn closes the connection by its id.
+func (s *Server) CloseConn(id string) (err error) {
+	conn := s.Conn(id)
+	if conn == nil {
+		return ErrConnNotFound
+	}
+	return conn.Close()
+}
+
+// Broadcast puts msg into the outbound queue (see Conn.Send) of
+// every connection. The connections whose queues are full are
+// skipped, and the count of them returned.
+func (s *Server) Broadcast(msg []byte) (skipped int) {
+	return s.BroadcastFunc(func(connID string) ([]byte, bool) {
+		return msg, true
+	})
+}
+
+// BroadcastFunc is like Broadcast, but asks fn for the message to
+// each connection, which is skipped if fn returns false.
+func (s *Server) BroadcastFunc(fn func(connID string) (msg []byte, ok bool)) (skipped int) {
+	for _, conn := range s.snapshotConns() {
+		if msg, ok := fn(conn.ID()); ok {
+			if err := conn.Send(msg); err != nil {
+				skipped++
+			}
+		}
+	}
+	return
+}
+
+// snapshotConns returns the connections being served, so that they
+// can be iterated without holding connsMu.
+func (s *Server) snapshotConns() (conns []*Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	conns = make([]*Conn, 0, len(s.conns))
+	for _, conn := range s.conns {
+		if conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	return
 }
 
+// ActiveConnections returns the count of connections being served.
+func (s *Server) ActiveConnections() int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return len(s.conns)
+}
+
+// ReadBufferPool returns the pool specified by
+// WithServerReadBufferPool, or nil.
+func (s *Server) ReadBufferPool() *BufferPool {
+	return s.readPool
+}
+
+// acquireSlot takes a slot for a new connection, false returned if
+// the limit is reached (OverLimitReject) or the server is stopping.
+func (s *Server) acquireSlot(done <-chan struct{}) bool {
+	if s.slots == nil {
+		return true
+	}
+	if s.overLimit == OverLimitWait {
+		select {
+		case s.slots <- struct{}{}:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// Close stops accepting new connections immediately, and cancels
+// the contexts of the active connections, which are left open. It's
+// safe to be called concurrently, such as by Stop and Run, only the
+// first call since Start does the work.
 func (s *Server) Close() (err error) {
+	s.connsMu.Lock()
 	s.exitingFlag = true
+	done := s.done
+	s.done = nil
+	s.connsMu.Unlock()
+	if done == nil {
+		return // closed already
+	}
 
-	if s.l != nil {
-		if err = s.l.Close(); err != nil {
-			s.Errorf("closing s.listener: %v", err)
-		}
+	if s.cancel != nil {
+		s.cancel()
 	}
 
-	if s.done != nil {
-		close(s.done)
-		s.done = nil
+	for _, ln := range s.ls {
+		if e := ln.Close(); e != nil {
+			s.Errorf("closing the listener on %v: %v", ln.addr, e)
+			err = e
+		}
 	}
 
+	close(done)
+	s.stopHealthProbe()
+
 	// if s.conn != nil {
 	// 	if err := s.conn.Close(); err != nil {
 	// 		s.Wrong(err, "closing s.conn")
@@ -164,7 +764,11 @@ func (s *Server) Close() (err error) {
 	return
 }
 
-func (s *Server) runLoop(l net.Listener, done <-chan struct{}) {
+// runLoop accepts the connections from ln until it's closed, each
+// listener has its own one.
+func (s *Server) runLoop(ln *listener, done <-chan struct{}) {
+	defer s.loops.Done()
+
 	// timer := time.NewTicker(10 * time.Second)
 	// defer func() {
 	// 	timer.Stop()
@@ -172,9 +776,12 @@ func (s *Server) runLoop(l net.Listener, done <-chan struct{}) {
 	// }()
 
 	if s.onTcpServerListening != nil {
-		s.onTcpServerListening(s, l)
+		s.onTcpServerListening(s, ln.Listener)
 	}
 
+	var delay time.Duration // the backoff after Accept failed, 0 once succeeded
+	var loggedAt time.Time
+	var suppressed int
 	for {
 		// select {
 		// case <-done:
@@ -182,36 +789,260 @@ func (s *Server) runLoop(l net.Listener, done <-chan struct{}) {
 		// case tick := <-timer.C:
 		// 	s.Debug("tick at %v", tick)
 
-		conn, err := l.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
-			if s.exitingFlag {
+			if s.exiting() {
 				return
 			}
-			if neterr, ok := err.(net.Error); ok && (neterr.Temporary() || neterr.Timeout()) {
-				s.Warnf("network error (temporary, or timeout), sleep 5ms and retry...: %v", neterr)
-				time.Sleep(5 * time.Millisecond)
-				continue
+			atomic.AddUint64(&s.acceptErrors, 1)
+			temporary := isTemporary(err)
+			err = &OpError{Op: ErrAccept, Addr: ln.Addr().String(), Err: err}
+			if errors.Is(err, net.ErrClosed) {
+				s.Errorf("error accepting: %v", err)
+				s.connsMu.Lock()
+				if s.acceptErr == nil {
+					s.acceptErr = err // closed by others, nothing to accept anymore
+				}
+				s.connsMu.Unlock()
+				return
+			}
+
+			if delay *= 2; delay < s.acceptBackoffMin {
+				delay = s.acceptBackoffMin
+			} else if delay > s.acceptBackoffMax {
+				delay = s.acceptBackoffMax
+			}
+			if now := time.Now(); now.Sub(loggedAt) >= acceptLogInterval {
+				if temporary {
+					s.Warnf("error accepting (temporary), retrying in %v (%d errors suppressed): %v", delay, suppressed, err)
+				} else {
+					s.Errorf("error accepting, retrying in %v (%d errors suppressed): %v", delay, suppressed, err)
+				}
+				loggedAt, suppressed = now, 0
+			} else {
+				suppressed++
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
 			}
-			s.Errorf("error accepting: %v", err)
-			time.Sleep(5 * time.Millisecond)
 			continue // os.Exit(1)
 		}
+		delay = 0
 
+		atomic.AddUint64(&s.accepted, 1)
 		ts := time.Now().UTC()
+		if ln.proxyL == nil && !s.filterAccepted(conn.RemoteAddr()) {
+			_ = conn.Close()
+			continue
+		}
 		// logs an incoming message
 		s.Debugf("received message %s -> %s \n", conn.RemoteAddr(), conn.LocalAddr())
 		// Handle connections in a new goroutine.
-		go s.handleRequest(conn, ts, done)
+		if !s.acquireSlot(done) {
+			s.Warnf("conn(from: %v) rejected, too many connections", conn.RemoteAddr())
+			_ = conn.Close()
+			continue
+		}
+		if !s.trackConn(conn) {
+			s.releaseSlot()
+			_ = conn.Close()
+			return
+		}
+		raw := ln.tuningL.take()
+		var pc *proxyConn
+		if ln.proxyL != nil {
+			pc = ln.proxyL.take()
+		}
+		go s.handleRequest(ln, conn, raw, pc, ts, done)
 		// }
 	}
 }
 
-func (s *Server) handleRequest(conn net.Conn, tsConnected time.Time, done <-chan struct{}) {
+// filterAccepted reports whether the connection from remote passes
+// WithServerAcceptFilter, and counts the rejected ones.
+func (s *Server) filterAccepted(remote net.Addr) bool {
+	if s.acceptFilter == nil || s.acceptFilter(remote) {
+		return true
+	}
+	atomic.AddUint64(&s.filtered, 1)
+	s.Debugf("conn(from: %v) rejected by the accept filter", remote)
+	return false
+}
+
+// isTemporary reports whether the error of Accept is transient, such
+// as running out of the file descriptors, so that accepting again
+// after a while may succeed.
+func isTemporary(err error) bool {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && (ne.Temporary() || ne.Timeout())
+}
+
+// AuthStats returns how many connections were accepted and rejected
+// by the authenticator.
+func (s *Server) AuthStats() (accepted, rejected uint64) {
+	return atomic.LoadUint64(&s.authAccepted), atomic.LoadUint64(&s.authRejected)
+}
+
+// ServerStats is the snapshot of the counters of a server, see
+// Server.Stats.
+type ServerStats struct {
+	Accepted          uint64 // the connections accepted in total
+	Active            int    // the connections being served
+	AcceptErrors      uint64 // the failures of accepting, not including the rejected connections
+	BytesRead         uint64
+	BytesWritten      uint64
+	PanicsRecovered   uint64 // the panics of handlers recovered
+	FrameTimeouts     uint64 // the connections closed by WithServerFrameReadTimeout
+	HandshakeTimeouts uint64 // the connections closed by WithServerTLSHandshakeTimeout
+	Filtered          uint64 // the connections rejected by WithServerAcceptFilter
+	MemoryBudget      int64  // the bytes of WithServerMemoryBudget, 0 for no budget
+	MemoryUsed        int64  // the bytes of the budget in use
+	MemoryWaits       uint64 // the times of waiting for the budget
+}
+
+// Stats returns the counters of the server, so that it can be
+// monitored without instrumenting the handlers.
+func (s *Server) Stats() ServerStats {
+	budget, used, waits := s.budget.stats()
+	return ServerStats{
+		Accepted:          atomic.LoadUint64(&s.accepted),
+		Active:            s.ActiveConnections(),
+		AcceptErrors:      atomic.LoadUint64(&s.acceptErrors),
+		BytesRead:         atomic.LoadUint64(&s.bytesRead),
+		BytesWritten:      atomic.LoadUint64(&s.bytesWritten),
+		PanicsRecovered:   atomic.LoadUint64(&s.panics),
+		FrameTimeouts:     atomic.LoadUint64(&s.frameTimeouts),
+		HandshakeTimeouts: atomic.LoadUint64(&s.handshakeTimeouts),
+		Filtered:          atomic.LoadUint64(&s.filtered),
+		MemoryBudget:      budget,
+		MemoryUsed:        used,
+		MemoryWaits:       waits,
+	}
+}
+
+func (s *Server) authenticate(conn *Conn) (err error) {
+	if s.authTimeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(s.authTimeout)); err != nil {
+			return
+		}
+	}
+
+	conn.identity, err = s.onTcpServerAuthenticate(conn)
+
+	if s.authTimeout > 0 && err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		atomic.AddUint64(&s.authRejected, 1)
+		return
+	}
+	atomic.AddUint64(&s.authAccepted, 1)
+	return
+}
+
+// handshake completes the TLS handshake of a connection accepted
+// from a TLS listener, so that the failure can be reported before
+// any callback is invoked.
+func (s *Server) handshake(tc *tls2.Conn) (err error) {
+	if s.tlsHandshakeTimeout > 0 {
+		if err = tc.SetDeadline(time.Now().Add(s.tlsHandshakeTimeout)); err != nil {
+			return
+		}
+	}
+
+	err = tc.Handshake()
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		atomic.AddUint64(&s.handshakeTimeouts, 1)
+	} else if s.tlsHandshakeTimeout > 0 && err == nil {
+		err = tc.SetDeadline(time.Time{})
+	}
+	return
+}
+
+// readProxyHeader reads the PROXY header from the underlying
+// connection pc, before the TLS handshake if any.
+func (s *Server) readProxyHeader(pc *proxyConn) (err error) {
+	if s.proxyHeaderTimeout > 0 {
+		if err = pc.SetDeadline(time.Now().Add(s.proxyHeaderTimeout)); err != nil {
+			return
+		}
+	}
+
+	err = pc.readHeader()
+
+	if s.proxyHeaderTimeout > 0 && err == nil {
+		err = pc.SetDeadline(time.Time{})
+	}
+	return
+}
+
+func (s *Server) handleRequest(ln *listener, nc, raw net.Conn, pc *proxyConn, tsConnected time.Time, done <-chan struct{}) {
+	defer s.untrackConn(nc)
+
+	if pc != nil {
+		if err := s.readProxyHeader(pc); err != nil {
+			err = &OpError{Op: ErrHandshake, Addr: nc.RemoteAddr().String(), Err: err}
+			s.Warnf("conn(from: %v) reading PROXY header failed, closing: %v", nc.RemoteAddr(), err)
+			_ = nc.Close()
+			return
+		}
+		if !s.filterAccepted(pc.RemoteAddr()) {
+			_ = nc.Close()
+			return
+		}
+	}
+
+	var peerCert *x509.Certificate
+	var protocol string
+	tc, _ := nc.(*tls2.Conn)
+	if tc != nil {
+		if err := s.handshake(tc); err != nil {
+			err = &OpError{Op: ErrHandshake, Addr: nc.RemoteAddr().String(), Err: err}
+			s.Errorf("conn(from: %v) TLS handshake failed, closing: %v", nc.RemoteAddr(), err)
+			_ = nc.Close()
+			return
+		}
+		state := tc.ConnectionState()
+		if certs := state.PeerCertificates; len(certs) > 0 {
+			peerCert = certs[0]
+		}
+		protocol = state.NegotiatedProtocol
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	sc := nc
+	if s.compression != nil {
+		sc = newCompressedConn(nc, s.compression)
+	}
+	conn := newConn(s, sc, tsConnected)
+	ctx = context.WithValue(ctx, connKey{}, conn)
+	conn.ctx = ctx
+	conn.raw, conn.tlsConn = raw, tc
+	conn.listenAddr = ln.Addr()
+	conn.peerCert = peerCert
+	conn.protocol = protocol
+	if pc != nil {
+		conn.proxySrc, conn.proxyDst = pc.src, pc.dst
+	}
+	s.registerConn(nc, conn)
 	var reader io.Reader
 	var writer io.Writer
+	var exitErr error // why the connection terminated, nil for EOF
 	defer func() {
+		if writer != nil {
+			_ = flushIdle(nil, writer) // don't strand the pending data
+		}
+		conn.finishSending()
 		if err := conn.Close(); err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") {
+			if errors.Is(err, net.ErrClosed) {
 				s.Tracef("conn(from %v) closed by others.", conn.RemoteAddr())
 			} else {
 				s.Warnf("conn.close failed: %v", err)
@@ -220,34 +1051,85 @@ func (s *Server) handleRequest(conn net.Conn, tsConnected time.Time, done <-chan
 		if s.onTcpServerDisconnectedWithClient != nil {
 			s.onTcpServerDisconnectedWithClient(s, conn, reader)
 		}
+		if s.onDisconnect != nil {
+			s.onDisconnect(conn.Info(), exitErr)
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			exitErr = s.recovered(conn, r)
+		}
 	}()
 
+	if s.onConnect != nil {
+		s.onConnect(conn.Info())
+	}
 	if s.onTcpServerConnectedWithClient != nil {
 		s.onTcpServerConnectedWithClient(s, conn)
 	}
 
+	if s.onTcpServerAuthenticate != nil {
+		if exitErr = s.authenticate(conn); exitErr != nil {
+			s.Warnf("conn(from: %v) authenticating failed, closing: %v", conn.RemoteAddr(), exitErr)
+			return
+		}
+	}
+
 	// ctx, cancel := context.WithCancel(context.Background())
 	// reader := bufio.NewReader(conn)
 	// writer := bufio.NewWriter(conn)
+	if cost := s.readBufferCost(); s.budget != nil {
+		if exitErr = s.budget.acquire(ctx, cost); exitErr != nil {
+			s.Warnf("conn(from: %v) no memory budget for %v bytes, closing: %v", conn.RemoteAddr(), cost, exitErr)
+			return
+		}
+		defer s.budget.release(cost)
+	}
+
+	atomic.StoreInt32(&conn.deadlines, 1)
 	reader, writer = s.onTcpServerCreateReadWriter(s, conn, tsConnected)
 
 	// ctxHolder, hasProcess := reader.(mqtt.Contextual)
-	cidHolder, hasProcess := reader.(interface{ GetClientID() string })
-	_, hasProcess = reader.(Processor)
+	cidHolder, ok := reader.(interface{ GetClientID() string })
+	if !ok {
+		cidHolder = noClientID{}
+	}
+	_, hasProcess := reader.(Processor)
+
+	if s.codec != nil {
+		exitErr = s.serveMessages(ctx, conn, reader, writer, cidHolder.GetClientID())
+		return
+	}
 
-	buf := make([]byte, s.bufferSize)
+	var buf []byte
+	if s.readPool != nil {
+		buf = s.readPool.Get()
+		defer s.readPool.Put(buf)
+	} else {
+		buf = make([]byte, s.bufferSize)
+	}
 	var nn int
 	for {
+		if err := flushIdle(reader, writer); err != nil {
+			exitErr = err
+			s.Errorf("♦︎︎ conn(from: %v) flushing failed. closing '%v': %v", conn.RemoteAddr(), cidHolder.GetClientID(), err)
+			return
+		}
 		n, err := reader.Read(buf)
 		if err != nil {
 			if err == io.EOF {
 				s.Debugf("♦︎ conn(from: %v) read i/o eof found. closing '%v'", conn.RemoteAddr(), cidHolder.GetClientID())
 			} else {
+				exitErr = classifyReset(err, conn.RemoteAddr())
 				if n > 0 {
-					nn, _ = s.onTcpProcess(buf[:n], reader, writer)
+					nn, _ = s.onTcpProcess(ctx, buf[:n], reader, writer)
 				}
-				if strings.Contains(err.Error(), "use of closed network connection") {
+				if errors.Is(err, net.ErrClosed) {
 					s.Tracef("♦︎ conn(from %v) closed by others.", conn.RemoteAddr())
+				} else if exitErr != err {
+					s.Debugf("♦︎ conn(from: %v) reset by peer. closing '%v'", conn.RemoteAddr(), cidHolder.GetClientID())
+				} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					s.Debugf("♦︎ conn(from: %v) timed out (last active at %v). closing '%v'", conn.RemoteAddr(), conn.LastActive(), cidHolder.GetClientID())
 				} else {
 					s.Errorf("♦︎︎ conn(from: %v) reader.read(buf) failed. closing '%v': %v", conn.RemoteAddr(), cidHolder.GetClientID(), err)
 				}
@@ -270,8 +1152,9 @@ func (s *Server) handleRequest(conn net.Conn, tsConnected time.Time, done <-chan
 		// 进行解码操作。
 
 		// s.Debug("onTcpProcess processing %v bytes (%v, '%v')", nn, buf[:nn], string(buf[:nn]))
-		nn, err = s.onTcpProcess(buf[:n], reader, writer)
+		nn, err = s.onTcpProcess(ctx, buf[:n], reader, writer)
 		if err != nil {
+			exitErr = err
 			s.Errorf("onTcpProcess(buf, wr) failed. conn(from: %v), nn=%v. closing '%v': %v", conn.RemoteAddr(), nn, cidHolder.GetClientID(), err)
 			return
 		}
@@ -283,7 +1166,153 @@ func (s *Server) handleRequest(conn net.Conn, tsConnected time.Time, done <-chan
 	}
 }
 
-func (s *Server) defaultProcess(buf []byte, in io.Reader, out io.Writer) (nn int, err error) {
+// readBufferCost returns the bytes charged to the memory budget for
+// the read buffer of a connection.
+func (s *Server) readBufferCost() int {
+	if s.readPool != nil {
+		return s.readPool.Size()
+	}
+	return s.bufferSize
+}
+
+// recovered handles the panic r of the handler of conn, the
+// connection will be closed by the caller.
+func (s *Server) recovered(conn *Conn, r interface{}) error {
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	atomic.AddUint64(&s.panics, 1)
+	s.Errorf("conn(from: %v) handler panicked, closing: %v\n%s", conn.RemoteAddr(), r, err.Stack)
+	if s.onPanic != nil {
+		s.onPanic(conn.ID(), r)
+	}
+	return err
+}
+
+// serveMessages decodes the messages from reader one by one, and
+// hands them over to onTcpMessage. The error terminated the
+// connection is returned, or nil for EOF.
+func (s *Server) serveMessages(ctx context.Context, conn *Conn, reader io.Reader, writer io.Writer, cid string) (err error) {
+	out := conn.newMessageWriter(writer)
+	if _, ok := writer.(*BufferedWriter); ok && s.flushInterval > 0 {
+		out.coalesce = true
+	}
+
+	if s.wsUpgrade {
+		br, ok := reader.(*bufio.Reader)
+		if !ok {
+			br = bufio.NewReader(reader)
+			reader = br
+		}
+		if _, err = UpgradeWebSocket(br, writer); err != nil {
+			err = &OpError{Op: ErrHandshake, Addr: conn.RemoteAddr().String(), Err: err}
+			s.Warnf("♦︎ conn(from: %v) websocket upgrading failed. closing '%v': %v", conn.RemoteAddr(), cid, err)
+			return
+		}
+	}
+
+	var hb *heartbeat
+	if s.hbInterval > 0 {
+		hb = newHeartbeat(s.hbInterval)
+		go hb.run(ctx.Done(), func() error {
+			return out.WriteMessage(s.hbPing)
+		}, func() {
+			s.Debugf("♦︎ conn(from: %v) heartbeat lost. closing '%v'", conn.RemoteAddr(), cid)
+			_ = conn.Close()
+		})
+	}
+
+	var buf []byte
+	if s.readPool != nil {
+		buf = s.readPool.Get()
+		defer func() { s.readPool.Put(buf) }()
+	}
+	codec, _ := conn.codecSwitched()
+	decode := s.decoder(codec, out, buf)
+
+	for {
+		if codec, switched := conn.codecSwitched(); switched {
+			decode = s.decoder(codec, out, buf) // see Conn.SetCodec
+		}
+		if out.coalesce {
+			if err = flushIdle(reader, writer); err != nil {
+				s.Errorf("♦︎︎ conn(from: %v) flushing failed. closing '%v': %v", conn.RemoteAddr(), cid, err)
+				return
+			}
+		}
+		var msg []byte
+		if s.frameTimeout > 0 {
+			conn.beginFrame(reader)
+		}
+		msg, err = decode(reader)
+		timedOut := conn.endFrame()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && timedOut {
+				atomic.AddUint64(&s.frameTimeouts, 1)
+				s.Warnf("♦︎ conn(from: %v) didn't complete a message in %v. closing '%v'", conn.RemoteAddr(), s.frameTimeout, cid)
+				err = ErrFrameTimeout
+			} else if err == io.EOF {
+				s.Debugf("♦︎ conn(from: %v) read i/o eof found. closing '%v'", conn.RemoteAddr(), cid)
+				err = nil
+			} else if errors.Is(err, net.ErrClosed) {
+				s.Tracef("♦︎ conn(from %v) closed by others.", conn.RemoteAddr())
+			} else if IsConnReset(err) {
+				s.Debugf("♦︎ conn(from: %v) reset by peer. closing '%v'", conn.RemoteAddr(), cid)
+				err = classifyReset(err, conn.RemoteAddr())
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.Debugf("♦︎ conn(from: %v) timed out (last active at %v). closing '%v'", conn.RemoteAddr(), conn.LastActive(), cid)
+			} else {
+				s.Errorf("♦︎︎ conn(from: %v) decoding message failed. closing '%v': %v", conn.RemoteAddr(), cid, err)
+			}
+			return
+		}
+
+		if hb != nil {
+			hb.seen()
+			if bytes.Equal(msg, s.hbPing) {
+				if err = out.WriteMessage(s.hbPong); err != nil {
+					s.Errorf("♦︎︎ conn(from: %v) writing pong failed. closing '%v': %v", conn.RemoteAddr(), cid, err)
+					return
+				}
+				continue
+			}
+			if bytes.Equal(msg, s.hbPong) {
+				continue
+			}
+		}
+
+		if s.onTcpMessage == nil {
+			continue
+		}
+		if err = s.onTcpMessage(ctx, msg, out); err != nil {
+			s.Errorf("onTcpMessage(msg, out) failed. conn(from: %v). closing '%v': %v", conn.RemoteAddr(), cid, err)
+			return
+		}
+	}
+}
+
+// decoder returns the func decoding a message with codec, into buf
+// if it's not nil and codec is a BufferedDecoder.
+func (s *Server) decoder(codec Codec, out *messageWriter, buf []byte) func(r io.Reader) ([]byte, error) {
+	if ws, ok := codec.(*WebSocketCodec); ok {
+		return func(r io.Reader) ([]byte, error) {
+			return ws.decode(r, func(op byte, payload []byte) error {
+				return out.writeFrame(appendWsFrame(nil, op, payload))
+			})
+		}
+	}
+	if bd, ok := codec.(BufferedDecoder); ok && buf != nil {
+		return func(r io.Reader) ([]byte, error) {
+			return bd.DecodeBuffer(r, buf)
+		}
+	}
+	return codec.Decode
+}
+
+// noClientID stands for the reader which doesn't know the client id.
+type noClientID struct{}
+
+func (noClientID) GetClientID() string { return "" }
+
+func (s *Server) defaultProcess(ctx context.Context, buf []byte, in io.Reader, out io.Writer) (nn int, err error) {
 	// nn, err = out.Write(buf)
 	return
 }
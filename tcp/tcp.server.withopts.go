@@ -5,9 +5,13 @@
 package tcp
 
 import (
+	tls2 "crypto/tls"
+	"crypto/x509"
 	"github.com/hedzr/go-socketlib/tcp/tls"
 	"github.com/hedzr/log"
 	log2 "log"
+	"net"
+	"time"
 )
 
 func WithServerOnProcessFunc(onProcess OnTcpServerProcessFunc) ServerOpt {
@@ -16,6 +20,370 @@ func WithServerOnProcessFunc(onProcess OnTcpServerProcessFunc) ServerOpt {
 	}
 }
 
+// WithServerCodec frames the stream of each connection into the
+// messages with codec, such as NewLengthPrefixedCodec(4, 0). The
+// messages are handled by the function specified with
+// WithServerOnMessageFunc, and WithServerOnProcessFunc is ignored.
+func WithServerCodec(codec Codec) ServerOpt {
+	return func(server *Server) {
+		server.codec = codec
+	}
+}
+
+// WithServerWebSocket speaks WebSocket on each connection: the HTTP
+// upgrade handshake is accepted first (see UpgradeWebSocket), and
+// then the messages are framed by codec, such as
+// NewWebSocketCodec(0, true). The messages are handled by the
+// function specified with WithServerOnMessageFunc.
+func WithServerWebSocket(codec *WebSocketCodec) ServerOpt {
+	return func(server *Server) {
+		server.codec = codec
+		server.wsUpgrade = true
+	}
+}
+
+// WithServerMaxMessageSize limits the messages decoded and encoded
+// by the codec of WithServerCodec or WithServerWebSocket to n bytes,
+// overriding the maxSize given to its constructor, so that one
+// setting covers whichever framing is in use. A larger message is
+// rejected with ErrMessageTooLarge before anything is allocated for
+// it, and the connection is closed. The builtin codecs default to
+// DefaultMaxMessageSize; a custom Codec has to enforce its own limit.
+func WithServerMaxMessageSize(n int) ServerOpt {
+	return func(server *Server) {
+		if n <= 0 {
+			log2.Panicf("wrong max message size: %v", n)
+		}
+		server.maxMessageSize = n
+	}
+}
+
+// WithServerCompression compresses all bytes on each connection
+// with algo, such as CompressionGzip, after the TLS handshake (and
+// the PROXY header) if any. The clients must be configured with the
+// same algorithm, see WithClientCompression.
+func WithServerCompression(algo Compression) ServerOpt {
+	return func(server *Server) {
+		server.compression = algo
+	}
+}
+
+// WithServerOnMessageFunc sets the handler of the messages decoded
+// by the codec specified with WithServerCodec.
+func WithServerOnMessageFunc(onMessage OnTcpServerMessageFunc) ServerOpt {
+	return func(server *Server) {
+		server.onTcpMessage = onMessage
+	}
+}
+
+// WithServerHeartbeat sends ping to a connection which is silent
+// for interval, and closes it if nothing (pong or the other
+// messages) arrives within another interval. The ping from the peer
+// is answered with pong.
+//
+// The heartbeat works on the messages of the codec specified by
+// WithServerCodec, the messages equal to ping or pong are consumed
+// and never passed to the handler. It's ignored if no codec.
+//
+// Since the ping/pong frames count as the activities, a connection
+// answering pings is never reaped by WithServerIdleTimeout. To
+// close the connections idle at the application level, do it in
+// the message handler.
+func WithServerHeartbeat(interval time.Duration, ping, pong []byte) ServerOpt {
+	return func(server *Server) {
+		server.hbInterval, server.hbPing, server.hbPong = interval, ping, pong
+	}
+}
+
+// WithServerSendQueueSize sets the capacity of the outbound queue
+// of each connection, see Conn.Send. The capacity is rounded up to
+// a power of 2.
+func WithServerSendQueueSize(n int) ServerOpt {
+	return func(server *Server) {
+		server.sendQueueSize = uint32(n)
+		if n <= 0 {
+			log2.Panicf("wrong send queue size: %v", n)
+		}
+	}
+}
+
+// WithServerOnBackpressure sets the callback fired when the outbound
+// queue (see Conn.Send) of a connection reaches the high-water mark,
+// that is, the peer is falling behind, so that the application can
+// pause producing for it or shed the load. It's fired once until
+// the callback of WithServerOnBackpressureCleared.
+//
+// The callbacks are called by the goroutines sending and writing the
+// messages, they must not block.
+func WithServerOnBackpressure(fn func(connID string, queueDepth, cap uint32)) ServerOpt {
+	return func(server *Server) {
+		server.onBackpressure = fn
+	}
+}
+
+// WithServerOnBackpressureCleared sets the callback fired when the
+// outbound queue of a connection in backpressure drops to the
+// low-water mark, see WithServerOnBackpressure.
+func WithServerOnBackpressureCleared(fn func(connID string, queueDepth, cap uint32)) ServerOpt {
+	return func(server *Server) {
+		server.onBackpressureCleared = fn
+	}
+}
+
+// WithServerBackpressureWaterMarks sets the water marks of
+// WithServerOnBackpressure as the fractions of the capacity of the
+// outbound queue, DefaultHighWater and DefaultLowWater by default.
+// 0 < low < high <= 1 is required.
+func WithServerBackpressureWaterMarks(high, low float64) ServerOpt {
+	return func(server *Server) {
+		if low <= 0 || low >= high || high > 1 {
+			log2.Panicf("wrong water marks: high=%v, low=%v", high, low)
+		}
+		server.highWater, server.lowWater = high, low
+	}
+}
+
+// WithServerAcceptBackoff sets the bounds of the delay before
+// accepting again after Accept failed, such as EMFILE (too many open
+// files), so that the accept loop neither spins the CPU nor floods
+// the logs. The delay starts at min and doubles on each consecutive
+// failure up to max, DefaultAcceptBackoffMin and
+// DefaultAcceptBackoffMax by default. 0 < min <= max is required.
+func WithServerAcceptBackoff(min, max time.Duration) ServerOpt {
+	return func(server *Server) {
+		if min <= 0 || min > max {
+			log2.Panicf("wrong accept backoff: min=%v, max=%v", min, max)
+		}
+		server.acceptBackoffMin, server.acceptBackoffMax = min, max
+	}
+}
+
+// WithServerFrameReadTimeout closes the connection which doesn't
+// complete a message of WithServerCodec within d since its first
+// bytes arrived, such as a slow-loris attacker trickling the bytes
+// to hold the connection, which defeats WithServerIdleTimeout. The
+// closings are counted in ServerStats.FrameTimeouts, and reported
+// as ErrFrameTimeout to WithServerOnDisconnect.
+func WithServerFrameReadTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.frameTimeout = d
+	}
+}
+
+// WithServerTLSHandshakeTimeout closes the TLS connection which
+// doesn't complete the handshake within d since accepted, such as a
+// peer connecting but sending nothing, which would hold a goroutine
+// forever. The closings are counted in ServerStats.HandshakeTimeouts.
+// It's DefaultTLSHandshakeTimeout by default, zero means no limit.
+func WithServerTLSHandshakeTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.tlsHandshakeTimeout = d
+	}
+}
+
+// WithServerTransport listens over t instead of the default
+// TCPTransport, such as UnixTransport, PipeTransport (for the tests)
+// or the KCP transport of the subpackage kcp, addr is interpreted by
+// t then. The TLS and the other options about the connections work
+// over it as well, but
+// WithServerNetwork and WithServerReusePort are for the default
+// TCPTransport only.
+func WithServerTransport(t Transport) ServerOpt {
+	return func(server *Server) {
+		server.transport = t
+	}
+}
+
+// WithServerNoDelay controls the Nagle's algorithm of the accepted
+// connections, see net.TCPConn.SetNoDelay.
+func WithServerNoDelay(b bool) ServerOpt {
+	return func(server *Server) {
+		server.sock.noDelay = &b
+	}
+}
+
+// WithServerLinger sets SO_LINGER of the accepted connections, see
+// net.TCPConn.SetLinger. It's applied when the connection is being
+// closed, and a handler can override it with Conn.SetLinger. 0
+// discards the unsent data and resets the connection (an abortive
+// close, RST); a positive value waits up to seconds for the unsent
+// data to be acknowledged on some OSes, Linux included, blocking
+// Close meanwhile.
+//
+// In the graceful shutdown (see WithServerShutdownTimeout), the
+// connections closed after the timeout elapsed are lingered as
+// well: 0 resets them at once, losing the responses not yet sent,
+// and a positive value can make Stop return later than the timeout.
+func WithServerLinger(seconds int) ServerOpt {
+	return func(server *Server) {
+		server.sock.linger = &seconds
+	}
+}
+
+// WithServerSocketReadBuffer sets the size of the kernel receive
+// buffer of the accepted connections.
+func WithServerSocketReadBuffer(size int) ServerOpt {
+	return func(server *Server) {
+		server.sock.readBuffer = size
+	}
+}
+
+// WithServerSocketWriteBuffer sets the size of the kernel send
+// buffer of the accepted connections.
+func WithServerSocketWriteBuffer(size int) ServerOpt {
+	return func(server *Server) {
+		server.sock.writeBuffer = size
+	}
+}
+
+// WithServerReusePort sets SO_REUSEPORT on the listening socket,
+// so that several servers (in one or more processes) can listen on
+// the same address, and the kernel balances the connections among
+// them. Start() returns ErrReusePortUnsupported on the platforms
+// other than Linux and BSDs.
+func WithServerReusePort(b bool) ServerOpt {
+	return func(server *Server) {
+		server.reusePort = b
+	}
+}
+
+// WithServerReadBufferPool shares a pool of the size-bytes read
+// buffers among the connections, instead of allocating a buffer for
+// each connection. The handlers can reuse the buffers from it too,
+// see Server.ReadBufferPool.
+//
+// The buffers are used as the reading buffer passed to
+// WithServerOnProcessFunc, which overrides WithServerBufferSize, and
+// by the codecs implementing BufferedDecoder. In the latter case, a
+// message passed to WithServerOnMessageFunc is valid only until the
+// handler returns, copy it if it's kept.
+func WithServerReadBufferPool(size int) ServerOpt {
+	return func(server *Server) {
+		server.readPool = NewBufferPool(size)
+	}
+}
+
+// WithServerWriteFlushInterval makes the default writer (see
+// BufferedWriter) flush the pending data at most d after the first
+// write, instead of flushing each message of WithServerCodec at
+// once, so that the many small messages written by a handler go out
+// in fewer write syscalls. The data is flushed anyway before the
+// server waits for the next request, and before the connection
+// closes.
+//
+// A handler can flush explicitly by asserting its writer to
+// interface{ Flush() error }.
+func WithServerWriteFlushInterval(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.flushInterval = d
+	}
+}
+
+// WithServerProxyProtocol expects the PROXY protocol (v1 or v2)
+// header at the beginning of each connection, as sent by HAProxy or
+// AWS NLB, so that the real client address is reported by
+// RemoteAddr() of the connection. The header is read within the
+// duration of WithServerProxyHeaderTimeout, and the connection is
+// closed if the header is missing or malformed.
+//
+// The addresses in the header are also available from ConnInfo.
+func WithServerProxyProtocol(b bool) ServerOpt {
+	return func(server *Server) {
+		server.proxyProtocol = b
+	}
+}
+
+// WithServerProxyHeaderTimeout limits the time to read the PROXY
+// header of WithServerProxyProtocol, DefaultProxyHeaderTimeout by
+// default. Zero means no limit.
+func WithServerProxyHeaderTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.proxyHeaderTimeout = d
+	}
+}
+
+// WithServerAcceptFilter rejects the connections early, such as by
+// an IP allowlist, denylist or a connection-rate limiter: fn is
+// called with the remote address right after accepting, before the
+// TLS handshake and anything else, and the connection is closed at
+// once if it returns false. The rejected ones are counted in
+// ServerStats.Filtered.
+//
+// With WithServerProxyProtocol, fn is called on the real client
+// address after the PROXY header read instead, since the remote one
+// is the proxy's. fn is called from the accepting goroutines
+// concurrently.
+func WithServerAcceptFilter(fn func(remote net.Addr) bool) ServerOpt {
+	return func(server *Server) {
+		server.acceptFilter = fn
+	}
+}
+
+// WithServerListenAddrs makes the server listen on addrs besides the
+// addr of NewServer, such as an internal and an external interface.
+// The connections accepted from all of them share the handlers and
+// the registry, each listener has its own accept loop, so a failure
+// of one doesn't stop the others. Stop closes them all. An address
+// prefixed by "unix:" is the path of a Unix domain socket, the
+// others are listened on with the transport of the server.
+//
+// The listener accepted a connection is reported by
+// ConnInfo.ListenAddr.
+func WithServerListenAddrs(addrs ...string) ServerOpt {
+	return func(server *Server) {
+		server.addrs = append(server.addrs, addrs...)
+	}
+}
+
+// WithServerListenInheritedFD makes Start() take the listener
+// passed by the parent process through LISTEN_FDS if there is, see
+// Server.Handover and ListenWithInheritedFD. Linux and BSDs only.
+// It's for the addr of NewServer, not WithServerListenAddrs.
+func WithServerListenInheritedFD(b bool) ServerOpt {
+	return func(server *Server) {
+		server.inheritFD = b
+	}
+}
+
+// WithServerNetwork forces the address family of the listener,
+// network must be "tcp" (the default), "tcp4" or "tcp6".
+//
+// With "tcp", an address without host (such as ":8080") listens
+// on both IPv4 and IPv6 if the system supports dual-stack.
+func WithServerNetwork(network string) ServerOpt {
+	return func(server *Server) {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			server.network = network
+		default:
+			log2.Panicf("wrong network: %v", network)
+		}
+	}
+}
+
+// WithServerMemoryBudget limits the memory of the buffers of all the
+// connections to bytes, so that a flood of connections can't exhaust
+// the memory. Each connection takes its read buffer (see
+// WithServerBufferSize and WithServerReadBufferPool) from the budget
+// before serving, and waits for it without reading anything while
+// the budget is exhausted, so the backpressure goes to the peers;
+// the messages queued by Conn.Send are charged until written, and
+// shed with ErrMemoryBudget if they don't fit, or waited for by
+// Conn.SendContext. The usage is reported by Stats.
+//
+// Only the read buffer is charged for a connection itself, not its
+// write buffer, TLS state or socket buffers in the kernel, so leave
+// room for them. The buffers allocated by the handlers, the codecs
+// and the OnTcpServerCreateReadWriter aren't counted either.
+func WithServerMemoryBudget(bytes int) ServerOpt {
+	return func(server *Server) {
+		if bytes <= 0 {
+			log2.Panicf("wrong memory budget: %v", bytes)
+		}
+		server.budget = newMemBudget(int64(bytes))
+	}
+}
+
 func WithServerBufferSize(size int) ServerOpt {
 	return func(server *Server) {
 		server.bufferSize = size
@@ -49,12 +417,227 @@ func WithServerListening(fn OnTcpServerListening) ServerOpt {
 	}
 }
 
+// WithServerOnConnect sets the hook called once a connection is
+// accepted (and the TLS handshake finished), before any other
+// callback, such as WithServerConnectedWithClient and the
+// authenticator.
+func WithServerOnConnect(fn func(info ConnInfo)) ServerOpt {
+	return func(server *Server) {
+		server.onConnect = fn
+	}
+}
+
+// WithServerOnDisconnect sets the hook called exactly once when a
+// connection terminated, after the handler returned, the connection
+// closed, and WithServerDisconnectedWithClient called. err is why
+// it terminated (such as a read error, the handler's error, or the
+// closing by the server), or nil if the peer closed it normally.
+// An abrupt drop by the peer is reported as an *OpError of
+// ErrConnReset, test it with IsConnReset.
+//
+// It's called only for the connections which OnConnect has been
+// called for.
+func WithServerOnDisconnect(fn func(info ConnInfo, err error)) ServerOpt {
+	return func(server *Server) {
+		server.onDisconnect = fn
+	}
+}
+
+// WithServerPanicHandler sets the function called if the handler of
+// a connection panicked. The panic is recovered and logged anyway,
+// the connection is closed, and the hook of WithServerOnDisconnect
+// receives a *PanicError.
+func WithServerPanicHandler(fn func(connID string, r interface{})) ServerOpt {
+	return func(server *Server) {
+		server.onPanic = fn
+	}
+}
+
+// WithServerAuthenticator installs an authentication phase which
+// runs after a connection accepted and before the reading loop.
+func WithServerAuthenticator(fn OnTcpServerAuthenticate) ServerOpt {
+	return func(server *Server) {
+		server.onTcpServerAuthenticate = fn
+	}
+}
+
+// WithServerAuthTimeout limits the duration of the authentication
+// phase. Zero means no limit.
+func WithServerAuthTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.authTimeout = d
+	}
+}
+
 func WithTlsConfig(s *tls.CmdrTlsConfig) ServerOpt {
 	return func(server *Server) {
 		server.CmdrTlsConfig = s
 	}
 }
 
+// WithServerMaxConnections limits the count of connections being
+// served at the same time, see also WithServerOverLimitPolicy.
+// Zero means no limit.
+func WithServerMaxConnections(n int) ServerOpt {
+	return func(server *Server) {
+		server.slots = nil
+		if n > 0 {
+			server.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithServerOverLimitPolicy sets what to do with the new connections
+// once WithServerMaxConnections reached, the default is
+// OverLimitReject.
+func WithServerOverLimitPolicy(policy OverLimitPolicy) ServerOpt {
+	return func(server *Server) {
+		server.overLimit = policy
+	}
+}
+
+// WithServerReadTimeout limits the duration of each reading from a
+// connection, the connection is closed on timeout.
+func WithServerReadTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.readTimeout = d
+	}
+}
+
+// WithServerWriteTimeout limits the duration of each writing to a
+// connection.
+func WithServerWriteTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.writeTimeout = d
+	}
+}
+
+// WithServerIdleTimeout closes a connection if there is no reading
+// nor writing on it for d.
+func WithServerIdleTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.idleTimeout = d
+	}
+}
+
+// WithServerHealthProbe serves the TCP-level health checks, such as
+// the probes of Kubernetes, on a dedicated listener on addr, so that
+// they needn't speak the protocol of the server. A probe connection
+// sending request gets response back if the server is Ready, or is
+// closed without a reply otherwise, including the graceful shutdown
+// is draining the active connections; request may be empty to
+// answer at once on connecting:
+//
+//	tcp.WithServerHealthProbe(":8081", "PING\n", "PONG\n")
+//
+// The probe listener is closed after the active connections
+// finished.
+func WithServerHealthProbe(addr, request, response string) ServerOpt {
+	return func(server *Server) {
+		server.health = &healthProbe{addr: addr, request: []byte(request), response: []byte(response)}
+	}
+}
+
+// WithServerShutdownTimeout sets how long Stop() waits for the
+// active connections before closing them forcibly,
+// DefaultShutdownTimeout by default. 0 closes them at once, and a
+// negative d waits for them indefinitely, see StopWithTimeout.
+func WithServerShutdownTimeout(d time.Duration) ServerOpt {
+	return func(server *Server) {
+		server.shutdownTimeout = d
+	}
+}
+
+// WithServerTLS serves over TLS with config, it takes precedence
+// over WithTlsConfig.
+func WithServerTLS(config *tls2.Config) ServerOpt {
+	return func(server *Server) {
+		server.tlsConfig = config
+	}
+}
+
+// WithServerTLSMinVersion sets the minimum version of TLS accepted,
+// such as tls.VersionTLS13. It's tls.VersionTLS12 by default, TLS
+// 1.0 and 1.1 have to be enabled explicitly for the legacy clients.
+//
+// The hardening options (WithServerTLSMinVersion,
+// WithServerTLSCipherSuites and WithServerTLSModernDefaults) apply
+// over WithServerTLS and WithServerTLSFiles, not WithTlsConfig.
+func WithServerTLSMinVersion(v uint16) ServerOpt {
+	return func(server *Server) {
+		switch v {
+		case tls2.VersionTLS10, tls2.VersionTLS11, tls2.VersionTLS12, tls2.VersionTLS13:
+			server.tlsHardening.minVersion = v
+		default:
+			log2.Panicf("wrong TLS version: %#04x", v)
+		}
+	}
+}
+
+// WithServerTLSCipherSuites sets the cipher suites enabled for TLS
+// 1.0-1.2, DefaultTLSCipherSuites by default. The broken ones (RC4,
+// 3DES and the CBC ones with SHA-256) are rejected.
+func WithServerTLSCipherSuites(suites []uint16) ServerOpt {
+	return func(server *Server) {
+		for _, id := range suites {
+			if insecureCipherSuites[id] {
+				log2.Panicf("wrong cipher suite, insecure: %#04x", id)
+			}
+		}
+		server.tlsHardening.cipherSuites = suites
+	}
+}
+
+// WithServerTLSModernDefaults accepts TLS 1.3 only, with the X25519
+// and P-256 key exchanges, as the "modern" profile of Mozilla. It's
+// for the servers whose clients are all up to date, the default
+// settings (TLS 1.2 at least with DefaultTLSCipherSuites) are the
+// "intermediate" profile which fits most of the others.
+func WithServerTLSModernDefaults() ServerOpt {
+	return func(server *Server) {
+		server.tlsHardening.minVersion = tls2.VersionTLS13
+		server.tlsHardening.curves = []tls2.CurveID{tls2.X25519, tls2.CurveP256}
+	}
+}
+
+// WithServerTLSFiles serves over TLS with the PEM encoded certificate
+// and key files. The files are loaded in Start(), and a failure will
+// be returned from it.
+func WithServerTLSFiles(certFile, keyFile string) ServerOpt {
+	return func(server *Server) {
+		server.tlsCertFile, server.tlsKeyFile = certFile, keyFile
+	}
+}
+
+// WithServerClientCAs verifies the client certificates against pool
+// (mutual TLS). The client certificate is required and verified
+// unless WithServerClientAuth specifies another policy.
+//
+// The verified certificate is available from Conn.PeerCertificate().
+func WithServerClientCAs(pool *x509.CertPool) ServerOpt {
+	return func(server *Server) {
+		server.tlsClientCAs = pool
+	}
+}
+
+// WithServerClientAuth sets the policy for the TLS client
+// authentication, such as tls.RequireAndVerifyClientCert.
+func WithServerClientAuth(auth tls2.ClientAuthType) ServerOpt {
+	return func(server *Server) {
+		server.tlsClientAuth = auth
+	}
+}
+
+// WithServerALPNProtocols sets the application protocols supported
+// over TLS, in the order of preference, such as []string{"h2",
+// "my-proto"}. The protocol negotiated with a client is available
+// from Conn.NegotiatedProtocol() and ConnInfo.
+func WithServerALPNProtocols(protos []string) ServerOpt {
+	return func(server *Server) {
+		server.alpn = protos
+	}
+}
+
 //func WithLoggerConfig(config *log.LoggerConfig) ServerOpt {
 //	return func(server *Server) {
 //		server.Logger = build.New(config)
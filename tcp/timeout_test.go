@@ -0,0 +1,73 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// closedWithin reports whether the server closed c within d, a read
+// timeout of the client means it's still open.
+func closedWithin(c *lineConn, d time.Duration) bool {
+	_, err := c.recv(d)
+	var ne net.Error
+	return err != nil && !(errors.As(err, &ne) && ne.Timeout())
+}
+
+func TestReadTimeout(t *testing.T) {
+	s := startTestServer(t, append(echoLines(), WithServerReadTimeout(100*time.Millisecond))...)
+	c := newLineConn(dialTestServer(t, s))
+	start := time.Now()
+	if !closedWithin(c, time.Second) {
+		t.Fatal("a silent connection isn't closed on the read timeout")
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("closed after %v, before the read timeout", d)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := startTestServer(t, append(echoLines(), WithServerIdleTimeout(150*time.Millisecond))...)
+	c := newLineConn(dialTestServer(t, s))
+	for i := 0; i < 5; i++ {
+		c.send(t, "ping")
+		if line, err := c.recv(time.Second); err != nil || line != "ping" {
+			t.Fatalf("an active connection: %q, %v", line, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !closedWithin(c, time.Second) {
+		t.Fatal("an idle connection isn't closed")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	werr := make(chan error, 1)
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)), WithServerWriteTimeout(100*time.Millisecond),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			chunk := make([]byte, 64<<10)
+			for {
+				if _, err := ConnFromContext(ctx).Write(chunk); err != nil {
+					werr <- err
+					return err
+				}
+			}
+		}))
+	c := newLineConn(dialTestServer(t, s))
+	c.send(t, "flood") // and never read
+	select {
+	case err := <-werr:
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			t.Fatalf("writing to a stuck peer: %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing to a stuck peer isn't timed out")
+	}
+}
@@ -70,7 +70,7 @@ func (s *CmdrTlsConfig) IsServerCertValid() bool {
 }
 
 func (s *CmdrTlsConfig) IsCertValid() bool {
-	return s.Cert != "" && s.Key != ""
+	return s != nil && s.Cert != "" && s.Key != ""
 }
 
 func (s *CmdrTlsConfig) IsClientAuthValid() bool {
@@ -236,6 +236,7 @@ func (s *CmdrTlsConfig) newTlsConfig() (config *tls.Config, err error) {
 	config = &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		MinVersion:   uint16(s.MinTlsVersion),
+		NextProtos:   s.NextProtos,
 	}
 
 	// Require client certificates as needed
@@ -290,49 +291,71 @@ func (s *CmdrTlsConfig) NewTlsListener(l net.Listener) (listener net.Listener, e
 // the zero configuration; see the documentation of Config
 // for the defaults.
 func (s *CmdrTlsConfig) Dial(network, addr string) (conn net.Conn, err error) {
-	if s != nil && s.IsServerCertValid() {
-		roots := x509.NewCertPool()
+	dialer := &net.Dialer{}
+	if s != nil {
+		dialer.Timeout = s.DialTimeout
+	}
+	return s.DialWithDialer(dialer, network, addr)
+}
 
-		err = s.addCert(roots, s.ServerCert)
-		if err != nil {
-			return
-		}
-		err = s.addCert(roots, s.CaCert)
-		if err != nil {
+// DialWithDialer is like Dial but connects with dialer, so that
+// the timeout and keepalive settings of dialer take effect instead
+// of DialTimeout.
+func (s *CmdrTlsConfig) DialWithDialer(dialer *net.Dialer, network, addr string) (conn net.Conn, err error) {
+	if s != nil && s.IsServerCertValid() {
+		var cfg *tls.Config
+		if cfg, err = s.ToClientTlsConfig(); err != nil {
 			return
 		}
 
-		cfg := &tls.Config{
-			RootCAs: roots,
-		}
-
-		if s.IsClientAuthValid() {
-			var cert tls.Certificate
-			cert, err = tls.LoadX509KeyPair(s.Cert, s.Key)
-			if err != nil {
-				return
-			}
-			cfg.Certificates = []tls.Certificate{cert}
-		}
-
-		cfg.InsecureSkipVerify = s.InsecureSkipVerify
-
 		if s.logger != nil {
 			s.logger.Printf("Connecting to %s over TLS [-k=%v]...\n", addr, cfg.InsecureSkipVerify)
 		}
 
-		dialer := &net.Dialer{Timeout: s.DialTimeout}
 		// Use the tls.Config here in http.Transport.TLSClientConfig
 		conn, err = tls.DialWithDialer(dialer, network, addr, cfg)
 	} else {
-		if s.logger != nil {
+		if s != nil && s.logger != nil {
 			s.logger.Printf("Connecting to %s...\n", addr)
 		}
-		conn, err = net.DialTimeout(network, addr, s.DialTimeout)
+		conn, err = dialer.Dial(network, addr)
 	}
 	return
 }
 
+// ToClientTlsConfig returns the tls.Config to connect to the server,
+// nil if the server certificate isn't specified (see
+// IsServerCertValid), which means the plain connection.
+func (s *CmdrTlsConfig) ToClientTlsConfig() (cfg *tls.Config, err error) {
+	if s == nil || !s.IsServerCertValid() {
+		return
+	}
+
+	roots := x509.NewCertPool()
+	if err = s.addCert(roots, s.ServerCert); err != nil {
+		return
+	}
+	if err = s.addCert(roots, s.CaCert); err != nil {
+		return
+	}
+
+	cfg = &tls.Config{
+		RootCAs:    roots,
+		NextProtos: s.NextProtos,
+	}
+
+	if s.IsClientAuthValid() {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(s.Cert, s.Key); err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	cfg.InsecureSkipVerify = s.InsecureSkipVerify
+	return
+}
+
 func (s *CmdrTlsConfig) addCert(roots *x509.CertPool, certPath string) (err error) {
 	if certPath != "" {
 		var rootPEM []byte
@@ -22,6 +22,7 @@ type CmdrTlsConfig struct {
 	InsecureSkipVerify bool          // client-side only
 	MinTlsVersion      VersionTLS    // Both
 	DialTimeout        time.Duration // for dialing
+	NextProtos         []string      // Both: the ALPN protocols, in the order of preference
 
 	logger log.Logger
 }
@@ -0,0 +1,291 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	tls2 "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPKI is a CA with the certificates it issued, for the TLS tests.
+type testPKI struct {
+	pool   *x509.CertPool
+	server tls2.Certificate // for 127.0.0.1 and "localhost"
+	client tls2.Certificate // CommonName "client"
+
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{pool: x509.NewCertPool()}
+	p.caKey, p.caCert = p.issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+	p.pool.AddCert(p.caCert)
+	p.server = p.certificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	p.client = p.certificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return p
+}
+
+// issue signs tmpl by the CA, or by itself if there is no CA yet.
+func (p *testPKI) issue(t *testing.T, tmpl *x509.Certificate) (key *ecdsa.PrivateKey, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	parent, signer := tmpl, key
+	if p.caCert != nil {
+		parent, signer = p.caCert, p.caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func (p *testPKI) certificate(t *testing.T, tmpl *x509.Certificate) tls2.Certificate {
+	t.Helper()
+	key, cert := p.issue(t, tmpl)
+	return tls2.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+}
+
+// dialTLS connects to s over TLS with config.
+func dialTLS(t *testing.T, s *Server, config *tls2.Config) (c *tls2.Conn, err error) {
+	t.Helper()
+	d := &net.Dialer{Timeout: time.Second}
+	if c, err = tls2.DialWithDialer(d, "tcp", s.Addr().String(), config); err == nil {
+		t.Cleanup(func() { _ = c.Close() })
+	}
+	return
+}
+
+// commonNameOfPeer replies the CommonName of the client certificate.
+func commonNameOfPeer() ServerOpt {
+	return WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+		cn := "<none>"
+		if cert := ConnFromContext(ctx).PeerCertificate(); cert != nil {
+			cn = cert.Subject.CommonName
+		}
+		return out.WriteMessage([]byte(cn))
+	})
+}
+
+func TestMutualTLS(t *testing.T) {
+	p := newTestPKI(t)
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)), commonNameOfPeer(),
+		WithServerTLS(&tls2.Config{Certificates: []tls2.Certificate{p.server}}),
+		WithServerClientCAs(p.pool))
+
+	tc, err := dialTLS(t, s, &tls2.Config{RootCAs: p.pool, Certificates: []tls2.Certificate{p.client}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newLineConn(tc)
+	c.send(t, "who")
+	if line, err := c.recv(time.Second); err != nil || line != "client" {
+		t.Fatalf("PeerCertificate seen by the handler: %q, %v", line, err)
+	}
+}
+
+// TestMutualTLSRejects connects without a client certificate, and
+// with one signed by an unrelated CA, the handler must never run.
+func TestMutualTLSRejects(t *testing.T) {
+	p, other := newTestPKI(t), newTestPKI(t)
+	var served int32
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)),
+		WithServerOnMessageFunc(func(ctx context.Context, msg []byte, out MessageWriter) error {
+			atomic.AddInt32(&served, 1)
+			return out.WriteMessage(msg)
+		}),
+		WithServerTLS(&tls2.Config{Certificates: []tls2.Certificate{p.server}}),
+		WithServerClientCAs(p.pool))
+
+	for _, tc := range []struct {
+		name  string
+		certs []tls2.Certificate
+	}{
+		{"no certificate", nil},
+		{"untrusted certificate", []tls2.Certificate{other.client}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := dialTLS(t, s, &tls2.Config{RootCAs: p.pool, Certificates: tc.certs})
+			if err == nil {
+				// TLS 1.3 reports the rejection on the first read
+				c := newLineConn(conn)
+				_, _ = c.Write([]byte("who\n"))
+				var line string
+				var ne net.Error
+				if line, err = c.recv(time.Second); err == nil || errors.As(err, &ne) && ne.Timeout() {
+					t.Fatalf("served without a trusted client certificate: %q, %v", line, err)
+				}
+			}
+			if n := atomic.LoadInt32(&served); n != 0 {
+				t.Fatalf("the handler ran %v times", n)
+			}
+		})
+	}
+}
+
+func TestMutualTLSOptional(t *testing.T) {
+	p := newTestPKI(t)
+	s := startTestServer(t, WithServerCodec(NewLineCodec(0)), commonNameOfPeer(),
+		WithServerTLS(&tls2.Config{Certificates: []tls2.Certificate{p.server}}),
+		WithServerClientCAs(p.pool), WithServerClientAuth(tls2.VerifyClientCertIfGiven))
+
+	tc, err := dialTLS(t, s, &tls2.Config{RootCAs: p.pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newLineConn(tc)
+	c.send(t, "who")
+	if line, err := c.recv(time.Second); err != nil || line != "<none>" {
+		t.Fatalf("PeerCertificate without a client certificate: %q, %v", line, err)
+	}
+}
+
+func TestALPN(t *testing.T) {
+	p := newTestPKI(t)
+	s := startTestServer(t, append(echoLines(),
+		WithServerTLS(&tls2.Config{Certificates: []tls2.Certificate{p.server}}),
+		WithServerALPNProtocols([]string{"h2", "my-proto"}))...)
+
+	for _, tc := range []struct {
+		offered []string
+		want    string
+	}{
+		{[]string{"other", "my-proto"}, "my-proto"},
+		{[]string{"my-proto", "h2"}, "h2"}, // the preference of the server wins
+		{nil, ""},
+	} {
+		c, err := Dial(s.Addr().String(), WithClientTLS(&tls2.Config{RootCAs: p.pool}),
+			WithClientALPNProtocols(tc.offered))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.NegotiatedProtocol(); got != tc.want {
+			t.Errorf("offering %v, the client negotiated %q, want %q", tc.offered, got, tc.want)
+		}
+		if !waitFor(time.Second, func() bool { return len(s.Conns()) == 1 }) {
+			t.Fatal("the connection isn't registered")
+		}
+		if got := s.Conns()[0].NegotiatedProtocol; got != tc.want {
+			t.Errorf("offering %v, the server negotiated %q, want %q", tc.offered, got, tc.want)
+		}
+		c.Close()
+		if !waitFor(time.Second, func() bool { return len(s.Conns()) == 0 }) {
+			t.Fatal("the connection isn't closed")
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	p := newTestPKI(t)
+	s := startTestServer(t, append(echoLines(),
+		WithServerTLS(&tls2.Config{Certificates: []tls2.Certificate{p.server}}))...)
+	addr := s.Addr().String() // 127.0.0.1, in the IP SANs of the certificate
+	trusted := WithClientTLS(&tls2.Config{RootCAs: p.pool})
+
+	for _, tc := range []struct {
+		name string
+		opts []ClientOpt
+		ok   bool
+	}{
+		{"trusted", []ClientOpt{trusted}, true},
+		{"server name", []ClientOpt{trusted, WithClientServerName("localhost")}, true},
+		{"wrong server name", []ClientOpt{trusted, WithClientServerName("example.com")}, false},
+		{"untrusted", []ClientOpt{WithClientServerName("localhost")}, false},
+		{"insecure", []ClientOpt{WithClientInsecureSkipVerify(true)}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := Dial(addr, tc.opts...)
+			if !tc.ok {
+				if err == nil {
+					c.Close()
+					t.Fatal("connected without verifying the server")
+				}
+				if !errors.Is(err, ErrDial) {
+					t.Fatalf("Dial: %v, want a *DialError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if certs := c.PeerCertificates(); len(certs) == 0 || certs[0].Subject.CommonName != "server" {
+				t.Fatalf("PeerCertificates: %v", certs)
+			}
+		})
+	}
+}
+
+// TestTLSHandshakeTimeout connects over plain TCP and sends no TLS
+// bytes, the server closes it once the handshake timeout elapsed.
+func TestTLSHandshakeTimeout(t *testing.T) {
+	p := newTestPKI(t)
+	s := startTestServer(t, append(echoLines(),
+		WithServerTLS(&tls2.Config{Certificates: []tls2.Certificate{p.server}}),
+		WithServerTLSHandshakeTimeout(100*time.Millisecond))...)
+
+	c := newLineConn(dialTestServer(t, s))
+	start := time.Now()
+	if !closedWithin(c, time.Second) {
+		t.Fatal("a silent peer isn't closed on the handshake timeout")
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("closed after %v, before the handshake timeout", d)
+	}
+	if !waitFor(time.Second, func() bool { return s.Stats().HandshakeTimeouts == 1 }) {
+		t.Fatalf("HandshakeTimeouts: %v, want 1", s.Stats().HandshakeTimeouts)
+	}
+}
+
+// TestTLSHandshakeTimeoutDefault checks that the handshake isn't
+// limited by WithServerAuthTimeout, but DefaultTLSHandshakeTimeout.
+func TestTLSHandshakeTimeoutDefault(t *testing.T) {
+	p := newTestPKI(t)
+	s := startTestServer(t, append(echoLines(),
+		WithServerTLS(&tls2.Config{Certificates: []tls2.Certificate{p.server}}),
+		WithServerAuthTimeout(50*time.Millisecond))...)
+	if s.tlsHandshakeTimeout != DefaultTLSHandshakeTimeout {
+		t.Fatalf("the handshake timeout: %v, want %v", s.tlsHandshakeTimeout, DefaultTLSHandshakeTimeout)
+	}
+
+	c := newLineConn(dialTestServer(t, s))
+	if closedWithin(c, 200*time.Millisecond) {
+		t.Fatal("the handshake is limited by the auth timeout")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	tls2 "crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// ErrInsecureTLS is the reason of Start failed if the TLS settings
+// are obviously insecure, such as SSL 3.0 or a broken cipher suite
+// enabled, see WithServerTLSCipherSuites.
+var ErrInsecureTLS = errors.New("insecure TLS configuration")
+
+// DefaultTLSCipherSuites are the cipher suites of TLS 1.2 enabled by
+// default, the ECDHE key exchanges with the AEAD ciphers only, which
+// keep the forward secrecy and are free of the CBC padding attacks.
+// The ones of TLS 1.3 aren't configurable, they are all secure.
+var DefaultTLSCipherSuites = []uint16{
+	tls2.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls2.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls2.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls2.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls2.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls2.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// insecureCipherSuites are rejected in any case: RC4 and 3DES are
+// broken, and the CBC ones with SHA-256 are open to Lucky13.
+var insecureCipherSuites = map[uint16]bool{
+	tls2.TLS_RSA_WITH_RC4_128_SHA:                true,
+	tls2.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:        true,
+	tls2.TLS_ECDHE_RSA_WITH_RC4_128_SHA:          true,
+	tls2.TLS_RSA_WITH_3DES_EDE_CBC_SHA:           true,
+	tls2.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     true,
+	tls2.TLS_RSA_WITH_AES_128_CBC_SHA256:         true,
+	tls2.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: true,
+	tls2.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   true,
+}
+
+// tlsOpts holds the TLS hardening options, which are applied over
+// the tls.Config of WithServerTLS and WithServerTLSFiles.
+type tlsOpts struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls2.CurveID
+}
+
+// apply sets the options into config, the secure defaults into the
+// fields left zero: TLS 1.2 at least, and DefaultTLSCipherSuites.
+// ErrInsecureTLS returned if config turns out to be insecure.
+func (o *tlsOpts) apply(config *tls2.Config) (err error) {
+	if o.minVersion != 0 {
+		config.MinVersion = o.minVersion
+	} else if config.MinVersion == 0 {
+		config.MinVersion = tls2.VersionTLS12
+	}
+	if len(o.cipherSuites) > 0 {
+		config.CipherSuites = o.cipherSuites
+	} else if len(config.CipherSuites) == 0 {
+		config.CipherSuites = DefaultTLSCipherSuites
+	}
+	if len(o.curves) > 0 {
+		config.CurvePreferences = o.curves
+	}
+
+	if config.MinVersion < tls2.VersionTLS10 {
+		return fmt.Errorf("%w: SSL 3.0 enabled", ErrInsecureTLS)
+	}
+	if config.MaxVersion != 0 && config.MaxVersion < config.MinVersion {
+		return fmt.Errorf("%w: max version %#04x below min version %#04x", ErrInsecureTLS, config.MaxVersion, config.MinVersion)
+	}
+	for _, id := range config.CipherSuites {
+		if insecureCipherSuites[id] {
+			return fmt.Errorf("%w: cipher suite %#04x enabled", ErrInsecureTLS, id)
+		}
+	}
+	return
+}
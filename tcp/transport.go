@@ -0,0 +1,82 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"context"
+	"net"
+)
+
+// Transport carries the connections of Server and Client, see
+// WithServerTransport and WithClientTransport, TCPTransport is used
+// by default. The accepted and dialed connections are served in the
+// same way, so the handlers don't know which transport is under
+// them.
+//
+// The TLS and compression options work over any Transport, the
+// socket options (WithServerNoDelay, ...) are for TCP only and
+// ignored by the others.
+type Transport interface {
+	// Listen announces on the local address addr.
+	Listen(addr string) (net.Listener, error)
+	// Dial connects to the address addr.
+	Dial(addr string) (net.Conn, error)
+}
+
+// TCPTransport is the default Transport, which is configured by the
+// options of Server and Client, such as WithServerNetwork,
+// WithServerReusePort and WithClientDialTimeout.
+type TCPTransport struct {
+	Network   string // tcp (by default), tcp4 or tcp6
+	ReusePort bool   // see WithServerReusePort
+	Dialer    net.Dialer
+}
+
+func (t *TCPTransport) network() string {
+	if t.Network == "" {
+		return "tcp"
+	}
+	return t.Network
+}
+
+// Listen announces on the TCP address addr, ErrReusePortUnsupported
+// returned if ReusePort is set on the OS lacking SO_REUSEPORT.
+func (t *TCPTransport) Listen(addr string) (net.Listener, error) {
+	if !t.ReusePort {
+		return net.Listen(t.network(), addr)
+	}
+	if !reusePortSupported {
+		return nil, ErrReusePortUnsupported
+	}
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(context.Background(), t.network(), addr)
+}
+
+// Dial connects to the TCP address addr with the Dialer.
+func (t *TCPTransport) Dial(addr string) (net.Conn, error) {
+	return t.Dialer.Dial(t.network(), addr)
+}
+
+// unixAddrPrefix marks the address of a Server to listen on the Unix
+// domain socket, such as "unix:/run/app.sock", see
+// WithServerListenAddrs.
+const unixAddrPrefix = "unix:"
+
+// UnixTransport carries the connections over the Unix domain socket,
+// the addr of Server and Client is the path of the socket file, which
+// is removed when the server stops.
+type UnixTransport struct {
+	Dialer net.Dialer
+}
+
+// Listen announces on the socket file addr.
+func (t *UnixTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("unix", addr)
+}
+
+// Dial connects to the socket file addr.
+func (t *UnixTransport) Dial(addr string) (net.Conn, error) {
+	return t.Dialer.Dial("unix", addr)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultUDPBufferSize is large enough for any UDP datagram.
+	DefaultUDPBufferSize = 65535
+)
+
+// OnUDPServerProcessFunc handles a datagram received from remote.
+//
+// payload is reused by the next reading, so it must be copied if
+// it will be retained after the handler returned. To respond to the
+// sender, call ss.Reply(remote, data).
+type OnUDPServerProcessFunc func(ss *UDPServer, remote *net.UDPAddr, payload []byte)
+
+// UDPServer reads the datagrams and dispatches each of them to the
+// handler specified by WithUDPServerOnProcessFunc.
+//
+// Since UDP is connectionless, there are no per-connection states
+// and callbacks like Server has.
+type UDPServer struct {
+	addr        string
+	conn        *net.UDPConn
+	exitingFlag bool
+
+	base
+
+	bufferSize   int
+	onUdpProcess OnUDPServerProcessFunc
+}
+
+func newUDPServer(addr string, opts ...UDPServerOpt) *UDPServer {
+	s := &UDPServer{
+		addr:       addr,
+		base:       newBase(nil),
+		bufferSize: DefaultUDPBufferSize,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *UDPServer) Start() (err error) {
+	s.exitingFlag = false
+
+	var addr *net.UDPAddr
+	if addr, err = net.ResolveUDPAddr("udp", s.addr); err != nil {
+		err = &OpError{Op: ErrListen, Addr: s.addr, Err: err}
+		s.Errorf("can't resolve udp addr: %v", err)
+		return
+	}
+	if s.conn, err = net.ListenUDP("udp", addr); err != nil {
+		err = &OpError{Op: ErrListen, Addr: s.addr, Err: err}
+		s.Errorf("error listening: %v", err)
+		return
+	}
+	s.Debugf("A udp server listening on %v", s.conn.LocalAddr())
+
+	go s.runLoop(s.conn)
+	return
+}
+
+func (s *UDPServer) Stop() {
+	_ = s.Close()
+}
+
+func (s *UDPServer) Close() (err error) {
+	s.exitingFlag = true
+
+	if s.conn != nil {
+		if err = s.conn.Close(); err != nil {
+			s.Errorf("closing s.conn: %v", err)
+		}
+	}
+	return
+}
+
+// LocalAddr returns the address the server is listening on, or nil
+// if it's not started.
+func (s *UDPServer) LocalAddr() net.Addr {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Reply sends data to remote, generally the sender of a datagram.
+func (s *UDPServer) Reply(remote *net.UDPAddr, data []byte) (n int, err error) {
+	return s.conn.WriteToUDP(data, remote)
+}
+
+func (s *UDPServer) runLoop(conn *net.UDPConn) {
+	buf := make([]byte, s.bufferSize)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if s.exitingFlag || strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+			if neterr, ok := err.(net.Error); ok && (neterr.Temporary() || neterr.Timeout()) {
+				s.Warnf("network error (temporary, or timeout), sleep 5ms and retry...: %v", neterr)
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			s.Errorf("error reading: %v", err)
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+
+		if s.onUdpProcess != nil {
+			s.onUdpProcess(s, remote, buf[:n])
+		}
+	}
+}
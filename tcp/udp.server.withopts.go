@@ -0,0 +1,33 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"github.com/hedzr/log"
+	log2 "log"
+)
+
+func WithUDPServerOnProcessFunc(onProcess OnUDPServerProcessFunc) UDPServerOpt {
+	return func(server *UDPServer) {
+		server.onUdpProcess = onProcess
+	}
+}
+
+// WithUDPServerBufferSize sets the size of the reading buffer, the
+// longer part of a datagram will be truncated.
+func WithUDPServerBufferSize(size int) UDPServerOpt {
+	return func(server *UDPServer) {
+		server.bufferSize = size
+		if size <= 0 {
+			log2.Panicf("wrong buffer size: %v", size)
+		}
+	}
+}
+
+func WithUDPServerLogger(l log.Logger) UDPServerOpt {
+	return func(server *UDPServer) {
+		server.Logger = l
+	}
+}
@@ -1,7 +1,7 @@
 package udp
 
 import (
-	"github.com/hedzr/go-ringbuf/fast"
+	"github.com/hedzr/go-socketlib/ringbuf"
 	"github.com/hedzr/go-socketlib/tcp/base"
 	"github.com/hedzr/go-socketlib/tcp/protocol"
 	"github.com/hedzr/log"
@@ -12,9 +12,9 @@ import (
 type Opt func(*Obj)
 
 func New(so protocol.InterceptorHolder, opts ...Opt) (obj *Obj) {
-	if x := fast.New(DefaultPacketQueueSize,
-		fast.WithDebugMode(false),
-		fast.WithLogger(so.(log.Logger)),
+	if x := ringbuf.New(DefaultPacketQueueSize,
+		ringbuf.WithDebugMode(false),
+		ringbuf.WithLogger(so.(log.Logger)),
 	); x != nil {
 		obj = &Obj{
 			Logger:            so.(log.Logger),
@@ -2,7 +2,7 @@ package udp
 
 import (
 	"context"
-	"github.com/hedzr/go-ringbuf/fast"
+	"github.com/hedzr/go-socketlib/ringbuf"
 	"github.com/hedzr/go-socketlib/tcp/base"
 	"github.com/hedzr/go-socketlib/tcp/protocol"
 	"github.com/hedzr/log"
@@ -25,7 +25,7 @@ type Obj struct {
 	conn           *net.UDPConn
 	baseConn       base.Conn
 	maxBufferSize  int
-	rb             fast.RingBuffer
+	rb             ringbuf.RingBuffer
 	rdCh           chan *base.UdpPacket
 	wrCh           chan *base.UdpPacket
 	debugMode      bool
@@ -369,7 +369,7 @@ func (s *Obj) readPump(ctx context.Context) {
 
 		it, err = s.rb.Dequeue()
 		if err != nil {
-			if err == fast.ErrQueueEmpty {
+			if err == ringbuf.ErrQueueEmpty {
 				// block till queue not empty
 				time.Sleep(time.Duration(retry) * time.Microsecond)
 				retry++
@@ -451,7 +451,7 @@ func (s *Obj) listener(baseCtx context.Context) {
 	retryPut:
 		err = s.rb.Enqueue(base.NewUdpPacket(remoteAddr, sd))
 		if err != nil {
-			if err == fast.ErrQueueFull {
+			if err == ringbuf.ErrQueueFull {
 				// block till queue not full
 				time.Sleep(time.Duration(retry) * time.Microsecond)
 				retry++
@@ -517,7 +517,7 @@ func (s *Obj) clientListener(baseCtx context.Context) {
 	retryPut:
 		err = s.rb.Enqueue(base.NewUdpPacket(remoteAddr, sd))
 		if err != nil {
-			if err == fast.ErrQueueFull {
+			if err == ringbuf.ErrQueueFull {
 				// block till queue not full
 				time.Sleep(time.Duration(retry) * time.Microsecond)
 				retry++
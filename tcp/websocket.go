@@ -0,0 +1,252 @@
+/*
+ * Copyright © 2020 Hedzr Yeh.
+ */
+
+package tcp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrWebSocketHandshake is returned by UpgradeWebSocket if the
+	// request isn't a valid WebSocket handshake.
+	ErrWebSocketHandshake = errors.New("bad websocket handshake")
+	// ErrWebSocketProtocol is returned by WebSocketCodec if a frame
+	// violates RFC 6455.
+	ErrWebSocketProtocol = errors.New("websocket protocol error")
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsMaxControlPayload = 125
+	wsGUID              = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// UpgradeWebSocket reads the HTTP request from r, and accepts it by
+// writing the 101 response to w if it's a WebSocket handshake, or
+// writes a 400 response and returns ErrWebSocketHandshake. The
+// WebSocket frames follow in r, so r must not be discarded.
+func UpgradeWebSocket(r *bufio.Reader, w io.Writer) (req *http.Request, err error) {
+	if req, err = http.ReadRequest(r); err != nil {
+		return
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	var resp string
+	if req.Method != http.MethodGet ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		err = ErrWebSocketHandshake
+		resp = "HTTP/1.1 400 Bad Request\r\nSec-WebSocket-Version: 13\r\nConnection: close\r\n\r\n"
+	} else {
+		sum := sha1.Sum([]byte(key + wsGUID))
+		resp = fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	if _, e := io.WriteString(w, resp); e != nil && err == nil {
+		err = e
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		if e := f.Flush(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// headerContains tests if the comma-separated header key has token.
+func headerContains(h http.Header, key, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketCodec frames the messages in the WebSocket protocol
+// (RFC 6455) on the server side, that is, the frames from the
+// client must be masked and the frames to it are not. See
+// WithServerWebSocket.
+//
+// The fragmented messages are reassembled. The ping frames are
+// answered with pong and the close frame is echoed, if the codec
+// is used by the server; Decode just skips the control frames and
+// returns io.EOF on the close frame.
+type WebSocketCodec struct {
+	maxSize int
+	opcode  byte
+}
+
+// NewWebSocketCodec returns a WebSocketCodec which sends the
+// messages in the text frames if text is true, or the binary
+// frames. A message (reassembled from the fragments) longer than
+// maxSize is rejected with ErrMessageTooLarge. maxSize <= 0 means
+// DefaultMaxMessageSize.
+func NewWebSocketCodec(maxSize int, text bool) *WebSocketCodec {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	c := &WebSocketCodec{maxSize: maxSize, opcode: wsOpBinary}
+	if text {
+		c.opcode = wsOpText
+	}
+	return c
+}
+
+func (c *WebSocketCodec) withMaxSize(maxSize int) Codec {
+	return &WebSocketCodec{maxSize: maxSize, opcode: c.opcode}
+}
+
+// Encode writes msg as one unmasked frame.
+func (c *WebSocketCodec) Encode(w io.Writer, msg []byte) (err error) {
+	if len(msg) > c.maxSize {
+		return ErrMessageTooLarge
+	}
+	_, err = w.Write(appendWsFrame(nil, c.opcode, msg))
+	return
+}
+
+// Decode returns the next data message.
+func (c *WebSocketCodec) Decode(r io.Reader) (msg []byte, err error) {
+	return c.decode(r, nil)
+}
+
+// decode reads the frames until a whole data message, the control
+// frames are answered through reply if it's not nil.
+func (c *WebSocketCodec) decode(r io.Reader, reply func(op byte, payload []byte) error) (msg []byte, err error) {
+	var fragmented bool
+	for {
+		var fin bool
+		var op byte
+		var payload []byte
+		if fin, op, payload, err = c.readFrame(r); err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case wsOpPing:
+			if reply != nil {
+				if err = reply(wsOpPong, payload); err != nil {
+					return nil, err
+				}
+			}
+		case wsOpPong:
+		case wsOpClose:
+			if reply != nil {
+				if len(payload) > 2 {
+					payload = payload[:2] // echo the status code only
+				}
+				_ = reply(wsOpClose, payload)
+			}
+			return nil, io.EOF
+		case wsOpContinuation:
+			if !fragmented {
+				return nil, ErrWebSocketProtocol
+			}
+			if len(msg)+len(payload) > c.maxSize {
+				return nil, ErrMessageTooLarge
+			}
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		case wsOpText, wsOpBinary:
+			if fragmented {
+				return nil, ErrWebSocketProtocol
+			}
+			if fin {
+				return payload, nil
+			}
+			msg, fragmented = payload, true
+		default:
+			return nil, ErrWebSocketProtocol
+		}
+	}
+}
+
+// readFrame reads and unmasks one frame.
+func (c *WebSocketCodec) readFrame(r io.Reader) (fin bool, op byte, payload []byte, err error) {
+	var hdr [8]byte
+	if _, err = io.ReadFull(r, hdr[:2]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	masked, size := hdr[1]&0x80 != 0, uint64(hdr[1]&0x7f)
+	if hdr[0]&0x70 != 0 || !masked {
+		err = ErrWebSocketProtocol // no extension negotiated, and the client must mask
+		return
+	}
+	if op >= wsOpClose && (!fin || size > wsMaxControlPayload) {
+		err = ErrWebSocketProtocol
+		return
+	}
+
+	switch size {
+	case 126:
+		if _, err = io.ReadFull(r, hdr[:2]); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err = io.ReadFull(r, hdr[:8]); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(hdr[:8])
+	}
+	if size > uint64(c.maxSize) {
+		err = ErrMessageTooLarge
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return
+}
+
+// appendWsFrame appends an unmasked final frame to buf.
+func appendWsFrame(buf []byte, op byte, payload []byte) []byte {
+	buf = append(buf, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 126, byte(n>>8), byte(n))
+	default:
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(n))
+		buf = append(append(buf, 127), size[:]...)
+	}
+	return append(buf, payload...)
+}